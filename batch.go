@@ -0,0 +1,266 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// AddDocumentsOptions configures AddDocuments.
+// Zero values are replaced by the defaults noted on each field.
+type AddDocumentsOptions struct {
+	// BatchSize is the largest number of segments embedded in a single call. Segments of
+	// different documents share a call. Defaults to 100.
+	BatchSize int
+	// Partial adds every document that can be added and reports the others in a *BatchError.
+	// By default, no document is added if any of them fails.
+	Partial bool
+}
+
+const defaultEmbeddingBatchSize = 100
+
+// DocumentError is the error of a single document in a batch.
+type DocumentError struct {
+	// Index is the position of the document in the batch.
+	Index int
+	ID    string
+	Err   error
+}
+
+// BatchError reports the documents of a batch that failed, in the order of the batch.
+type BatchError struct {
+	Errors []DocumentError
+}
+
+func (e *BatchError) Error() string {
+	first := e.Errors[0]
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("document %q failed: %v", first.ID, first.Err)
+	}
+	return fmt.Sprintf("%d documents failed, first %q: %v", len(e.Errors), first.ID, first.Err)
+}
+
+// Unwrap returns the errors of the documents, so that errors.Is and errors.As can match them.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, docErr := range e.Errors {
+		errs[i] = docErr.Err
+	}
+	return errs
+}
+
+// newBatchError returns a *BatchError for the failed documents of docs, by position.
+func newBatchError(docs []*Document, failed map[int]error) *BatchError {
+	batchErr := &BatchError{}
+	for i, doc := range docs {
+		err, ok := failed[i]
+		if !ok {
+			continue
+		}
+		docErr := DocumentError{Index: i, Err: err}
+		if doc != nil {
+			docErr.ID = doc.ID
+		}
+		batchErr.Errors = append(batchErr.Errors, docErr)
+	}
+	return batchErr
+}
+
+// pendingSegment is a segment of a document in a batch that is waiting for its embedding.
+type pendingSegment struct {
+	doc      int // position of the document in the batch.
+	index    int // position of the segment in the document.
+	text     string
+	metadata map[string]interface{}
+}
+
+// AddDocuments adds several documents to the collection. It splits them all into segments
+// and embeds the segments in batches that cross document boundaries, so that many small
+// documents take few calls to the embedding function.
+//
+// By default the documents are added atomically: if any of them is invalid or fails to embed,
+// none is added. Invalid documents are reported in a *BatchError. With opts.Partial, the
+// documents that succeed are added, and the others are reported in a *BatchError; a failed
+// embedding call fails every document with segments in that call.
+func (c *Collection) AddDocuments(docs []*Document, opts AddDocumentsOptions) error {
+	return c.AddDocumentsContext(context.Background(), docs, opts)
+}
+
+// AddDocumentsContext is like AddDocuments, but gives up when ctx is done.
+func (c *Collection) AddDocumentsContext(ctx context.Context, docs []*Document, opts AddDocumentsOptions) error {
+	if opts.BatchSize < 0 {
+		return errors.New("batch size must be greater than or equal to zero")
+	}
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultEmbeddingBatchSize
+	}
+
+	// Validate the documents and reserve their IDs, so that they can be embedded without
+	// holding the lock.
+	c.documentsLock.Lock()
+	failed := make(map[int]error)
+	seen := make(map[string]bool, len(docs))
+	var reserved []string
+	for i, doc := range docs {
+		switch {
+		case doc == nil:
+			failed[i] = errors.New("document is required")
+		case doc.ID == "":
+			failed[i] = errors.New("document ID is required")
+		case c.documents[doc.ID] != nil || seen[doc.ID]:
+			failed[i] = fmt.Errorf("document with ID %s already exists", doc.ID)
+		case doc.Content == "":
+			failed[i] = errors.New("document content is required")
+		default:
+			if err := c.beginWrite(doc.ID); err != nil {
+				failed[i] = err
+			} else {
+				reserved = append(reserved, doc.ID)
+			}
+		}
+		if doc != nil {
+			seen[doc.ID] = true
+		}
+	}
+	c.documentsLock.Unlock()
+
+	defer func() {
+		c.documentsLock.Lock()
+		defer c.documentsLock.Unlock()
+		for _, id := range reserved {
+			c.endWrite(id)
+		}
+	}()
+
+	if len(failed) > 0 && !opts.Partial {
+		return newBatchError(docs, failed)
+	}
+
+	// Split every valid document and queue its segments.
+	segments := make([][]*Segment, len(docs))
+	var pending []pendingSegment
+	for i, doc := range docs {
+		if _, ok := failed[i]; ok {
+			continue
+		}
+		chunks, err := c.splitChunks(doc.Content)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		segments[i] = make([]*Segment, len(chunks))
+		for j, chunk := range chunks {
+			pending = append(pending, pendingSegment{doc: i, index: j, text: chunk.Text, metadata: chunk.Metadata})
+		}
+	}
+
+	for start := 0; start < len(pending); start += opts.BatchSize {
+		var batch []pendingSegment
+		for _, segment := range pending[start:min(start+opts.BatchSize, len(pending))] {
+			// Skip the documents that failed in an earlier batch.
+			if _, ok := failed[segment.doc]; !ok {
+				batch = append(batch, segment)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := c.embedPendingSegments(ctx, batch, segments, failed); err != nil {
+			if !opts.Partial {
+				return err
+			}
+			for _, segment := range batch {
+				if _, ok := failed[segment.doc]; !ok {
+					failed[segment.doc] = err
+				}
+			}
+		}
+		if len(failed) > 0 && !opts.Partial {
+			return newBatchError(docs, failed)
+		}
+	}
+
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	// Check the dimension of every document, including segments set by the caller, against
+	// the collection and the documents before it.
+	dimension := c.dimension
+	for i, doc := range docs {
+		if _, ok := failed[i]; ok {
+			continue
+		}
+		// Keep the segments set by the caller, as AddDocument does.
+		segments[i] = append(append([]*Segment(nil), doc.Segments...), segments[i]...)
+		docDimension, err := segmentDimension(segments[i], dimension)
+		if err != nil {
+			failed[i] = err
+			continue
+		}
+		dimension = docDimension
+	}
+	if len(failed) > 0 && !opts.Partial {
+		return newBatchError(docs, failed)
+	}
+
+	var added []int
+	for i := range docs {
+		if _, ok := failed[i]; !ok {
+			added = append(added, i)
+		}
+	}
+
+	if len(added) > 0 {
+		// Build the documents to log without changing the caller's documents yet,
+		// so that nothing changes if logging fails.
+		logged := make([]*Document, len(added))
+		for j, i := range added {
+			withSegments := *docs[i]
+			withSegments.Segments = segments[i]
+			withSegments.Fingerprint = c.fingerprint(docs[i].Content)
+			logged[j] = &withSegments
+		}
+		// Log the documents in a single record, so that they survive a crash together.
+		if err := c.logMutation(walRecord{Op: walOpAddBatch, Documents: logged}); err != nil {
+			return err
+		}
+		for j, i := range added {
+			docs[i].Segments = logged[j].Segments
+			docs[i].Fingerprint = logged[j].Fingerprint
+			c.insertDocument(docs[i])
+		}
+	}
+
+	if len(failed) > 0 {
+		return newBatchError(docs, failed)
+	}
+	return nil
+}
+
+// embedPendingSegments embeds a batch of segments and stores them in segments, by document
+// and position. Documents with an embedding that cannot be normalized are marked in failed.
+// It returns an error if the batch as a whole fails.
+func (c *Collection) embedPendingSegments(ctx context.Context, batch []pendingSegment, segments [][]*Segment, failed map[int]error) error {
+	texts := make([]string, len(batch))
+	for i, segment := range batch {
+		texts[i] = segment.text
+	}
+
+	embeddings, err := c.embed(ctx, texts, c.embeddingDocumentType)
+	if err != nil {
+		return err
+	}
+
+	for i, embedding := range embeddings {
+		norm, err := normalizeVector(embedding)
+		if err != nil {
+			if _, ok := failed[batch[i].doc]; !ok {
+				failed[batch[i].doc] = err
+			}
+			continue
+		}
+		segments[batch[i].doc][batch[i].index] = &Segment{Text: texts[i], Embedding: norm, Metadata: batch[i].metadata}
+	}
+	return nil
+}
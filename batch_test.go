@@ -0,0 +1,167 @@
+package vector
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBatchTestCollection(t *testing.T, embedder Embedder) *Collection {
+	collection, err := NewCollectionWithEmbedder("test", "docType", "queryType", 4, 0, embedder)
+	require.NoError(t, err)
+	return collection
+}
+
+func TestCollection_AddDocuments(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection := newBatchTestCollection(t, embedder)
+
+	docs := []*Document{
+		{ID: "1", Content: "aaaabbbb"},
+		{ID: "2", Content: "cc"},
+		{ID: "3", Content: "ddddeeeeff"},
+	}
+	require.NoError(t, collection.AddDocuments(docs, AddDocumentsOptions{BatchSize: 4}))
+	assert.Equal(t, 3, collection.Length())
+
+	// The segments are packed into batches across documents.
+	assert.Equal(t, [][]string{{"aaaa", "bbbb", "cc", "dddd"}, {"eeee", "ff"}}, embedder.calls)
+
+	doc, ok := collection.GetDocument("3")
+	require.True(t, ok)
+	require.Len(t, doc.Segments, 3)
+	assert.Equal(t, "ff", doc.Segments[2].Text)
+	assert.Equal(t, []float64{1}, doc.Segments[2].Embedding)
+
+	results, err := collection.GetTopNSimilarDocumentsExact("query", 10)
+	require.NoError(t, err)
+	assert.Len(t, results, 6)
+
+	// The default batch size embeds everything at once.
+	embedder.calls = nil
+	require.NoError(t, collection.AddDocuments([]*Document{{ID: "4", Content: "gggg"}, {ID: "5", Content: "hhhh"}}, AddDocumentsOptions{}))
+	assert.Equal(t, [][]string{{"gggg", "hhhh"}}, embedder.calls)
+
+	assert.NoError(t, collection.AddDocuments(nil, AddDocumentsOptions{}))
+	assert.Error(t, collection.AddDocuments(nil, AddDocumentsOptions{BatchSize: -1}))
+}
+
+func TestCollection_AddDocuments_Atomic(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection := newBatchTestCollection(t, embedder)
+	require.NoError(t, collection.AddDocument(&Document{ID: "existing", Content: "content"}))
+	embedder.calls = nil
+
+	// Invalid documents are reported without embedding anything.
+	docs := []*Document{
+		{ID: "1", Content: "aaaa"},
+		{ID: "existing", Content: "bbbb"},
+		{ID: "2", Content: ""},
+		nil,
+		{ID: "1", Content: "cccc"},
+	}
+	err := collection.AddDocuments(docs, AddDocumentsOptions{})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 4)
+	assert.Equal(t, []int{1, 2, 3, 4}, []int{batchErr.Errors[0].Index, batchErr.Errors[1].Index, batchErr.Errors[2].Index, batchErr.Errors[3].Index})
+	assert.Equal(t, "existing", batchErr.Errors[0].ID)
+	assert.Equal(t, "1", batchErr.Errors[3].ID)
+	assert.Empty(t, embedder.calls)
+	assert.Equal(t, 1, collection.Length())
+
+	// A failed embedding call adds nothing.
+	embedder.errs = []error{nil, errors.New("backend down")}
+	docs = []*Document{{ID: "1", Content: "aaaa"}, {ID: "2", Content: "bbbb"}}
+	err = collection.AddDocuments(docs, AddDocumentsOptions{BatchSize: 1})
+	assert.EqualError(t, err, "backend down")
+	assert.Equal(t, 1, collection.Length())
+	assert.Empty(t, docs[0].Segments)
+}
+
+func TestCollection_AddDocuments_Partial(t *testing.T) {
+	embedder := &recordingEmbedder{errs: []error{nil, errors.New("backend down")}}
+	collection := newBatchTestCollection(t, embedder)
+
+	docs := []*Document{
+		{ID: "1", Content: "aaaa"},
+		{ID: "2", Content: ""},
+		{ID: "3", Content: "bbbbcccc"},
+		{ID: "4", Content: "dddd"},
+	}
+	err := collection.AddDocuments(docs, AddDocumentsOptions{BatchSize: 2, Partial: true})
+
+	// The second batch, "cccc" and "dddd", fails, and with it documents 3 and 4.
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 3)
+	assert.Equal(t, "2", batchErr.Errors[0].ID)
+	assert.Equal(t, "3", batchErr.Errors[1].ID)
+	assert.EqualError(t, batchErr.Errors[1].Err, "backend down")
+	assert.Equal(t, "4", batchErr.Errors[2].ID)
+
+	assert.Equal(t, 1, collection.Length())
+	_, ok := collection.GetDocument("1")
+	assert.True(t, ok)
+	assert.Empty(t, docs[2].Segments)
+}
+
+func TestCollection_AddDocuments_ZeroEmbedding(t *testing.T) {
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		embeddings := make([][]float64, len(inputs))
+		for i, input := range inputs {
+			if input == "zero" {
+				embeddings[i] = []float64{0}
+			} else {
+				embeddings[i] = []float64{1}
+			}
+		}
+		return embeddings, nil
+	}
+	collection, err := NewCollection("test", "docType", "queryType", 4, 0, embeddingFunc)
+	require.NoError(t, err)
+
+	docs := []*Document{{ID: "1", Content: "aaaa"}, {ID: "2", Content: "zero"}}
+	err = collection.AddDocuments(docs, AddDocumentsOptions{})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 0, collection.Length())
+
+	err = collection.AddDocuments(docs, AddDocumentsOptions{Partial: true})
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 1)
+	assert.Equal(t, "2", batchErr.Errors[0].ID)
+	assert.Equal(t, 1, collection.Length())
+}
+
+func TestCollection_AddDocuments_WAL(t *testing.T) {
+	walPath := filepath.Join(t.TempDir(), "collection.wal")
+	collection := newBatchTestCollection(t, &recordingEmbedder{})
+	require.NoError(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocuments([]*Document{{ID: "1", Content: "aaaa"}, {ID: "2", Content: "bbbb"}}, AddDocumentsOptions{}))
+	require.NoError(t, collection.CloseWAL())
+
+	embedder := &recordingEmbedder{}
+	recovered := newBatchTestCollection(t, embedder)
+	require.NoError(t, recovered.OpenWAL(walPath))
+	defer recovered.CloseWAL()
+
+	assert.Equal(t, 2, recovered.Length())
+	doc, ok := recovered.GetDocument("2")
+	require.True(t, ok)
+	require.Len(t, doc.Segments, 1)
+	assert.Equal(t, "bbbb", doc.Segments[0].Text)
+	assert.Empty(t, embedder.calls)
+}
+
+func TestBatchError(t *testing.T) {
+	err := &BatchError{Errors: []DocumentError{{ID: "1", Err: ErrModelNotFound}}}
+	assert.EqualError(t, err, `document "1" failed: model not found`)
+	assert.ErrorIs(t, err, ErrModelNotFound)
+
+	err.Errors = append(err.Errors, DocumentError{ID: "2", Err: errors.New("other")})
+	assert.EqualError(t, err, `2 documents failed, first "1": model not found`)
+}
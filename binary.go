@@ -0,0 +1,105 @@
+package vector
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// BinaryQuantizer is a Codec that stores one sign bit per dimension, which takes 1/64
+// of the memory of a float64 embedding. Codes are compared with the Hamming distance,
+// computed with popcount, which makes it a fast first-stage filter.
+//
+// Binary codes are coarse, so they are meant to be stored next to the full-precision
+// embeddings: enable it with QuantizationOptions.KeepEmbeddings and a RescoreFactor,
+// so that the best topN*RescoreFactor candidates by Hamming distance are rescored
+// with the dot product against the full-precision embeddings.
+type BinaryQuantizer struct{}
+
+// NewBinaryQuantizer creates a binary quantizer.
+func NewBinaryQuantizer() *BinaryQuantizer {
+	return &BinaryQuantizer{}
+}
+
+// Train does nothing, since sign bits need no training.
+func (q *BinaryQuantizer) Train(embeddings [][]float64) error {
+	return nil
+}
+
+// Encode packs the sign of every dimension into bits, one for positive values and zero otherwise.
+// The first byte of the code stores the number of unused bits in the last byte,
+// so that the dimension can be recovered.
+func (q *BinaryQuantizer) Encode(embedding []float64) ([]byte, error) {
+	if len(embedding) == 0 {
+		return nil, errors.New("embedding is empty")
+	}
+
+	code := make([]byte, 1+(len(embedding)+7)/8)
+	code[0] = byte((8 - len(embedding)%8) % 8)
+	for i, v := range embedding {
+		if v > 0 {
+			code[1+i/8] |= 1 << (i % 8)
+		}
+	}
+	return code, nil
+}
+
+// dimension returns the number of dimensions encoded in a code.
+func (q *BinaryQuantizer) dimension(code []byte) (int, error) {
+	if len(code) < 2 || code[0] > 7 {
+		return 0, errors.New("invalid binary code")
+	}
+	return 8*(len(code)-1) - int(code[0]), nil
+}
+
+// Decode reconstructs a normalized embedding with the same signs as the original,
+// where every dimension has the same magnitude.
+func (q *BinaryQuantizer) Decode(code []byte) ([]float64, error) {
+	dim, err := q.dimension(code)
+	if err != nil {
+		return nil, err
+	}
+
+	magnitude := 1 / math.Sqrt(float64(dim))
+	embedding := make([]float64, dim)
+	for i := range embedding {
+		if code[1+i/8]&(1<<(i%8)) != 0 {
+			embedding[i] = magnitude
+		} else {
+			embedding[i] = -magnitude
+		}
+	}
+	return embedding, nil
+}
+
+// hammingDistance returns the number of bits that differ between two codes of the same length.
+func hammingDistance(code1, code2 []byte) int {
+	var distance int
+	i := 0
+	for ; i+8 <= len(code1); i += 8 {
+		distance += bits.OnesCount64(binary.LittleEndian.Uint64(code1[i:]) ^ binary.LittleEndian.Uint64(code2[i:]))
+	}
+	for ; i < len(code1); i++ {
+		distance += bits.OnesCount8(code1[i] ^ code2[i])
+	}
+	return distance
+}
+
+// Scorer encodes the query and returns a function that scores a code by its Hamming distance
+// to the query code, mapped to [-1, 1]: 1 when all signs match and -1 when none do.
+func (q *BinaryQuantizer) Scorer(query []float64) (func(code []byte) float64, error) {
+	queryCode, err := q.Encode(query)
+	if err != nil {
+		return nil, err
+	}
+	queryBits := queryCode[1:]
+	dim := float64(len(query))
+
+	return func(code []byte) float64 {
+		if len(code) != len(queryCode) || code[0] != queryCode[0] {
+			return math.Inf(-1)
+		}
+		return 1 - 2*float64(hammingDistance(queryBits, code[1:]))/dim
+	}, nil
+}
@@ -0,0 +1,132 @@
+package vector
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryQuantizer(t *testing.T) {
+	q := NewBinaryQuantizer()
+
+	tests := []struct {
+		name      string
+		embedding []float64
+		code      []byte
+	}{
+		{"Full Byte", []float64{1, -1, 1, -1, 0, 1, 1, -1}, []byte{0, 0b01100101}},
+		{"Partial Byte", []float64{-1, 1, 1}, []byte{5, 0b110}},
+		{"Two Bytes", []float64{1, 0, 0, 0, 0, 0, 0, 0, 1}, []byte{7, 0b1, 0b1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := q.Encode(tt.embedding)
+			require.NoError(t, err)
+			assert.Equal(t, tt.code, code)
+
+			decoded, err := q.Decode(code)
+			require.NoError(t, err)
+			require.Len(t, decoded, len(tt.embedding))
+			assert.True(t, isNormalized(decoded))
+			for i, v := range tt.embedding {
+				assert.Equal(t, v > 0, decoded[i] > 0)
+			}
+		})
+	}
+
+	_, err := q.Encode(nil)
+	assert.Error(t, err)
+	_, err = q.Decode([]byte{0})
+	assert.Error(t, err)
+	_, err = q.Decode([]byte{8, 0})
+	assert.Error(t, err)
+}
+
+func TestHammingDistance(t *testing.T) {
+	a := []byte{0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x00, 0x0f}
+	b := []byte{0x00, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff, 0x01, 0x00}
+	assert.Equal(t, 13, hammingDistance(a, b))
+	assert.Equal(t, 0, hammingDistance(a, a))
+}
+
+func TestBinaryQuantizer_Scorer(t *testing.T) {
+	q := NewBinaryQuantizer()
+
+	query := []float64{0.5, 0.5, -0.5, -0.5}
+	scorer, err := q.Scorer(query)
+	require.NoError(t, err)
+
+	same, _ := q.Encode([]float64{1, 1, -1, -1})
+	opposite, _ := q.Encode([]float64{-1, -1, 1, 1})
+	half, _ := q.Encode([]float64{1, -1, -1, 1})
+	other, _ := q.Encode([]float64{1, 1, -1})
+
+	assert.Equal(t, 1.0, scorer(same))
+	assert.Equal(t, -1.0, scorer(opposite))
+	assert.Equal(t, 0.0, scorer(half))
+	assert.Equal(t, math.Inf(-1), scorer(other))
+}
+
+func TestCollection_BinaryQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(10))
+	vectors := randomNormalizedVectors(rng, 300, 64)
+	ids := make([]string, len(vectors))
+
+	contents := make(map[string][]float64)
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		embeddings := make([][]float64, len(inputs))
+		for i, input := range inputs {
+			embeddings[i] = contents[input]
+		}
+		return embeddings, nil
+	}
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc)
+	for i, v := range vectors {
+		ids[i] = fmt.Sprintf("doc%d_0", i)
+		content := fmt.Sprintf("content %d", i)
+		contents[content] = v
+		require.NoError(t, collection.AddDocument(&Document{ID: fmt.Sprintf("doc%d", i), Content: content}))
+	}
+
+	require.NoError(t, collection.EnableQuantization(NewBinaryQuantizer(), QuantizationOptions{KeepEmbeddings: true, RescoreFactor: 20}))
+
+	doc, _ := collection.GetDocument("doc0")
+	assert.Len(t, doc.Segments[0].Code, 9)
+	assert.NotNil(t, doc.Segments[0].Embedding)
+
+	var total float64
+	queries := randomNormalizedVectors(rng, 20, 64)
+	for i, q := range queries {
+		query := fmt.Sprintf("query %d", i)
+		contents[query] = q
+
+		expected, _ := getTopNSimilarEmbeddings(q, vectors, ids, 5)
+		results, err := collection.GetTopNSimilarDocuments(query, 5)
+		require.NoError(t, err)
+		require.Len(t, results, 5)
+
+		got := make([]Similarity, len(results))
+		for j, result := range results {
+			got[j] = Similarity{ID: result.Document.ID + "_0", Score: result.Similarity}
+			// Candidates are rescored with full precision.
+			score, _ := dotProduct(q, result.Segment.Embedding)
+			assert.InDelta(t, score, result.Similarity, 1e-12)
+		}
+		total += recallAt(expected, got)
+	}
+	assert.GreaterOrEqual(t, total/float64(len(queries)), 0.8)
+
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollection(&buf, embeddingFunc)
+	require.NoError(t, err)
+	assert.IsType(t, &BinaryQuantizer{}, loaded.codec)
+	assert.Equal(t, collection.quantization, loaded.quantization)
+}
@@ -0,0 +1,278 @@
+package vector
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// EmbeddingCache stores embeddings by the key computed by a CachedEmbedder.
+// Implementations must be safe for concurrent use.
+type EmbeddingCache interface {
+	// Get returns the embedding stored under key, if any.
+	Get(key string) ([]float64, bool)
+	// Put stores embedding under key.
+	Put(key string, embedding []float64) error
+}
+
+// CacheStats reports how many inputs a CachedEmbedder found in its cache.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+}
+
+// CachedEmbedder looks up the embedding of every input in a cache before calling the wrapped
+// embedder, and only embeds the inputs that are not cached, so that the same text is never
+// embedded twice. Embeddings are cached by the model, the embedding type and the SHA-256
+// hash of the text.
+type CachedEmbedder struct {
+	embedder Embedder
+	cache    EmbeddingCache
+	model    string
+	hits     atomic.Int64
+	misses   atomic.Int64
+}
+
+// NewCachedEmbedder wraps embedder in a CachedEmbedder. The model identifies the embeddings
+// produced by embedder. It must change whenever they do, for example with the name of the
+// model, its dimensions or its input prefixes, so that a cache shared between embedders never
+// returns the embeddings of another model.
+func NewCachedEmbedder(embedder Embedder, cache EmbeddingCache, model string) (*CachedEmbedder, error) {
+	if embedder == nil {
+		return nil, errors.New("embedder is required")
+	}
+	if cache == nil {
+		return nil, errors.New("cache is required")
+	}
+	if model == "" {
+		return nil, errors.New("model is required")
+	}
+	return &CachedEmbedder{embedder: embedder, cache: cache, model: model}, nil
+}
+
+// cacheKey returns the key of the embedding of text.
+func (e *CachedEmbedder) cacheKey(embeddingType, text string) string {
+	return hashFields(e.model, embeddingType, text)
+}
+
+// hashFields returns the hex-encoded SHA-256 hash of fields.
+func hashFields(fields ...string) string {
+	hash := sha256.New()
+	// Length prefixes keep the fields from running into each other.
+	for _, field := range fields {
+		_ = binary.Write(hash, binary.LittleEndian, uint64(len(field)))
+		hash.Write([]byte(field))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Embed returns the cached embeddings of the inputs, and embeds the others with a single call
+// to the wrapped embedder. Inputs repeated within the call are embedded once.
+func (e *CachedEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	embeddings := make([][]float64, len(inputs))
+	keys := make([]string, len(inputs))
+	missing := make(map[string][]int) // positions of the inputs by key.
+	var missingInputs, missingKeys []string
+	for i, input := range inputs {
+		keys[i] = e.cacheKey(embeddingType, input)
+		if embedding, ok := e.cache.Get(keys[i]); ok {
+			embeddings[i] = embedding
+			continue
+		}
+		if _, ok := missing[keys[i]]; !ok {
+			missingInputs = append(missingInputs, input)
+			missingKeys = append(missingKeys, keys[i])
+		}
+		missing[keys[i]] = append(missing[keys[i]], i)
+	}
+	e.hits.Add(int64(len(inputs) - len(missingInputs)))
+	e.misses.Add(int64(len(missingInputs)))
+
+	if len(missingInputs) == 0 {
+		return embeddings, nil
+	}
+
+	missingEmbeddings, err := e.embedder.Embed(ctx, missingInputs, embeddingType)
+	if err != nil {
+		return nil, err
+	}
+	if len(missingEmbeddings) != len(missingInputs) {
+		return nil, fmt.Errorf("%w: expected %d embeddings, got %d", ErrEmbeddingCountMismatch, len(missingInputs), len(missingEmbeddings))
+	}
+	for i, embedding := range missingEmbeddings {
+		key := missingKeys[i]
+		// A cache that cannot be written to only costs the next call an embedding.
+		if err := e.cache.Put(key, embedding); err != nil {
+			slog.Warn("failed to cache embedding", "key", key, "error", err)
+		}
+		for _, position := range missing[key] {
+			embeddings[position] = embedding
+		}
+	}
+	return embeddings, nil
+}
+
+// Stats returns the number of inputs that were served from the cache, including inputs
+// repeated within a call, and the number that were embedded.
+func (e *CachedEmbedder) Stats() CacheStats {
+	return CacheStats{Hits: e.hits.Load(), Misses: e.misses.Load()}
+}
+
+// LRUCache is an in-memory EmbeddingCache that holds a fixed number of embeddings and
+// evicts the least recently used one when it is full.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // entries from most to least recently used.
+	entries  map[string]*list.Element // elements of order by key.
+}
+
+// lruEntry is an embedding stored in an LRUCache.
+type lruEntry struct {
+	key       string
+	embedding []float64
+}
+
+// NewLRUCache creates an LRUCache that holds up to capacity embeddings.
+func NewLRUCache(capacity int) (*LRUCache, error) {
+	if capacity <= 0 {
+		return nil, errors.New("capacity must be greater than zero")
+	}
+	return &LRUCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}, nil
+}
+
+// Get returns a copy of the embedding stored under key, if any.
+func (l *LRUCache) Get(key string) ([]float64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(element)
+	return append([]float64(nil), element.Value.(*lruEntry).embedding...), true
+}
+
+// Put stores a copy of embedding under key, evicting the least recently used embedding
+// if the cache is full.
+func (l *LRUCache) Put(key string, embedding []float64) error {
+	embedding = append([]float64(nil), embedding...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		element.Value.(*lruEntry).embedding = embedding
+		l.order.MoveToFront(element)
+		return nil
+	}
+	if l.order.Len() >= l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, embedding: embedding})
+	return nil
+}
+
+// Len returns the number of embeddings in the cache.
+func (l *LRUCache) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.order.Len()
+}
+
+// DiskCache is an EmbeddingCache that stores every embedding in its own file in a directory,
+// so that it survives restarts and can be shared by processes. It never evicts embeddings.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache creates a DiskCache in dir, creating the directory if needed.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if dir == "" {
+		return nil, errors.New("directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+// path returns the path of the file holding the embedding stored under key.
+// Keys that are not plain file names have no path.
+func (d *DiskCache) path(key string) (string, bool) {
+	if key == "" || key == "." || key == ".." || filepath.Base(key) != key {
+		return "", false
+	}
+	return filepath.Join(d.dir, key), true
+}
+
+// Get reads the embedding stored under key, if any. Unreadable files are treated as missing.
+func (d *DiskCache) Get(key string) ([]float64, bool) {
+	path, ok := d.path(key)
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to read cached embedding", "key", key, "error", err)
+		}
+		return nil, false
+	}
+	if len(data)%8 != 0 {
+		slog.Warn("skipping corrupt cached embedding", "key", key, "size", len(data))
+		return nil, false
+	}
+
+	embedding := make([]float64, len(data)/8)
+	for i := range embedding {
+		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return embedding, true
+}
+
+// Put writes embedding to the file of key. The file is written in full before it replaces
+// any previous one, so that concurrent readers never see a partial embedding.
+func (d *DiskCache) Put(key string, embedding []float64) error {
+	path, ok := d.path(key)
+	if !ok {
+		return fmt.Errorf("invalid cache key %q", key)
+	}
+
+	data := make([]byte, len(embedding)*8)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(v))
+	}
+
+	file, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
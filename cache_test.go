@@ -0,0 +1,176 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCachedEmbedder(t *testing.T) {
+	cache, _ := NewLRUCache(10)
+
+	_, err := NewCachedEmbedder(nil, cache, "model")
+	assert.Error(t, err)
+	_, err = NewCachedEmbedder(&recordingEmbedder{}, nil, "model")
+	assert.Error(t, err)
+	_, err = NewCachedEmbedder(&recordingEmbedder{}, cache, "")
+	assert.Error(t, err)
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	cache, err := NewLRUCache(10)
+	require.NoError(t, err)
+	inner := &recordingEmbedder{}
+	embedder, err := NewCachedEmbedder(inner, cache, "model")
+	require.NoError(t, err)
+
+	embeddings, err := embedder.Embed(context.Background(), []string{"a", "bb", "a"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1}, {2}, {1}}, embeddings)
+	assert.Equal(t, [][]string{{"a", "bb"}}, inner.calls)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, embedder.Stats())
+
+	// Only the new input is embedded.
+	embeddings, err = embedder.Embed(context.Background(), []string{"bb", "ccc", "a"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{2}, {3}, {1}}, embeddings)
+	assert.Equal(t, []string{"ccc"}, inner.calls[1])
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 3}, embedder.Stats())
+
+	// Cached inputs are not embedded at all.
+	_, err = embedder.Embed(context.Background(), []string{"a", "ccc"}, "docType")
+	require.NoError(t, err)
+	assert.Len(t, inner.calls, 2)
+
+	// The embedding type is part of the key.
+	_, err = embedder.Embed(context.Background(), []string{"a"}, "queryType")
+	require.NoError(t, err)
+	assert.Len(t, inner.calls, 3)
+
+	// So is the model.
+	other, err := NewCachedEmbedder(inner, cache, "other-model")
+	require.NoError(t, err)
+	_, err = other.Embed(context.Background(), []string{"a"}, "docType")
+	require.NoError(t, err)
+	assert.Len(t, inner.calls, 4)
+	assert.NotEqual(t, embedder.cacheKey("docType", "a"), other.cacheKey("docType", "a"))
+	assert.NotEqual(t, embedder.cacheKey("docType", "a"), embedder.cacheKey("docTyp", "ea"))
+}
+
+// readOnlyCache is an empty cache that fails every write.
+type readOnlyCache struct{}
+
+func (readOnlyCache) Get(key string) ([]float64, bool)          { return nil, false }
+func (readOnlyCache) Put(key string, embedding []float64) error { return errors.New("read-only") }
+
+func TestCachedEmbedder_Errors(t *testing.T) {
+	cache, _ := NewLRUCache(10)
+	inner := &recordingEmbedder{errs: []error{errors.New("backend down")}}
+	embedder, _ := NewCachedEmbedder(inner, cache, "model")
+
+	// Nothing is cached when embedding fails.
+	_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+	assert.EqualError(t, err, "backend down")
+	assert.Equal(t, 0, cache.Len())
+
+	// A cache that cannot be written to does not fail the call.
+	embedder, _ = NewCachedEmbedder(&recordingEmbedder{}, readOnlyCache{}, "model")
+	embeddings, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1}}, embeddings)
+}
+
+func TestCachedEmbedder_Collection(t *testing.T) {
+	cache, _ := NewLRUCache(100)
+	inner := &recordingEmbedder{}
+	embedder, _ := NewCachedEmbedder(inner, cache, "model")
+
+	// Re-ingesting an unchanged corpus does not embed it again.
+	for i := 0; i < 2; i++ {
+		collection, err := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, embedder)
+		require.NoError(t, err)
+		require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first document"}))
+		require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second document"}))
+	}
+	assert.Len(t, inner.calls, 2)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 2}, embedder.Stats())
+}
+
+func TestLRUCache(t *testing.T) {
+	_, err := NewLRUCache(0)
+	assert.Error(t, err)
+
+	cache, err := NewLRUCache(2)
+	require.NoError(t, err)
+
+	embedding := []float64{1, 2}
+	require.NoError(t, cache.Put("a", embedding))
+	require.NoError(t, cache.Put("b", []float64{3}))
+
+	// The cache keeps its own copy.
+	embedding[0] = 9
+	got, ok := cache.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, []float64{1, 2}, got)
+	got[0] = 9
+	got, _ = cache.Get("a")
+	assert.Equal(t, []float64{1, 2}, got)
+
+	// "b" is the least recently used, so it is evicted.
+	require.NoError(t, cache.Put("c", []float64{4}))
+	assert.Equal(t, 2, cache.Len())
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+
+	// Putting an existing key replaces its embedding.
+	require.NoError(t, cache.Put("c", []float64{5}))
+	got, _ = cache.Get("c")
+	assert.Equal(t, []float64{5}, got)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestDiskCache(t *testing.T) {
+	_, err := NewDiskCache("")
+	assert.Error(t, err)
+
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache, err := NewDiskCache(dir)
+	require.NoError(t, err)
+
+	_, ok := cache.Get("a")
+	assert.False(t, ok)
+
+	require.NoError(t, cache.Put("a", []float64{1.5, -2, 0}))
+	got, ok := cache.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, []float64{1.5, -2, 0}, got)
+
+	// The embeddings survive reopening the cache.
+	reopened, err := NewDiskCache(dir)
+	require.NoError(t, err)
+	got, ok = reopened.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, []float64{1.5, -2, 0}, got)
+
+	// Only the embedding files are left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Corrupt files are treated as missing.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupt"), []byte{1, 2, 3}, 0o644))
+	_, ok = cache.Get("corrupt")
+	assert.False(t, ok)
+
+	// Keys cannot escape the directory.
+	assert.Error(t, cache.Put("../escape", []float64{1}))
+	_, ok = cache.Get("../cache/a")
+	assert.False(t, ok)
+}
@@ -1,6 +1,8 @@
 package vector
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -13,23 +15,90 @@ import (
 // EmbeddingFunc is a function that generates embeddings for a list of inputs.
 type EmbeddingFunc func(inputs []string, embeddingType string) ([][]float64, error)
 
+// ContextEmbeddingFunc is a function that generates embeddings for a list of inputs,
+// and gives up when ctx is done.
+type ContextEmbeddingFunc func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error)
+
+// WithContext adapts an EmbeddingFunc to a ContextEmbeddingFunc. Since f cannot be
+// interrupted, it keeps running in the background after ctx is done, and its result is discarded.
+func (f EmbeddingFunc) WithContext() ContextEmbeddingFunc {
+	return func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ctx.Done() == nil {
+			// The context can never be canceled.
+			return f(inputs, embeddingType)
+		}
+
+		type result struct {
+			embeddings [][]float64
+			err        error
+		}
+		done := make(chan result, 1)
+		go func() {
+			embeddings, err := f(inputs, embeddingType)
+			done <- result{embeddings, err}
+		}()
+
+		select {
+		case r := <-done:
+			return r.embeddings, r.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+var (
+	// ErrDimensionMismatch is returned when an embedding does not have the dimension of the
+	// collection, or of the other embeddings returned with it.
+	ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+	// ErrEmbeddingCountMismatch is returned when an embedding function does not return exactly
+	// one embedding per input.
+	ErrEmbeddingCountMismatch = errors.New("embedding count mismatch")
+)
+
 // Collection represents a collection of documents with metadata and an embedding function.
 type Collection struct {
 	Name                  string
 	metadata              map[string]interface{}
 	documents             map[string]*Document
 	documentsLock         sync.RWMutex
-	embeddingFunc         EmbeddingFunc
+	embeddingFunc         ContextEmbeddingFunc
 	embeddingDocumentType string // generate embeddings for documents.
 	embeddingQueryType    string // generate embeddings for queries.
 	ChunkSize             int
 	ChunkOverlap          int
+	Splitter              Splitter       // splits documents into segments, or nil to split by runes with RuneSplitter.
+	EmbeddingModel        string         // names the embedding model in document fingerprints, see EmbedDocuments.
+	wal                   *writeAheadLog // nil unless durable mode is enabled with OpenWAL.
+	index                 Index          // nil unless an index is set with SetIndex.
+	indexStale            bool           // set when a segment could not be added to the index, see insertDocument.
+	codec                 Codec          // nil unless quantization is enabled with EnableQuantization.
+	indexCodec            *codecSnapshot // codec of the IVF index saved with the collection, until SetIndex.
+	quantization          QuantizationOptions
+	keywordIndex          *KeywordIndex            // nil unless a keyword index is set with SetKeywordIndex.
+	metadataIndexes       map[string]metadataIndex // keyed by metadata key, see CreateMetadataIndex.
+	segmentCount          int                      // total number of segments in the collection.
+	writing               map[string]struct{}      // IDs reserved by writes in progress, see beginWrite.
+	dimension             int                      // dimension of every embedding, or 0 until the first one.
 }
 
 // NewCollection creates a new collection with the given name, split size, overlap size, and embedding function.
 // SplitSize defines the size of each segment after splitting the document.
 // OverlapSize defines the number of characters that will overlap between consecutive segments.
 func NewCollection(name, embeddingDocumentType, embeddingQueryType string, chunkSize, chunkOverlap int, embeddingFunc EmbeddingFunc) (*Collection, error) {
+	if embeddingFunc == nil {
+		return nil, errors.New("embedding function is required")
+	}
+	return NewCollectionWithContextFunc(name, embeddingDocumentType, embeddingQueryType, chunkSize, chunkOverlap, embeddingFunc.WithContext())
+}
+
+// NewCollectionWithContextFunc is like NewCollection, but takes an embedding function that
+// receives the context passed to the Context variants of the collection methods, such as
+// AddDocumentContext and QueryContext, so that deadlines and cancellation reach the embedding backend.
+func NewCollectionWithContextFunc(name, embeddingDocumentType, embeddingQueryType string, chunkSize, chunkOverlap int, embeddingFunc ContextEmbeddingFunc) (*Collection, error) {
 	if embeddingFunc == nil {
 		return nil, errors.New("embedding function is required")
 	}
@@ -56,6 +125,7 @@ func NewCollection(name, embeddingDocumentType, embeddingQueryType string, chunk
 		Name:                  name,
 		metadata:              make(map[string]interface{}),
 		documents:             make(map[string]*Document),
+		writing:               make(map[string]struct{}),
 		embeddingFunc:         embeddingFunc,
 		embeddingDocumentType: embeddingDocumentType,
 		embeddingQueryType:    embeddingQueryType,
@@ -66,50 +136,148 @@ func NewCollection(name, embeddingDocumentType, embeddingQueryType string, chunk
 
 // AddDocument adds a document to the collection, generating embeddings if necessary.
 func (c *Collection) AddDocument(doc *Document) error {
-	c.documentsLock.Lock()
-	defer c.documentsLock.Unlock()
+	return c.AddDocumentContext(context.Background(), doc)
+}
 
+// AddDocumentContext is like AddDocument, but gives up when ctx is done.
+func (c *Collection) AddDocumentContext(ctx context.Context, doc *Document) error {
+	return c.writeDocument(ctx, doc, func(existing *Document) error {
+		if existing != nil {
+			return fmt.Errorf("document with ID %s already exists", doc.ID)
+		}
+		return nil
+	})
+}
+
+// writeDocument adds doc to the collection, or replaces the stored document with the same ID.
+// check is called with the stored document, or nil, and rejects the write if it returns an error.
+//
+// The content is split and embedded without holding c.documentsLock, so that queries and writes
+// of other documents are not blocked by the embedding function; only the final insert is
+// serialized. Meanwhile, the ID is reserved, and other writes of the same ID fail.
+func (c *Collection) writeDocument(ctx context.Context, doc *Document, check func(existing *Document) error) error {
 	if doc.ID == "" {
 		return errors.New("document ID is required")
 	}
 
-	if _, ok := c.documents[doc.ID]; ok {
-		return fmt.Errorf("document with ID %s already exists", doc.ID)
+	c.documentsLock.Lock()
+	existing := c.documents[doc.ID]
+	err := check(existing)
+	if err == nil && doc.Content == "" {
+		err = errors.New("document content is required")
+	}
+	if err == nil {
+		err = c.beginWrite(doc.ID)
+	}
+	// Take a snapshot of the stored document to work on outside the lock.
+	var previous *Document
+	if err == nil && existing != nil {
+		previous = &Document{Content: existing.Content, Segments: copySegments(existing.Segments), Fingerprint: existing.Fingerprint}
+	}
+	c.documentsLock.Unlock()
+	if err != nil {
+		return err
 	}
 
-	if doc.Content == "" {
-		return errors.New("document content is required")
+	var segments []*Segment
+	var fingerprint string
+	if existing == nil {
+		segments, err = c.embedContent(ctx, doc.Content)
+		segments = append(append([]*Segment(nil), doc.Segments...), segments...)
+		fingerprint = c.fingerprint(doc.Content)
+	} else {
+		segments, fingerprint, err = c.updatedSegments(ctx, previous, doc == existing, doc)
 	}
 
-	// Split the content into segments.
-	segments, err := c.splitText(doc.Content)
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	c.endWrite(doc.ID)
 	if err != nil {
 		return err
 	}
+	if c.documents[doc.ID] != existing {
+		return fmt.Errorf("document with ID %s was changed while it was being written", doc.ID)
+	}
+
+	// Segments set by the caller, or embedded while another write locked in the dimension,
+	// may not have the dimension of the collection.
+	if _, err := segmentDimension(segments, c.dimension); err != nil {
+		return err
+	}
+
+	op := walOpAdd
+	if existing != nil {
+		op = walOpUpdate
+	}
+	// Log the document before applying it, so that it survives a crash in durable mode.
+	logged := *doc
+	logged.Segments = segments
+	logged.Fingerprint = fingerprint
+	if err := c.logMutation(walRecord{Op: op, Document: &logged}); err != nil {
+		return err
+	}
+
+	c.replaceDocument(doc, segments, fingerprint)
+	return nil
+}
+
+// beginWrite reserves id for a write that generates embeddings outside c.documentsLock.
+// It fails if another write of the same ID is in progress.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) beginWrite(id string) error {
+	if _, ok := c.writing[id]; ok {
+		return fmt.Errorf("document with ID %s is already being written", id)
+	}
+	c.writing[id] = struct{}{}
+	return nil
+}
+
+// endWrite releases an ID reserved by beginWrite.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) endWrite(id string) {
+	delete(c.writing, id)
+}
+
+// copySegments returns copies of segments, which can be read without holding c.documentsLock.
+// The caller must hold c.documentsLock.
+func copySegments(segments []*Segment) []*Segment {
+	copies := make([]*Segment, len(segments))
+	for i, segment := range segments {
+		segmentCopy := *segment
+		copies[i] = &segmentCopy
+	}
+	return copies
+}
+
+// embedContent splits content into segments and embeds them.
+func (c *Collection) embedContent(ctx context.Context, content string) ([]*Segment, error) {
+	// Split the content into segments.
+	chunks, err := c.splitChunks(content)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
 
 	// Generate embeddings for each segment.
-	embeddings, err := c.embeddingFunc(segments, c.embeddingDocumentType)
+	embeddings, err := c.embed(ctx, texts, c.embeddingDocumentType)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	segments := make([]*Segment, len(texts))
 	for i, embedding := range embeddings {
 		// Normalize the embedding.
 		norm, err := normalizeVector(embedding)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		// Create a segment with the normalized embedding.
-		doc.Segments = append(doc.Segments, &Segment{
-			Text:      segments[i],
-			Embedding: norm,
-		})
+		segments[i] = &Segment{Text: texts[i], Embedding: norm, Metadata: chunks[i].Metadata}
 	}
-
-	// Add the document to the collection.
-	c.documents[doc.ID] = doc
-	return nil
+	return segments, nil
 }
 
 // GetDocument retrieves a document from the collection by ID.
@@ -142,28 +310,371 @@ func (c *Collection) DeleteDocument(id string) error {
 		return fmt.Errorf("document with ID %s not found", id)
 	}
 
-	delete(c.documents, id)
+	if err := c.logMutation(walRecord{Op: walOpDelete, ID: id}); err != nil {
+		return err
+	}
+
+	c.removeDocument(id)
 	return nil
 }
 
-// UpdateDocument updates a document in the collection.
+// UpdateDocument replaces a document in the collection. If the content changed, it is split
+// into segments again, and only the segments whose text is new are embedded; the others keep
+// their embeddings. An update that leaves the content unchanged, such as a metadata change,
+// keeps the segments and does not generate any embeddings.
 func (c *Collection) UpdateDocument(doc *Document) error {
+	return c.UpdateDocumentContext(context.Background(), doc)
+}
+
+// UpdateDocumentContext is like UpdateDocument, but gives up when ctx is done.
+func (c *Collection) UpdateDocumentContext(ctx context.Context, doc *Document) error {
+	return c.writeDocument(ctx, doc, func(existing *Document) error {
+		if existing == nil {
+			return fmt.Errorf("document with ID %s not found", doc.ID)
+		}
+		return nil
+	})
+}
+
+// UpsertDocument adds a document to the collection, or updates it as UpdateDocument does if a
+// document with the same ID already exists.
+//
+// Like the other writes, it embeds the content without holding the collection lock, so that a
+// slow embedding function does not block queries. The ID is reserved meanwhile, so a concurrent
+// write of the same ID fails rather than interleaving, and the document is then inserted or
+// replaced under the lock in a single step. Readers see either the old or the new document.
+func (c *Collection) UpsertDocument(doc *Document) error {
+	return c.UpsertDocumentContext(context.Background(), doc)
+}
+
+// UpsertDocumentContext is like UpsertDocument, but gives up when ctx is done.
+func (c *Collection) UpsertDocumentContext(ctx context.Context, doc *Document) error {
+	return c.writeDocument(ctx, doc, func(existing *Document) error {
+		return nil
+	})
+}
+
+// PatchMetadata changes the metadata of a document without touching its content or segments,
+// so it never generates embeddings. The keys in set are added or replaced, and the keys in
+// unset are removed. A key cannot be both set and unset.
+func (c *Collection) PatchMetadata(id string, set map[string]interface{}, unset []string) error {
 	c.documentsLock.Lock()
 	defer c.documentsLock.Unlock()
 
-	if doc.ID == "" {
-		return errors.New("document ID is required")
+	if _, ok := c.documents[id]; !ok {
+		return fmt.Errorf("document with ID %s not found", id)
+	}
+	for _, key := range unset {
+		if _, ok := set[key]; ok {
+			return fmt.Errorf("metadata key %s cannot be both set and unset", key)
+		}
 	}
 
-	if _, ok := c.documents[doc.ID]; !ok {
-		return fmt.Errorf("document with ID %s not found", doc.ID)
+	// Only the patch is logged, rather than the document with its embeddings.
+	if err := c.logMutation(walRecord{Op: walOpPatchMetadata, ID: id, Set: set, Unset: unset}); err != nil {
+		return err
 	}
+	c.patchMetadata(id, set, unset)
+	return nil
+}
 
-	if doc.Content == "" {
-		return errors.New("document content is required")
+// patchMetadata sets and unsets metadata keys of the stored document with the given ID, if any.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) patchMetadata(id string, set map[string]interface{}, unset []string) {
+	doc, ok := c.documents[id]
+	if !ok {
+		return
 	}
 
+	// Build a new map rather than changing the current one, which readers may hold.
+	metadata := make(map[string]interface{}, len(doc.Metadata)+len(set))
+	for key, value := range doc.Metadata {
+		metadata[key] = value
+	}
+	for _, key := range unset {
+		delete(metadata, key)
+	}
+	for key, value := range set {
+		metadata[key] = value
+	}
+
+	// Only the metadata indexes change, since the segments stay as they are.
+	c.unindexMetadata(id)
+	doc.Metadata = metadata
+	c.indexMetadata(doc)
+}
+
+// updatedSegments returns the segments of doc, which replaces a stored document, and their
+// fingerprint. previous is a snapshot of the stored document, and inPlace reports whether doc is
+// the stored document itself. The segments of doc, or those of previous if doc has none, are kept
+// if the content is unchanged. Otherwise the content is split again, and the segments of either
+// document with the same text are reused, unless they were embedded with another model.
+func (c *Collection) updatedSegments(ctx context.Context, previous *Document, inPlace bool, doc *Document) ([]*Segment, string, error) {
+	// If doc is the stored document, its segments may change under the lock, and its content
+	// may have been changed in place, so that the content of previous tells nothing.
+	docSegments := doc.Segments
+	if inPlace {
+		docSegments = nil
+	}
+	if !inPlace && doc.Content == previous.Content {
+		if len(docSegments) > 0 {
+			return docSegments, doc.Fingerprint, nil
+		}
+		return previous.Segments, previous.Fingerprint, nil
+	}
+
+	chunks, err := c.splitChunks(doc.Content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reusable := make(map[string]*Segment)
+	if c.sameModel(previous.Fingerprint) {
+		for _, segment := range previous.Segments {
+			reusable[segment.Text] = segment
+		}
+	}
+	for _, segment := range docSegments {
+		reusable[segment.Text] = segment
+	}
+
+	segments := make([]*Segment, len(chunks))
+	var missing []string
+	var missingIndexes []int
+	for i, chunk := range chunks {
+		if segment, ok := reusable[chunk.Text]; ok {
+			reused := *segment
+			reused.Metadata = chunk.Metadata
+			segments[i] = &reused
+			continue
+		}
+		missing = append(missing, chunk.Text)
+		missingIndexes = append(missingIndexes, i)
+	}
+	fingerprint := c.fingerprint(doc.Content)
+	if len(missing) == 0 {
+		return segments, fingerprint, nil
+	}
+
+	embeddings, err := c.embed(ctx, missing, c.embeddingDocumentType)
+	if err != nil {
+		return nil, "", err
+	}
+	for i, embedding := range embeddings {
+		norm, err := normalizeVector(embedding)
+		if err != nil {
+			return nil, "", err
+		}
+		segments[missingIndexes[i]] = &Segment{Text: missing[i], Embedding: norm, Metadata: chunks[missingIndexes[i]].Metadata}
+	}
+	return segments, fingerprint, nil
+}
+
+// fingerprint identifies the segments generated from content with the current embedding model
+// and chunking. It starts with the fingerprint of the model, see sameModel.
+func (c *Collection) fingerprint(content string) string {
+	return c.modelFingerprint() + ":" + hashFields(description(c.splitter()), strconv.Itoa(c.ChunkSize), strconv.Itoa(c.ChunkOverlap), content)
+}
+
+// modelFingerprint identifies the embedding model and the embedding type of documents.
+func (c *Collection) modelFingerprint() string {
+	return hashFields(c.EmbeddingModel, c.embeddingDocumentType)[:16]
+}
+
+// sameModel reports whether segments with the given fingerprint were embedded with the current
+// model, so that their embeddings can be reused. Segments without a fingerprint, such as those
+// saved by older releases, are assumed to be.
+func (c *Collection) sameModel(fingerprint string) bool {
+	return fingerprint == "" || strings.HasPrefix(fingerprint, c.modelFingerprint()+":")
+}
+
+// upToDate reports whether the segments of a document with the given content and fingerprint
+// are current. Without a fingerprint, the segments are current if they are exactly the split content.
+func (c *Collection) upToDate(content, fingerprint string, segments []*Segment) (bool, error) {
+	if len(segments) == 0 {
+		return false, nil
+	}
+	if fingerprint != "" {
+		return fingerprint == c.fingerprint(content), nil
+	}
+	chunks, err := c.splitChunks(content)
+	if err != nil {
+		return false, err
+	}
+	return segmentTextsEqual(segments, chunks), nil
+}
+
+// segmentTextsEqual reports whether the texts of segments are exactly those of chunks.
+func segmentTextsEqual(segments []*Segment, chunks []Chunk) bool {
+	if len(segments) != len(chunks) {
+		return false
+	}
+	for i, segment := range segments {
+		if segment.Text != chunks[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
+// insertDocument stores doc in the collection.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) insertDocument(doc *Document) {
 	c.documents[doc.ID] = doc
+	c.segmentCount += len(doc.Segments)
+	if c.dimension == 0 {
+		c.dimension, _ = segmentDimension(doc.Segments, 0)
+	}
+
+	c.indexMetadata(doc)
+
+	if c.codec != nil {
+		c.encodeSegments(doc)
+	}
+
+	if c.index != nil {
+		for i, segment := range doc.Segments {
+			if err := c.index.Add(segmentID(doc.ID, i), c.segmentEmbedding(segment)); err != nil {
+				// Searching the index would miss the segment, so scan every segment instead
+				// until the index is rebuilt by SetIndex or RetrainIndex.
+				slog.Warn("failed to add segment to index, searching without it until it is rebuilt", "docID", doc.ID, "segmentIndex", i, "error", err)
+				c.indexStale = true
+			}
+		}
+	}
+
+	if c.keywordIndex != nil {
+		for i, segment := range doc.Segments {
+			c.keywordIndex.Add(segmentID(doc.ID, i), segment.Text)
+		}
+	}
+}
+
+// removeDocument removes the document with the given ID from the collection, if it exists.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) removeDocument(id string) {
+	doc, ok := c.documents[id]
+	if !ok {
+		return
+	}
+
+	if c.index != nil {
+		for i := range doc.Segments {
+			c.index.Remove(segmentID(id, i))
+		}
+	}
+	if c.keywordIndex != nil {
+		for i := range doc.Segments {
+			c.keywordIndex.Remove(segmentID(id, i))
+		}
+	}
+	c.unindexMetadata(id)
+	c.segmentCount -= len(doc.Segments)
+	delete(c.documents, id)
+}
+
+// replaceDocument stores doc with the given segments and fingerprint, in place of the stored
+// document with the same ID, if any. If the segments are unchanged, as after an update of the
+// metadata, only the metadata indexes are updated, so that the segments keep their entries in
+// the vector and keyword indexes.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) replaceDocument(doc *Document, segments []*Segment, fingerprint string) {
+	if existing, ok := c.documents[doc.ID]; ok && sameSegments(existing.Segments, segments) {
+		c.unindexMetadata(doc.ID)
+		doc.Segments = segments
+		doc.Fingerprint = fingerprint
+		c.documents[doc.ID] = doc
+		c.indexMetadata(doc)
+		return
+	}
+	c.removeDocument(doc.ID)
+	doc.Segments = segments
+	doc.Fingerprint = fingerprint
+	c.insertDocument(doc)
+}
+
+// indexMetadata adds the metadata of doc to the metadata indexes.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) indexMetadata(doc *Document) {
+	for key, index := range c.metadataIndexes {
+		if value, ok := doc.Metadata[key]; ok {
+			index.add(doc.ID, value)
+		}
+	}
+}
+
+// unindexMetadata removes the document with the given ID from the metadata indexes.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) unindexMetadata(id string) {
+	for _, index := range c.metadataIndexes {
+		index.remove(id)
+	}
+}
+
+// sameSegments reports whether a and b have the same texts, embeddings and codes, so that
+// replacing one with the other leaves the vector and keyword indexes as they are.
+func sameSegments(a, b []*Segment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Text != b[i].Text || !bytes.Equal(a[i].Code, b[i].Code) || len(a[i].Embedding) != len(b[i].Embedding) {
+			return false
+		}
+		for j, value := range a[i].Embedding {
+			if b[i].Embedding[j] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetIndex builds the given index over every segment in the collection and uses it
+// for similarity queries from then on. The index is kept up to date as documents are
+// added, updated and deleted. Passing nil removes the index and returns to exact search.
+// Indexes that implement Trainer are trained once the existing segments have been added.
+//
+// If a segment added later cannot be added to the index, queries scan every segment, as
+// with no index, until the index is rebuilt by setting it again or by RetrainIndex.
+func (c *Collection) SetIndex(index Index) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if index != nil {
+		if ivf, ok := index.(*IVFIndex); ok && c.indexCodec != nil {
+			if err := ivf.restoreCodec(c.indexCodec); err != nil {
+				return err
+			}
+		}
+		if err := c.addSegmentsToIndex(index); err != nil {
+			return err
+		}
+
+		if trainer, ok := index.(Trainer); ok && index.Len() > 0 {
+			if err := trainer.Train(); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.index = index
+	c.indexStale = false
+	c.indexCodec = nil
+	return nil
+}
+
+// addSegmentsToIndex adds every segment in the collection to index, replacing the entries
+// it already has.
+// The caller must hold c.documentsLock.
+func (c *Collection) addSegmentsToIndex(index Index) error {
+	for docID, doc := range c.documents {
+		for i, segment := range doc.Segments {
+			if err := index.Add(segmentID(docID, i), c.segmentEmbedding(segment)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -175,47 +686,178 @@ func (c *Collection) Length() int {
 	return len(c.documents)
 }
 
-// EmbedDocuments splits the content of each document into segments and generates embeddings.
+// EmbedDocumentsOptions configures EmbedDocumentsWithOptions.
+// Zero values are replaced by the defaults noted on each field.
+type EmbedDocumentsOptions struct {
+	// Workers is the number of documents embedded at the same time. Defaults to 4.
+	Workers int
+	// Force re-embeds every document, even if its segments are up to date.
+	Force bool
+}
+
+const defaultEmbedWorkers = 4
+
+// EmbedDocuments brings the segments of every document up to date. Only documents without
+// segments, or whose content, chunking or embedding model changed since their segments were
+// generated, are split and embedded again, so calling it twice does nothing the second time.
+// Documents that fail are reported in a *BatchError, sorted by ID, and do not stop the others.
 func (c *Collection) EmbedDocuments() error {
-	c.documentsLock.Lock()
-	defer c.documentsLock.Unlock()
+	return c.EmbedDocumentsWithOptions(context.Background(), EmbedDocumentsOptions{})
+}
+
+// EmbedDocumentsContext is like EmbedDocuments, but gives up when ctx is done.
+// Documents embedded before ctx is done keep their new segments.
+func (c *Collection) EmbedDocumentsContext(ctx context.Context) error {
+	return c.EmbedDocumentsWithOptions(ctx, EmbedDocumentsOptions{})
+}
 
+// EmbedDocumentsWithOptions is like EmbedDocumentsContext, with options.
+func (c *Collection) EmbedDocumentsWithOptions(ctx context.Context, opts EmbedDocumentsOptions) error {
+	if opts.Workers < 0 {
+		return errors.New("workers must be greater than or equal to zero")
+	}
+	if opts.Workers == 0 {
+		opts.Workers = defaultEmbedWorkers
+	}
+
+	c.documentsLock.RLock()
+	docs := make([]*Document, 0, len(c.documents))
 	for _, doc := range c.documents {
-		if doc.Content == "" {
-			return errors.New("document content is required")
+		docs = append(docs, doc)
+	}
+	c.documentsLock.RUnlock()
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].ID < docs[j].ID
+	})
+
+	failed := make(map[int]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	next := make(chan int)
+
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := c.reembedDocument(ctx, docs[i], opts.Force); err != nil {
+					mu.Lock()
+					failed[i] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range docs {
+		select {
+		case next <- i:
+		case <-ctx.Done():
 		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(next)
+	wg.Wait()
 
-		// Split the content into segments.
-		segments, err := c.splitText(doc.Content)
-		if err != nil {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return newBatchError(docs, failed)
+	}
+	return nil
+}
+
+// reembedDocument brings the segments of the stored document doc up to date. The document is
+// only reserved, as writes do, once it is found to be stale, and until its segments are
+// replaced, so that writes of up-to-date documents are not blocked meanwhile. Documents that
+// another write replaced or is writing are skipped, since that write embeds them.
+func (c *Collection) reembedDocument(ctx context.Context, doc *Document, force bool) error {
+	snapshot, ok := c.snapshotDocument(doc)
+	if !ok {
+		return nil
+	}
+	if snapshot.Content == "" {
+		return errors.New("document content is required")
+	}
+	if !force {
+		upToDate, err := c.upToDate(snapshot.Content, snapshot.Fingerprint, snapshot.Segments)
+		if err != nil || upToDate {
 			return err
 		}
+	}
+
+	c.documentsLock.Lock()
+	if c.documents[doc.ID] != doc || c.beginWrite(doc.ID) != nil {
+		c.documentsLock.Unlock()
+		return nil
+	}
+	c.documentsLock.Unlock()
+	defer func() {
+		c.documentsLock.Lock()
+		defer c.documentsLock.Unlock()
+		c.endWrite(doc.ID)
+	}()
+
+	// Take the snapshot again, since the document may have changed before it was reserved.
+	if snapshot, ok = c.snapshotDocument(doc); !ok {
+		return nil
+	}
 
-		// Generate embeddings for each segment.
-		embeddings, err := c.embeddingFunc(segments, c.embeddingDocumentType)
+	if force {
+		segments, err := c.embedContent(ctx, snapshot.Content)
 		if err != nil {
 			return err
 		}
+		return c.replaceSegments(doc, segments, c.fingerprint(snapshot.Content))
+	}
+	// Reuse the embeddings of segments whose text did not change.
+	segments, fingerprint, err := c.updatedSegments(ctx, snapshot, true, snapshot)
+	if err != nil {
+		return err
+	}
+	return c.replaceSegments(doc, segments, fingerprint)
+}
 
-		// Normalize the embeddings.
-		for i, embedding := range embeddings {
-			norm, err := normalizeVector(embedding)
-			if err != nil {
-				return err
-			}
-			embeddings[i] = norm
-		}
+// snapshotDocument returns a copy of the content and segments of the stored document doc,
+// which can be read without holding c.documentsLock, or false if doc was replaced or deleted.
+func (c *Collection) snapshotDocument(doc *Document) (*Document, bool) {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
 
-		// Create segments with embeddings.
-		for i, segmentText := range segments {
-			segment := Segment{
-				Text:      segmentText,
-				Embedding: embeddings[i],
-			}
-			doc.Segments = append(doc.Segments, &segment)
-		}
+	if c.documents[doc.ID] != doc {
+		return nil, false
 	}
+	return &Document{ID: doc.ID, Content: doc.Content, Segments: copySegments(doc.Segments), Fingerprint: doc.Fingerprint}, true
+}
+
+// replaceSegments replaces the segments of the stored document doc, unless it was deleted meanwhile.
+func (c *Collection) replaceSegments(doc *Document, segments []*Segment, fingerprint string) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
 
+	if c.documents[doc.ID] != doc {
+		return nil
+	}
+	if _, err := segmentDimension(segments, c.dimension); err != nil {
+		return err
+	}
+
+	// Log the new segments before applying them, so that they survive a crash in durable mode.
+	updated := *doc
+	updated.Segments = segments
+	updated.Fingerprint = fingerprint
+	if err := c.logMutation(walRecord{Op: walOpUpdate, Document: &updated}); err != nil {
+		return err
+	}
+
+	// Remove the document before changing its segments, so that the index stays in sync.
+	c.removeDocument(doc.ID)
+	doc.Segments = segments
+	doc.Fingerprint = fingerprint
+	c.insertDocument(doc)
 	return nil
 }
 
@@ -224,54 +866,272 @@ type Result struct {
 	Document   *Document
 	Segment    *Segment
 	Similarity float64
+	// VectorScore and KeywordScore are set by HybridSearch to the embedding similarity and
+	// the BM25 score of the segment, or zero if the segment was not retrieved by that signal.
+	VectorScore  float64
+	KeywordScore float64
 }
 
 // GetTopNSimilarDocuments retrieves the top N similar documents to the given query.
+// If an index is set with SetIndex, the index is searched instead of scanning every segment.
 func (c *Collection) GetTopNSimilarDocuments(query string, topN int) ([]Result, error) {
+	return c.GetTopNSimilarDocumentsContext(context.Background(), query, topN)
+}
+
+// GetTopNSimilarDocumentsContext is like GetTopNSimilarDocuments, but gives up when ctx is done.
+func (c *Collection) GetTopNSimilarDocumentsContext(ctx context.Context, query string, topN int) ([]Result, error) {
+	return c.getTopNSimilarDocuments(ctx, query, topN, false, nil)
+}
+
+// GetTopNSimilarDocumentsExact retrieves the top N similar documents to the given query
+// by comparing the query with every segment, even if an index is set.
+// It can be used to verify the results of an approximate index.
+func (c *Collection) GetTopNSimilarDocumentsExact(query string, topN int) ([]Result, error) {
+	return c.GetTopNSimilarDocumentsExactContext(context.Background(), query, topN)
+}
+
+// GetTopNSimilarDocumentsExactContext is like GetTopNSimilarDocumentsExact, but gives up when ctx is done.
+func (c *Collection) GetTopNSimilarDocumentsExactContext(ctx context.Context, query string, topN int) ([]Result, error) {
+	return c.getTopNSimilarDocuments(ctx, query, topN, true, nil)
+}
+
+// getTopNSimilarDocuments retrieves the top N similar documents to the given query among
+// the documents matching filter, using the index unless exact is set or no index is available.
+// A nil filter matches every document.
+func (c *Collection) getTopNSimilarDocuments(ctx context.Context, query string, topN int, exact bool, filter Filter) ([]Result, error) {
+	matcher, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Embed the query before taking the lock, so that a slow embedding backend does not
+	// hold up writers.
+	queryEmbedding, err := c.embedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
 	c.documentsLock.RLock()
 	defer c.documentsLock.RUnlock()
 
-	queryEmbedding, err := c.embeddingFunc([]string{query}, c.embeddingQueryType)
+	sel := c.selectDocuments(filter, matcher)
+	similarities, err := c.search(ctx, queryEmbedding, topN, exact, sel)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(queryEmbedding) == 0 {
-		return nil, errors.New("no embeddings generated for the query")
+	return c.resultsFromSimilarities(similarities), nil
+}
+
+// embedQuery generates the embedding of a query.
+func (c *Collection) embedQuery(ctx context.Context, query string) ([]float64, error) {
+	queryEmbedding, err := c.embed(ctx, []string{query}, c.embeddingQueryType)
+	if err != nil {
+		return nil, err
 	}
+	return queryEmbedding[0], nil
+}
 
-	// Flatten the embeddings for all segments in the collection.
-	var embeddings [][]float64
-	var ids []string
-	for docID, doc := range c.documents {
-		for segmentIndex, segment := range doc.Segments {
-			embeddings = append(embeddings, segment.Embedding)
-			ids = append(ids, fmt.Sprintf("%s_%d", docID, segmentIndex))
+// embed calls the embedding function and checks that it returned one embedding per input,
+// each with the dimension of the collection.
+// It must be called without holding c.documentsLock.
+func (c *Collection) embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	embeddings, err := c.embeddingFunc(ctx, inputs, embeddingType)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) != len(inputs) {
+		return nil, fmt.Errorf("%w: expected %d embeddings, got %d", ErrEmbeddingCountMismatch, len(inputs), len(embeddings))
+	}
+
+	c.documentsLock.RLock()
+	dimension := c.dimension
+	c.documentsLock.RUnlock()
+
+	for _, embedding := range embeddings {
+		if len(embedding) == 0 {
+			return nil, fmt.Errorf("%w: empty embedding", ErrDimensionMismatch)
+		}
+		if dimension == 0 {
+			dimension = len(embedding)
+		}
+		if len(embedding) != dimension {
+			return nil, fmt.Errorf("%w: expected %d, got %d", ErrDimensionMismatch, dimension, len(embedding))
 		}
 	}
+	return embeddings, nil
+}
 
-	// Find the top N similar embeddings to the query embedding.
-	similarities, err := getTopNSimilarEmbeddings(queryEmbedding[0], embeddings, ids, topN)
+// segmentDimension checks that the embeddings of segments have the given dimension, or the same
+// dimension if it is zero, and returns it. Segments without a full-precision embedding are skipped.
+func segmentDimension(segments []*Segment, dimension int) (int, error) {
+	for _, segment := range segments {
+		if len(segment.Embedding) == 0 {
+			continue
+		}
+		if dimension == 0 {
+			dimension = len(segment.Embedding)
+		}
+		if len(segment.Embedding) != dimension {
+			return 0, fmt.Errorf("%w: expected %d, got %d", ErrDimensionMismatch, dimension, len(segment.Embedding))
+		}
+	}
+	return dimension, nil
+}
+
+// SetDimension fixes the dimension of the embeddings of the collection, so that embeddings of
+// any other dimension are rejected with ErrDimensionMismatch. Without it, the dimension is locked
+// in by the first document added. It fails if the collection already has another dimension.
+func (c *Collection) SetDimension(dimension int) error {
+	if dimension <= 0 {
+		return errors.New("dimension must be greater than zero")
+	}
+
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.dimension != 0 && c.dimension != dimension {
+		return fmt.Errorf("%w: the collection has dimension %d", ErrDimensionMismatch, c.dimension)
+	}
+	c.dimension = dimension
+	return nil
+}
+
+// Dimension returns the dimension of the embeddings of the collection, or zero if it is not
+// known yet.
+func (c *Collection) Dimension() int {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	return c.dimension
+}
+
+// filteredIndexSelectivity is the fraction of segments a filter has to match for a
+// filtered query to search the index. Below it, the matching segments are scanned instead,
+// since the index would have to return too many non-matching candidates.
+const filteredIndexSelectivity = 0.1
+
+// search returns the top N similarities between the query embedding and the segments of
+// the selected documents, using the index unless exact is set or no up-to-date index is available.
+// The caller must hold c.documentsLock.
+func (c *Collection) search(ctx context.Context, queryEmbedding []float64, topN int, exact bool, sel *selection) ([]Similarity, error) {
+	if c.index == nil || c.indexStale || exact {
+		return c.scan(ctx, queryEmbedding, topN, exact, sel)
+	}
+
+	normalizedQueryEmbedding, err := normalizeVector(queryEmbedding)
 	if err != nil {
 		return nil, err
 	}
+	if sel == nil {
+		return c.index.Search(normalizedQueryEmbedding, topN)
+	}
 
-	// Helper function to parse the ID and segment index from the similarity ID.
-	parseIDAndSegmentIndex := func(id string) (string, int, error) {
-		lastUnderscoreIndex := strings.LastIndex(id, "_")
-		if lastUnderscoreIndex == -1 {
-			return "", 0, fmt.Errorf("invalid ID format: %s", id)
+	var matching int
+	err = c.eachSelectedDocument(ctx, sel, func(doc *Document) {
+		for _, segment := range doc.Segments {
+			if sel.selectsSegment(doc, segment) {
+				matching++
+			}
 		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if matching == 0 {
+		return nil, nil
+	}
+	if float64(matching) < filteredIndexSelectivity*float64(c.segmentCount) {
+		return c.scan(ctx, queryEmbedding, topN, exact, sel)
+	}
 
-		docID := id[:lastUnderscoreIndex]
-		segmentIndex, err := strconv.Atoi(id[lastUnderscoreIndex+1:])
+	// Ask the index for enough candidates to expect topN matching ones, and widen the
+	// search until enough of them match or the whole index has been searched.
+	want := min(topN, matching)
+	if want <= 0 {
+		return nil, nil
+	}
+	k := max(topN*c.segmentCount/matching*2, 1)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		k = min(k, c.index.Len())
+		candidates, err := c.index.Search(normalizedQueryEmbedding, k)
 		if err != nil {
-			return "", 0, err
+			return nil, err
+		}
+
+		similarities := make([]Similarity, 0, want)
+		for _, sim := range candidates {
+			if c.selectsSegmentID(sel, sim.ID) {
+				similarities = append(similarities, sim)
+				if len(similarities) == want {
+					return similarities, nil
+				}
+			}
+		}
+
+		if k >= c.index.Len() {
+			break
+		}
+		k *= 2
+	}
+
+	// The approximate index missed some matching segments, so fall back to an exact scan.
+	return c.scan(ctx, queryEmbedding, topN, exact, sel)
+}
+
+// selectsSegmentID reports whether the segment with the given ID exists and is selected.
+// The caller must hold c.documentsLock.
+func (c *Collection) selectsSegmentID(sel *selection, id string) bool {
+	docID, segmentIndex, err := parseSegmentID(id)
+	if err != nil {
+		return false
+	}
+	doc, ok := c.documents[docID]
+	if !ok || segmentIndex < 0 || segmentIndex >= len(doc.Segments) {
+		return false
+	}
+	return sel.selects(doc) && sel.selectsSegment(doc, doc.Segments[segmentIndex])
+}
+
+// scan compares the query embedding with every segment of the selected documents
+// and returns the top N similarities.
+// The caller must hold c.documentsLock.
+func (c *Collection) scan(ctx context.Context, queryEmbedding []float64, topN int, exact bool, sel *selection) ([]Similarity, error) {
+	if c.codec != nil {
+		return c.searchQuantized(ctx, queryEmbedding, topN, exact, sel)
+	}
+
+	// Flatten the embeddings for all selected segments in the collection.
+	var embeddings [][]float64
+	var ids []string
+	err := c.eachSelectedDocument(ctx, sel, func(doc *Document) {
+		for segmentIndex, segment := range doc.Segments {
+			if !sel.selectsSegment(doc, segment) {
+				continue
+			}
+			embeddings = append(embeddings, segment.Embedding)
+			ids = append(ids, segmentID(doc.ID, segmentIndex))
 		}
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		return docID, segmentIndex, nil
+	if len(embeddings) == 0 {
+		// The collection is empty, or no document matches the filter.
+		return nil, nil
 	}
 
+	// Find the top N similar embeddings to the query embedding.
+	return getTopNSimilarEmbeddings(queryEmbedding, embeddings, ids, topN)
+}
+
+// resultsFromSimilarities resolves the segment IDs of similarities to documents and segments.
+// The caller must hold c.documentsLock.
+func (c *Collection) resultsFromSimilarities(similarities []Similarity) []Result {
 	var results []Result
 	var wg sync.WaitGroup
 	var mu sync.Mutex
@@ -280,27 +1140,15 @@ func (c *Collection) GetTopNSimilarDocuments(query string, topN int) ([]Result,
 		wg.Add(1)
 		go func(sim Similarity) {
 			defer wg.Done()
-			docID, segmentIndex, err := parseIDAndSegmentIndex(sim.ID)
-			if err != nil {
-				slog.Warn("failed to parse ID", "ID", sim.ID, "error", err)
-				return // Skip invalid IDs.
-			}
-
-			doc, ok := c.documents[docID]
+			doc, segment, ok := c.resolveSegment(sim.ID)
 			if !ok {
-				slog.Warn("document not found in collection", "docID", docID)
-				return // Skip documents that are not found in the collection.
-			}
-
-			if segmentIndex < 0 || segmentIndex >= len(doc.Segments) {
-				slog.Warn("segment index out of bounds for document", "segmentIndex", segmentIndex, "docID", docID)
-				return // Skip segments that are out of bounds.
+				return
 			}
 
 			mu.Lock()
 			results = append(results, Result{
 				Document:   doc,
-				Segment:    doc.Segments[segmentIndex],
+				Segment:    segment,
 				Similarity: sim.Score,
 			})
 			mu.Unlock()
@@ -314,11 +1162,41 @@ func (c *Collection) GetTopNSimilarDocuments(query string, topN int) ([]Result,
 		return results[i].Similarity > results[j].Similarity
 	})
 
-	return results, nil
+	return results
+}
+
+// resolveSegment returns the document and segment with the given segment ID.
+// It reports false, after logging why, if the ID does not resolve to a segment.
+// The caller must hold c.documentsLock.
+func (c *Collection) resolveSegment(id string) (*Document, *Segment, bool) {
+	docID, segmentIndex, err := parseSegmentID(id)
+	if err != nil {
+		slog.Warn("failed to parse ID", "ID", id, "error", err)
+		return nil, nil, false // Skip invalid IDs.
+	}
+
+	doc, ok := c.documents[docID]
+	if !ok {
+		slog.Warn("document not found in collection", "docID", docID)
+		return nil, nil, false // Skip documents that are not found in the collection.
+	}
+
+	if segmentIndex < 0 || segmentIndex >= len(doc.Segments) {
+		slog.Warn("segment index out of bounds for document", "segmentIndex", segmentIndex, "docID", docID)
+		return nil, nil, false // Skip segments that are out of bounds.
+	}
+
+	return doc, doc.Segments[segmentIndex], true
 }
 
 // GetTopNSimilarDocumentsForQueries retrieves the top N similar documents for a list of queries.
 func (c *Collection) GetTopNSimilarDocumentsForQueries(queries []string, topN int) ([]Result, error) {
+	return c.GetTopNSimilarDocumentsForQueriesContext(context.Background(), queries, topN)
+}
+
+// GetTopNSimilarDocumentsForQueriesContext is like GetTopNSimilarDocumentsForQueries,
+// but gives up when ctx is done.
+func (c *Collection) GetTopNSimilarDocumentsForQueriesContext(ctx context.Context, queries []string, topN int) ([]Result, error) {
 	type queryResult struct {
 		query   string
 		results []Result
@@ -334,7 +1212,7 @@ func (c *Collection) GetTopNSimilarDocumentsForQueries(queries []string, topN in
 		wg.Add(1)
 		go func(i int, query string) {
 			defer wg.Done()
-			results, queryErr := c.GetTopNSimilarDocuments(query, topN)
+			results, queryErr := c.GetTopNSimilarDocumentsContext(ctx, query, topN)
 			if queryErr != nil {
 				mu.Lock()
 				slog.Warn("failed to get top N similar documents", "query", query, "error", queryErr)
@@ -354,6 +1232,10 @@ func (c *Collection) GetTopNSimilarDocumentsForQueries(queries []string, topN in
 
 	wg.Wait()
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Check if there was an error for all queries.
 	if errNum == len(queries) {
 		return nil, errors.New("failed to get top N similar documents for all queries")
@@ -393,17 +1275,29 @@ func (c *Collection) GetTopNSimilarDocumentsForQueries(queries []string, topN in
 	return results, nil
 }
 
-// splitText splits a long text into chunks of a maximum size with an overlap.
-func (c *Collection) splitText(text string) ([]string, error) {
-	var chunks []string
-	runes := []rune(text)
+// splitChunks splits a long text into chunks of a maximum size with an overlap, with the splitter
+// of the collection. Chunks have metadata only if the splitter is a ChunkSplitter.
+func (c *Collection) splitChunks(text string) ([]Chunk, error) {
+	splitter := c.splitter()
+	if chunkSplitter, ok := splitter.(ChunkSplitter); ok {
+		return chunkSplitter.SplitChunks(text, c.ChunkSize, c.ChunkOverlap)
+	}
 
-	for i := 0; i < len(runes); i += c.ChunkSize - c.ChunkOverlap {
-		end := i + c.ChunkSize
-		if end > len(runes) {
-			end = len(runes)
-		}
-		chunks = append(chunks, string(runes[i:end]))
+	texts, err := splitter.Split(text, c.ChunkSize, c.ChunkOverlap)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]Chunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = Chunk{Text: text}
 	}
 	return chunks, nil
 }
+
+// splitter returns the splitter of the collection.
+func (c *Collection) splitter() Splitter {
+	if c.Splitter == nil {
+		return RuneSplitter{}
+	}
+	return c.Splitter
+}
@@ -1,9 +1,15 @@
 package vector
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // MockEmbeddingFunc is a mock implementation of the EmbeddingFunc.
@@ -155,6 +161,281 @@ func TestCollection_UpdateDocument(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestCollection_UpdateDocument_Reembed(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 4, 0, embedder)
+
+	doc := &Document{ID: "1", Content: "aaaabbbbcccc"}
+	assert.NoError(t, collection.AddDocument(doc))
+	assert.Len(t, embedder.calls, 1)
+
+	segmentTexts := func() []string {
+		doc, _ := collection.GetDocument("1")
+		var texts []string
+		for _, segment := range doc.Segments {
+			texts = append(texts, segment.Text)
+		}
+		return texts
+	}
+
+	// A metadata-only update does not call the embedder.
+	assert.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: "aaaabbbbcccc", Metadata: map[string]interface{}{"v": 2}}))
+	assert.Len(t, embedder.calls, 1)
+	assert.Equal(t, []string{"aaaa", "bbbb", "cccc"}, segmentTexts())
+
+	// Only the changed segment is embedded.
+	assert.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: "aaaaxxxxcccc"}))
+	assert.Len(t, embedder.calls, 2)
+	assert.Equal(t, []string{"xxxx"}, embedder.calls[1])
+	assert.Equal(t, []string{"aaaa", "xxxx", "cccc"}, segmentTexts())
+
+	// The stored document can be changed in place and updated.
+	stored, _ := collection.GetDocument("1")
+	stored.Content = "aaaaxxxxccccdd"
+	assert.NoError(t, collection.UpdateDocument(stored))
+	assert.Len(t, embedder.calls, 3)
+	assert.Equal(t, []string{"dd"}, embedder.calls[2])
+	assert.Equal(t, []string{"aaaa", "xxxx", "cccc", "dd"}, segmentTexts())
+
+	// The search finds the new segment.
+	results, err := collection.GetTopNSimilarDocumentsExact("zz", 4)
+	assert.NoError(t, err)
+	assert.Len(t, results, 4)
+
+	// A failed embedding leaves the document unchanged.
+	embedder.errs = []error{nil, nil, nil, nil, errors.New("backend down")}
+	assert.Error(t, collection.UpdateDocument(&Document{ID: "1", Content: "yyyy"}))
+	assert.Equal(t, []string{"aaaa", "xxxx", "cccc", "dd"}, segmentTexts())
+}
+
+func TestCollection_UpsertDocument(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, embedder)
+
+	// A new document is added.
+	assert.NoError(t, collection.UpsertDocument(&Document{ID: "1", Content: "first"}))
+	assert.Equal(t, 1, collection.Length())
+	assert.Len(t, embedder.calls, 1)
+
+	// An existing document is updated.
+	assert.NoError(t, collection.UpsertDocument(&Document{ID: "1", Content: "second"}))
+	assert.Equal(t, 1, collection.Length())
+	assert.Len(t, embedder.calls, 2)
+	doc, _ := collection.GetDocument("1")
+	assert.Equal(t, "second", doc.Content)
+	assert.Len(t, doc.Segments, 1)
+
+	assert.Error(t, collection.UpsertDocument(&Document{ID: "", Content: "content"}))
+	assert.Error(t, collection.UpsertDocument(&Document{ID: "1", Content: ""}))
+	assert.Error(t, collection.UpsertDocument(&Document{ID: "2", Content: ""}))
+}
+
+func TestCollection_PatchMetadata(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, embedder)
+	assert.NoError(t, collection.CreateMetadataIndex("status", HashIndex))
+
+	metadata := map[string]interface{}{"status": "draft", "author": "a", "tmp": true}
+	assert.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "content", Metadata: metadata}))
+
+	assert.NoError(t, collection.PatchMetadata("1", map[string]interface{}{"status": "published", "views": 3}, []string{"tmp", "missing"}))
+	doc, _ := collection.GetDocument("1")
+	assert.Equal(t, map[string]interface{}{"status": "published", "author": "a", "views": 3}, doc.Metadata)
+	assert.Equal(t, "content", doc.Content)
+	assert.Len(t, doc.Segments, 1)
+
+	// The previous metadata map is left untouched.
+	assert.Equal(t, "draft", metadata["status"])
+
+	// The patch does not generate embeddings.
+	assert.Len(t, embedder.calls, 1)
+
+	// The metadata indexes follow the patch.
+	ids, ok := collection.planFilter(Filter{"status": "published"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"1"}, sortedIDs(ids))
+	ids, _ = collection.planFilter(Filter{"status": "draft"})
+	assert.Empty(t, ids)
+
+	assert.Error(t, collection.PatchMetadata("2", map[string]interface{}{"status": "draft"}, nil))
+	assert.Error(t, collection.PatchMetadata("1", map[string]interface{}{"status": "draft"}, []string{"status"}))
+}
+
+func TestCollection_PatchMetadata_Indexes(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 4, 0, embedder)
+	index, _ := NewHNSWIndex(HNSWConfig{Seed: 1})
+	require.NoError(t, collection.SetIndex(index))
+	keywordIndex, _ := NewKeywordIndex(KeywordIndexConfig{})
+	collection.SetKeywordIndex(keywordIndex)
+	require.NoError(t, collection.CreateMetadataIndex("status", HashIndex))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "aaaa bbbb"}))
+
+	// Metadata changes leave the segments in the vector and keyword indexes as they are.
+	require.NoError(t, collection.PatchMetadata("1", map[string]interface{}{"status": "draft"}, nil))
+	require.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: "aaaa bbbb", Metadata: map[string]interface{}{"status": "published"}}))
+	require.NoError(t, collection.UpsertDocument(&Document{ID: "1", Content: "aaaa bbbb", Metadata: map[string]interface{}{"status": "final"}}))
+	assert.Equal(t, 0, index.deleted)
+	assert.Equal(t, 3, index.Len())
+
+	ids, _ := collection.planFilter(Filter{"status": "final"})
+	assert.Equal(t, []string{"1"}, sortedIDs(ids))
+	results, err := collection.Query("query", 5, Filter{"status": "final"})
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+	results, err = collection.KeywordSearch("aaaa", 5)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "final", results[0].Document.Metadata["status"])
+
+	// Content changes replace the segments.
+	require.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: "cccc"}))
+	assert.Equal(t, 1, index.Len())
+	results, err = collection.KeywordSearch("aaaa", 5)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestCollection_WriteOutsideLock(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	embeddingFunc := func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+		if inputs[0] == "slow" {
+			close(started)
+			<-release
+		}
+		embeddings := make([][]float64, len(inputs))
+		for i := range inputs {
+			embeddings[i] = []float64{1.0, 0.0}
+		}
+		return embeddings, nil
+	}
+	collection, _ := NewCollectionWithContextFunc("test", "docType", "queryType", 100, 10, embeddingFunc)
+	assert.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "fast"}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- collection.AddDocument(&Document{ID: "2", Content: "slow"})
+	}()
+	<-started
+
+	// Readers and writers of other documents are not blocked while the document is embedded.
+	_, ok := collection.GetDocument("1")
+	assert.True(t, ok)
+	results, err := collection.GetTopNSimilarDocuments("fast", 5)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: "faster"}))
+
+	// Concurrent writes of the same ID are rejected.
+	assert.Error(t, collection.AddDocument(&Document{ID: "2", Content: "other"}))
+	assert.Error(t, collection.UpsertDocument(&Document{ID: "2", Content: "other"}))
+	err = collection.AddDocuments([]*Document{{ID: "2", Content: "other"}}, AddDocumentsOptions{})
+	assert.Error(t, err)
+
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, collection.Length())
+
+	// The ID is released once the write is done.
+	assert.NoError(t, collection.UpdateDocument(&Document{ID: "2", Content: "updated"}))
+}
+
+func TestCollection_WriteOutsideLock_Deleted(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	embeddingFunc := func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+		if inputs[0] == "slow" {
+			close(started)
+			<-release
+		}
+		return [][]float64{{1.0, 0.0}}, nil
+	}
+	collection, _ := NewCollectionWithContextFunc("test", "docType", "queryType", 100, 10, embeddingFunc)
+	assert.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "fast"}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- collection.UpdateDocument(&Document{ID: "1", Content: "slow"})
+	}()
+	<-started
+
+	// A document deleted while it is being updated stays deleted.
+	assert.NoError(t, collection.DeleteDocument("1"))
+	close(release)
+	assert.Error(t, <-done)
+	assert.Equal(t, 0, collection.Length())
+}
+
+func TestCollection_Dimension(t *testing.T) {
+	dimension, count := 2, -1
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		n := len(inputs)
+		if count >= 0 {
+			n = count
+		}
+		embeddings := make([][]float64, n)
+		for i := range embeddings {
+			embeddings[i] = make([]float64, dimension)
+			embeddings[i][0] = 1.0
+		}
+		return embeddings, nil
+	}
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc)
+	assert.Equal(t, 0, collection.Dimension())
+
+	// Too few embeddings are rejected.
+	count = 0
+	err := collection.AddDocument(&Document{ID: "1", Content: "content"})
+	assert.ErrorIs(t, err, ErrEmbeddingCountMismatch)
+	assert.Equal(t, 0, collection.Length())
+	count = -1
+
+	// The first document locks in the dimension.
+	assert.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "content"}))
+	assert.Equal(t, 2, collection.Dimension())
+
+	dimension = 3
+	err = collection.AddDocument(&Document{ID: "2", Content: "content"})
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+	_, err = collection.GetTopNSimilarDocuments("query", 1)
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+	err = collection.AddDocuments([]*Document{{ID: "2", Content: "content"}}, AddDocumentsOptions{})
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+	assert.Equal(t, 1, collection.Length())
+
+	// Segments set by the caller are checked too.
+	err = collection.UpdateDocument(&Document{ID: "1", Content: "content", Segments: []*Segment{{Text: "content", Embedding: []float64{1, 0, 0}}}})
+	assert.ErrorIs(t, err, ErrDimensionMismatch)
+
+	// EmbedDocuments fails instead of panicking.
+	dimension, count = 2, 0
+	err = collection.EmbedDocumentsWithOptions(context.Background(), EmbedDocumentsOptions{Force: true})
+	assert.ErrorIs(t, err, ErrEmbeddingCountMismatch)
+}
+
+func TestCollection_SetDimension(t *testing.T) {
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		return [][]float64{{1.0, 0.0}}, nil
+	}
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc)
+
+	assert.Error(t, collection.SetDimension(0))
+	assert.NoError(t, collection.SetDimension(3))
+	assert.ErrorIs(t, collection.AddDocument(&Document{ID: "1", Content: "content"}), ErrDimensionMismatch)
+
+	// The dimension is saved with the collection.
+	var buf bytes.Buffer
+	assert.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollection(&buf, embeddingFunc)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, loaded.Dimension())
+
+	// The dimension cannot change once set.
+	assert.ErrorIs(t, collection.SetDimension(2), ErrDimensionMismatch)
+	assert.NoError(t, collection.SetDimension(3))
+}
+
 func TestCollection_Length(t *testing.T) {
 	embeddingFunc := new(MockEmbeddingFunc)
 	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
@@ -195,6 +476,134 @@ func TestCollection_EmbedDocuments(t *testing.T) {
 	assert.NotEmpty(t, retrievedDoc.Segments)
 }
 
+func TestCollection_EmbedDocuments_Incremental(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 4, 0, embedder)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "aaaabbbb"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "cccc"}))
+	embedder.calls = nil
+
+	// Up-to-date documents are not embedded again, and keep their segments.
+	require.NoError(t, collection.EmbedDocuments())
+	require.NoError(t, collection.EmbedDocuments())
+	assert.Empty(t, embedder.calls)
+	doc, _ := collection.GetDocument("1")
+	assert.Len(t, doc.Segments, 2)
+
+	// A document changed in place is re-embedded, reusing the segments that did not change.
+	doc.Content = "aaaabbbbdd"
+	require.NoError(t, collection.EmbedDocuments())
+	assert.Equal(t, [][]string{{"dd"}}, embedder.calls)
+	assert.Len(t, doc.Segments, 3)
+
+	// Changing the chunking splits every document again.
+	embedder.calls = nil
+	collection.ChunkSize = 2
+	require.NoError(t, collection.EmbedDocumentsWithOptions(context.Background(), EmbedDocumentsOptions{Workers: 1}))
+	assert.Len(t, embedder.calls, 2)
+	doc, _ = collection.GetDocument("2")
+	assert.Len(t, doc.Segments, 2)
+
+	// Changing the model embeds every segment again.
+	embedder.calls = nil
+	collection.EmbeddingModel = "other-model"
+	require.NoError(t, collection.EmbedDocuments())
+	assert.ElementsMatch(t, [][]string{{"aa", "aa", "bb", "bb", "dd"}, {"cc", "cc"}}, embedder.calls)
+
+	// Force embeds every document.
+	embedder.calls = nil
+	require.NoError(t, collection.EmbedDocumentsWithOptions(context.Background(), EmbedDocumentsOptions{Force: true}))
+	assert.Len(t, embedder.calls, 2)
+
+	assert.Error(t, collection.EmbedDocumentsWithOptions(context.Background(), EmbedDocumentsOptions{Workers: -1}))
+}
+
+func TestCollection_EmbedDocuments_Errors(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 4, 0, embedder)
+
+	// Documents saved by older releases have no fingerprint, and are compared by their segments.
+	collection.insertDocument(&Document{ID: "1", Content: "aaaa", Segments: []*Segment{{Text: "aaaa", Embedding: []float64{1}}}})
+	collection.insertDocument(&Document{ID: "2", Content: "bbbb"})
+	collection.insertDocument(&Document{ID: "3", Content: "cccc"})
+	collection.insertDocument(&Document{ID: "4"})
+
+	// A failing document does not stop the others.
+	embedder.errs = []error{errors.New("backend down")}
+	err := collection.EmbedDocumentsWithOptions(context.Background(), EmbedDocumentsOptions{Workers: 1})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 2)
+	assert.Equal(t, "2", batchErr.Errors[0].ID)
+	assert.EqualError(t, batchErr.Errors[0].Err, "backend down")
+	assert.Equal(t, "4", batchErr.Errors[1].ID)
+	assert.Len(t, embedder.calls, 2)
+
+	doc, _ := collection.GetDocument("3")
+	assert.Len(t, doc.Segments, 1)
+	assert.NotEmpty(t, doc.Fingerprint)
+
+	// The next call retries the document that failed.
+	embedder.calls, embedder.errs = nil, nil
+	err = collection.EmbedDocuments()
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 1)
+	assert.Equal(t, "4", batchErr.Errors[0].ID)
+	assert.Equal(t, [][]string{{"bbbb"}}, embedder.calls)
+
+	// Fingerprints are saved with the collection.
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollectionWithContextFunc(&buf, embedder.Embed)
+	require.NoError(t, err)
+	loadedDoc, _ := loaded.GetDocument("3")
+	assert.Equal(t, doc.Fingerprint, loadedDoc.Fingerprint)
+
+	// A canceled context stops the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, collection.EmbedDocumentsContext(ctx), context.Canceled)
+}
+
+func TestCollection_EmbedDocuments_Concurrent(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	embeddingFunc := func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+		if inputs[0] == "slow" {
+			close(started)
+			<-release
+		}
+		embeddings := make([][]float64, len(inputs))
+		for i := range inputs {
+			embeddings[i] = []float64{1.0, 0.0}
+		}
+		return embeddings, nil
+	}
+	collection, _ := NewCollectionWithContextFunc("test", "docType", "queryType", 100, 10, embeddingFunc)
+	require.NoError(t, collection.AddDocument(&Document{ID: "a", Content: "fast"}))
+	collection.insertDocument(&Document{ID: "b", Content: "slow"})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- collection.EmbedDocuments()
+	}()
+	<-started
+
+	// Up-to-date documents can be written while a stale one is embedded.
+	assert.NoError(t, collection.UpdateDocument(&Document{ID: "a", Content: "fast", Metadata: map[string]interface{}{"n": 1}}))
+	assert.NoError(t, collection.PatchMetadata("a", map[string]interface{}{"n": 2}, nil))
+	assert.NoError(t, collection.UpsertDocument(&Document{ID: "c", Content: "new"}))
+
+	// The stale document is reserved until it is embedded.
+	assert.Error(t, collection.UpdateDocument(&Document{ID: "b", Content: "other"}))
+
+	close(release)
+	require.NoError(t, <-done)
+	doc, _ := collection.GetDocument("b")
+	assert.Len(t, doc.Segments, 1)
+	assert.NoError(t, collection.UpdateDocument(&Document{ID: "b", Content: "other"}))
+}
+
 func TestCollection_GetTopNSimilarDocuments(t *testing.T) {
 	embeddingFunc := new(MockEmbeddingFunc)
 	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
@@ -213,6 +622,40 @@ func TestCollection_GetTopNSimilarDocuments(t *testing.T) {
 	assert.NotEmpty(t, results)
 }
 
+func TestCollection_GetTopNSimilarDocuments_Empty(t *testing.T) {
+	newCollection := func() *Collection {
+		collection, err := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, &recordingEmbedder{})
+		require.NoError(t, err)
+		return collection
+	}
+	hnsw, err := NewHNSWIndex(HNSWConfig{Seed: 1})
+	require.NoError(t, err)
+	ivf, err := NewIVFIndex(IVFConfig{NList: 2})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		setup func(c *Collection) error
+	}{
+		{"Scan", func(c *Collection) error { return nil }},
+		{"Quantized", func(c *Collection) error {
+			return c.EnableQuantization(NewScalarQuantizer(false), QuantizationOptions{})
+		}},
+		{"HNSW", func(c *Collection) error { return c.SetIndex(hnsw) }},
+		{"IVF", func(c *Collection) error { return c.SetIndex(ivf) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection := newCollection()
+			require.NoError(t, tt.setup(collection))
+			results, err := collection.GetTopNSimilarDocuments("test query", 3)
+			assert.NoError(t, err)
+			assert.Empty(t, results)
+		})
+	}
+}
+
 func TestCollection_GetTopNSimilarDocumentsForQueries(t *testing.T) {
 	embeddingFunc := new(MockEmbeddingFunc)
 	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
@@ -231,3 +674,81 @@ func TestCollection_GetTopNSimilarDocumentsForQueries(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, results)
 }
+
+func TestEmbeddingFunc_WithContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var calls atomic.Int32
+	blocking := EmbeddingFunc(func(inputs []string, embeddingType string) ([][]float64, error) {
+		calls.Add(1)
+		if inputs[0] == "block" {
+			<-release
+		}
+		return [][]float64{{1.0}}, nil
+	})
+	embeddingFunc := blocking.WithContext()
+
+	embeddings, err := embeddingFunc(context.Background(), []string{"fast"}, "docType")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{{1.0}}, embeddings)
+
+	// A hung embedding function is abandoned when the deadline passes.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = embeddingFunc(ctx, []string{"block"}, "docType")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A context that is already done does not call the embedding function.
+	_, err = embeddingFunc(ctx, []string{"fast"}, "docType")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCollection_Context(t *testing.T) {
+	var cancelQuery context.CancelFunc
+	embeddingFunc := func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+		switch inputs[0] {
+		case "slow document":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "cancel during scan":
+			// The query embedding succeeds, but the request is canceled before the scan.
+			cancelQuery()
+		}
+		embeddings := make([][]float64, len(inputs))
+		for i := range inputs {
+			embeddings[i] = []float64{1.0, 0.0}
+		}
+		return embeddings, nil
+	}
+
+	collection, err := NewCollectionWithContextFunc("test", "docType", "queryType", 100, 10, embeddingFunc)
+	assert.NoError(t, err)
+	_, err = NewCollectionWithContextFunc("test", "docType", "queryType", 100, 10, nil)
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = collection.AddDocumentContext(ctx, &Document{ID: "1", Content: "slow document"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, collection.Length())
+
+	assert.NoError(t, collection.AddDocumentContext(context.Background(), &Document{ID: "2", Content: "fast document"}))
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collection.QueryContext(canceled, "query", 1, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = collection.GetTopNSimilarDocumentsForQueriesContext(canceled, []string{"query"}, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	var queryCtx context.Context
+	queryCtx, cancelQuery = context.WithCancel(context.Background())
+	defer cancelQuery()
+	_, err = collection.GetTopNSimilarDocumentsContext(queryCtx, "cancel during scan", 1)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	results, err := collection.GetTopNSimilarDocumentsContext(context.Background(), "query", 1)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+}
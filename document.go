@@ -7,9 +7,15 @@ import (
 
 // Segment represents a segment of a document that has been split into smaller pieces.
 // Each segment has a corresponding embedding.
+// If quantization is enabled on the collection, Code holds the compressed embedding,
+// and Embedding may be nil if the collection does not keep full-precision embeddings.
 type Segment struct {
 	Text      string
 	Embedding []float64
+	Code      []byte
+	// Metadata is set by a ChunkSplitter, such as the headings of the section of a MarkdownSplitter.
+	// It can be matched by filters with $segment.
+	Metadata map[string]interface{}
 }
 
 // Document represents a document with metadata, segments, and content.
@@ -18,6 +24,10 @@ type Document struct {
 	Metadata map[string]interface{}
 	Segments []*Segment
 	Content  string
+	// Fingerprint identifies the content, chunking and embedding model that the segments were
+	// generated with. It is set by the collection, and EmbedDocuments re-embeds the document
+	// when it is out of date.
+	Fingerprint string
 }
 
 // Formatter formats a document for display or export to a file format
@@ -27,6 +37,14 @@ type Formatter interface {
 	Format(docID string, metadata map[string]interface{}, content string) string
 }
 
+// SegmentFormatter is a Formatter that also formats every segment with its metadata, before
+// the segments of a document are joined into the content passed to Format.
+type SegmentFormatter interface {
+	Formatter
+	// FormatSegment formats the text of a segment with the given metadata.
+	FormatSegment(text string, metadata map[string]interface{}) string
+}
+
 // AggregateResults aggregates the results of multiple queries into a single result set
 // using the given formatter interface to format the results.
 func AggregateResults(results []Result, formatter Formatter) string {
@@ -61,6 +79,7 @@ func AggregateResults(results []Result, formatter Formatter) string {
 	})
 
 	// Format the content and metadata for each document and append to the resultBuilder.
+	segmentFormatter, _ := formatter.(SegmentFormatter)
 	var resultBuilder strings.Builder
 	for _, docID := range docIDs {
 		metadata := docMetadataMap[docID]
@@ -73,7 +92,11 @@ func AggregateResults(results []Result, formatter Formatter) string {
 						// Add a separator between segments.
 						contentBuilder.WriteString("\n")
 					}
-					contentBuilder.WriteString(segment.Text)
+					if segmentFormatter != nil {
+						contentBuilder.WriteString(segmentFormatter.FormatSegment(segment.Text, segment.Metadata))
+					} else {
+						contentBuilder.WriteString(segment.Text)
+					}
 					break
 				}
 			}
@@ -180,3 +180,34 @@ func TestAggregateResultsWithJSONFormatter(t *testing.T) {
 		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, aggregated)
 	}
 }
+
+// HeadingFormatter formats every segment under its heading path.
+type HeadingFormatter struct {
+	MockFormatter
+}
+
+func (hf *HeadingFormatter) FormatSegment(text string, metadata map[string]interface{}) string {
+	if path, ok := metadata[HeadingPathKey].(string); ok {
+		return "[" + path + "] " + text
+	}
+	return text
+}
+
+func TestAggregateResultsWithSegmentFormatter(t *testing.T) {
+	results := createTestData()
+	results[1].Segment.Metadata = map[string]interface{}{HeadingPathKey: "Intro > World"}
+
+	// Create a formatter that also formats segments.
+	formatter := &HeadingFormatter{}
+
+	// Aggregate the results.
+	aggregated := AggregateResults(results, formatter)
+
+	// Expected output.
+	expected := "doc2|author:Bob date:2023-04-02 title:Foo Bar Baz Title|Foo1\nBar2\nBaz3doc1|author:Alice date:2023-04-01 title:Hello World Title|Hello1\n[Intro > World] World2doc3|author:Charlie date:2023-04-03 title:doc3 segment title|doc3 segment"
+
+	// Check if the aggregated result matches the expected output.
+	if aggregated != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, aggregated)
+	}
+}
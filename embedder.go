@@ -0,0 +1,405 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Embedder generates embeddings for a list of inputs, one per input and in the same order.
+// The embedding type is the embeddingDocumentType or embeddingQueryType of the collection.
+//
+// Embedders compose: NewBatchEmbedder, NewRetryEmbedder and NewRateLimitedEmbedder wrap an
+// Embedder to add batching, retries and rate limiting. Wrap the rate limiter in the retries,
+// and both in the batching, so that every batch is retried and rate limited on its own.
+type Embedder interface {
+	Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error)
+}
+
+// Embed calls f, so that a ContextEmbeddingFunc can be used as an Embedder.
+func (f ContextEmbeddingFunc) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	return f(ctx, inputs, embeddingType)
+}
+
+// Embed calls f, so that an EmbeddingFunc can be used as an Embedder.
+// See EmbeddingFunc.WithContext for how ctx is handled.
+func (f EmbeddingFunc) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	return f.WithContext()(ctx, inputs, embeddingType)
+}
+
+// NewCollectionWithEmbedder is like NewCollection, but generates embeddings with an Embedder.
+func NewCollectionWithEmbedder(name, embeddingDocumentType, embeddingQueryType string, chunkSize, chunkOverlap int, embedder Embedder) (*Collection, error) {
+	if embedder == nil {
+		return nil, errors.New("embedder is required")
+	}
+	return NewCollectionWithContextFunc(name, embeddingDocumentType, embeddingQueryType, chunkSize, chunkOverlap, embedder.Embed)
+}
+
+// BatchConfig configures a BatchEmbedder.
+type BatchConfig struct {
+	// MaxBatchSize is the largest number of inputs sent to the wrapped embedder at once.
+	MaxBatchSize int
+	// Concurrency is the number of batches embedded at the same time. Defaults to 1.
+	Concurrency int
+}
+
+// BatchEmbedder splits the inputs into batches no larger than the limit of the backend
+// and embeds them with the wrapped embedder, optionally several at a time.
+type BatchEmbedder struct {
+	embedder     Embedder
+	maxBatchSize int
+	concurrency  int
+}
+
+// NewBatchEmbedder wraps embedder in a BatchEmbedder.
+func NewBatchEmbedder(embedder Embedder, config BatchConfig) (*BatchEmbedder, error) {
+	if embedder == nil {
+		return nil, errors.New("embedder is required")
+	}
+	if config.MaxBatchSize <= 0 {
+		return nil, errors.New("max batch size must be greater than zero")
+	}
+	if config.Concurrency < 0 {
+		return nil, errors.New("concurrency must be greater than or equal to zero")
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = 1
+	}
+
+	return &BatchEmbedder{
+		embedder:     embedder,
+		maxBatchSize: config.MaxBatchSize,
+		concurrency:  config.Concurrency,
+	}, nil
+}
+
+// Embed embeds the inputs in batches. If any batch fails, the batches still running are
+// canceled and the first error is returned.
+func (b *BatchEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	if len(inputs) <= b.maxBatchSize {
+		return b.embedder.Embed(ctx, inputs, embeddingType)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	embeddings := make([][]float64, len(inputs))
+	semaphore := make(chan struct{}, b.concurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for start := 0; start < len(inputs); start += b.maxBatchSize {
+		end := min(start+b.maxBatchSize, len(inputs))
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			batch, err := b.embedder.Embed(ctx, inputs[start:end], embeddingType)
+			if err == nil && len(batch) != end-start {
+				err = fmt.Errorf("%w: expected %d embeddings, got %d", ErrEmbeddingCountMismatch, end-start, len(batch))
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			copy(embeddings[start:end], batch)
+		}(start, end)
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
+
+// transientError marks an error as worth retrying.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string   { return e.err.Error() }
+func (e *transientError) Unwrap() error   { return e.err }
+func (e *transientError) Transient() bool { return true }
+
+// permanentError marks an error as not worth retrying, even if it wraps a transient one.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Transient() bool { return false }
+
+// Transient marks err as transient, so that a RetryEmbedder retries the call that failed with it.
+// Embedders should mark errors such as rate limiting and server overload as transient.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err: err}
+}
+
+// IsTransient reports whether err is worth retrying. Errors are transient if they, or an
+// error they wrap, have a Transient method that returns true, or are network timeouts.
+// Cancellation and deadlines of the caller's context are never transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var transient interface{ Transient() bool }
+	if errors.As(err, &transient) {
+		return transient.Transient()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryConfig configures a RetryEmbedder.
+// Zero values are replaced by the defaults noted on each field.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts, including the first one. Defaults to 4.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. Defaults to 500ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts. Defaults to 30s.
+	MaxBackoff time.Duration
+	// Multiplier is the factor the delay grows by after each retry. Defaults to 2.
+	Multiplier float64
+	// Jitter randomizes each delay, from 0 to 1: a delay d is drawn uniformly from
+	// [d*(1-Jitter), d], which spreads out clients that fail together. Defaults to 0.5;
+	// see NoJitter for 0.
+	Jitter float64
+	// NoJitter sets Jitter to 0, so that the delays are exactly the exponential backoff.
+	NoJitter bool
+	// IsTransient classifies the errors that are retried. Defaults to IsTransient.
+	IsTransient func(err error) bool
+}
+
+const (
+	defaultRetryMaxAttempts    = 4
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2
+	defaultRetryJitter         = 0.5
+)
+
+// RetryEmbedder retries the calls to the wrapped embedder that fail with a transient error,
+// with exponential backoff and jitter. If an error has a RetryAfter method, such as the
+// errors of rate-limited HTTP clients, the delay is at least the duration it returns.
+type RetryEmbedder struct {
+	embedder Embedder
+	config   RetryConfig
+	mu       sync.Mutex
+	rng      *rand.Rand
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// NewRetryEmbedder wraps embedder in a RetryEmbedder.
+func NewRetryEmbedder(embedder Embedder, config RetryConfig) (*RetryEmbedder, error) {
+	if embedder == nil {
+		return nil, errors.New("embedder is required")
+	}
+	if config.MaxAttempts < 0 || config.InitialBackoff < 0 || config.MaxBackoff < 0 || config.Multiplier < 0 {
+		return nil, errors.New("retry parameters must be greater than or equal to zero")
+	}
+	if config.Multiplier != 0 && config.Multiplier < 1 {
+		return nil, errors.New("retry multiplier must be at least 1")
+	}
+	if config.Jitter < 0 || config.Jitter > 1 {
+		return nil, errors.New("retry jitter must be between 0 and 1")
+	}
+	if config.NoJitter && config.Jitter != 0 {
+		return nil, errors.New("retry jitter cannot be set with no jitter")
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if config.InitialBackoff == 0 {
+		config.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if config.Multiplier == 0 {
+		config.Multiplier = defaultRetryMultiplier
+	}
+	if config.Jitter == 0 && !config.NoJitter {
+		config.Jitter = defaultRetryJitter
+	}
+	if config.IsTransient == nil {
+		config.IsTransient = IsTransient
+	}
+
+	return &RetryEmbedder{
+		embedder: embedder,
+		config:   config,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep:    sleepContext,
+	}, nil
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Embed calls the wrapped embedder until it succeeds, fails with an error that is not
+// transient, or runs out of attempts, in which case the last error is returned.
+func (r *RetryEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	backoff := r.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		embeddings, err := r.embedder.Embed(ctx, inputs, embeddingType)
+		if err == nil {
+			return embeddings, nil
+		}
+		if attempt >= r.config.MaxAttempts || ctx.Err() != nil || !r.config.IsTransient(err) {
+			return nil, err
+		}
+
+		delay := r.jitter(backoff)
+		var retryAfter interface{ RetryAfter() time.Duration }
+		if errors.As(err, &retryAfter) {
+			delay = max(delay, retryAfter.RetryAfter())
+		}
+		if err := r.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		backoff = min(time.Duration(float64(backoff)*r.config.Multiplier), r.config.MaxBackoff)
+	}
+}
+
+// jitter draws a delay uniformly from [d*(1-Jitter), d].
+func (r *RetryEmbedder) jitter(d time.Duration) time.Duration {
+	r.mu.Lock()
+	f := r.rng.Float64()
+	r.mu.Unlock()
+
+	return time.Duration(float64(d) * (1 - r.config.Jitter*f))
+}
+
+// RateLimitConfig configures a RateLimitedEmbedder.
+type RateLimitConfig struct {
+	// Rate is the number of tokens added to the bucket per second.
+	Rate float64
+	// Burst is the capacity of the bucket. Defaults to Rate rounded up, and at least 1.
+	Burst int
+	// PerInput makes every call cost one token per input instead of a single token,
+	// for backends that limit inputs rather than requests.
+	PerInput bool
+}
+
+// RateLimitedEmbedder limits the calls to the wrapped embedder with a token bucket.
+// A call waits until the bucket holds enough tokens, or until its context is done.
+// Calls that cost more than the capacity of the bucket wait for it to be full and
+// leave it in debt, so that the average rate is kept.
+type RateLimitedEmbedder struct {
+	embedder Embedder
+	perInput bool
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// NewRateLimitedEmbedder wraps embedder in a RateLimitedEmbedder. The bucket starts full.
+func NewRateLimitedEmbedder(embedder Embedder, config RateLimitConfig) (*RateLimitedEmbedder, error) {
+	if embedder == nil {
+		return nil, errors.New("embedder is required")
+	}
+	if config.Rate <= 0 || math.IsInf(config.Rate, 0) || math.IsNaN(config.Rate) {
+		return nil, errors.New("rate must be a positive number")
+	}
+	if config.Burst < 0 {
+		return nil, errors.New("burst must be greater than or equal to zero")
+	}
+	if config.Burst == 0 {
+		config.Burst = max(int(math.Ceil(config.Rate)), 1)
+	}
+
+	return &RateLimitedEmbedder{
+		embedder: embedder,
+		perInput: config.PerInput,
+		rate:     config.Rate,
+		burst:    float64(config.Burst),
+		tokens:   float64(config.Burst),
+		last:     time.Now(),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}, nil
+}
+
+// Embed waits for the tokens the call costs and calls the wrapped embedder.
+func (l *RateLimitedEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	cost := 1.0
+	if l.perInput {
+		cost = float64(len(inputs))
+	}
+	if err := l.wait(ctx, cost); err != nil {
+		return nil, err
+	}
+	return l.embedder.Embed(ctx, inputs, embeddingType)
+}
+
+// wait takes cost tokens from the bucket, waiting until they have been added.
+// If ctx is done first, the tokens are returned to the bucket.
+func (l *RateLimitedEmbedder) wait(ctx context.Context, cost float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	// Reserve the tokens right away, so that calls are served in order.
+	l.tokens -= cost
+	// A call that costs more than the burst only waits for a full bucket.
+	deficit := -l.tokens - max(cost-l.burst, 0)
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	delay := time.Duration(deficit / l.rate * float64(time.Second))
+	if err := l.sleep(ctx, delay); err != nil {
+		l.mu.Lock()
+		l.tokens += cost
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingEmbedder returns an embedding per input that holds the length of the input,
+// and records the inputs of every call.
+type recordingEmbedder struct {
+	mu    sync.Mutex
+	calls [][]string
+	errs  []error // returned by the calls in order, until they run out.
+}
+
+func (e *recordingEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	e.mu.Lock()
+	e.calls = append(e.calls, inputs)
+	var err error
+	if len(e.calls) <= len(e.errs) {
+		err = e.errs[len(e.calls)-1]
+	}
+	e.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	embeddings := make([][]float64, len(inputs))
+	for i, input := range inputs {
+		embeddings[i] = []float64{float64(len(input))}
+	}
+	return embeddings, nil
+}
+
+func TestEmbedderAdapters(t *testing.T) {
+	embeddingFunc := EmbeddingFunc(func(inputs []string, embeddingType string) ([][]float64, error) {
+		return [][]float64{{1.0, 0.0}}, nil
+	})
+
+	var embedder Embedder = embeddingFunc
+	embeddings, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1.0, 0.0}}, embeddings)
+
+	embedder = embeddingFunc.WithContext()
+	embeddings, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1.0, 0.0}}, embeddings)
+
+	collection, err := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, embedder)
+	require.NoError(t, err)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "content"}))
+	results, err := collection.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	_, err = NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, nil)
+	assert.Error(t, err)
+}
+
+func TestBatchEmbedder(t *testing.T) {
+	_, err := NewBatchEmbedder(nil, BatchConfig{MaxBatchSize: 1})
+	assert.Error(t, err)
+	_, err = NewBatchEmbedder(&recordingEmbedder{}, BatchConfig{})
+	assert.Error(t, err)
+	_, err = NewBatchEmbedder(&recordingEmbedder{}, BatchConfig{MaxBatchSize: 1, Concurrency: -1})
+	assert.Error(t, err)
+
+	inner := &recordingEmbedder{}
+	embedder, err := NewBatchEmbedder(inner, BatchConfig{MaxBatchSize: 2, Concurrency: 2})
+	require.NoError(t, err)
+
+	inputs := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	embeddings, err := embedder.Embed(context.Background(), inputs, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{1}, {2}, {3}, {4}, {5}}, embeddings)
+
+	// The batches may run in any order.
+	var batches []string
+	for _, call := range inner.calls {
+		batches = append(batches, fmt.Sprint(call))
+	}
+	sort.Strings(batches)
+	assert.Equal(t, []string{"[a bb]", "[ccc dddd]", "[eeeee]"}, batches)
+
+	// A small input is passed through in a single call.
+	inner.calls = nil
+	_, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+	require.NoError(t, err)
+	assert.Len(t, inner.calls, 1)
+
+	// The first error is returned.
+	failing := &recordingEmbedder{errs: []error{errors.New("backend down")}}
+	embedder, _ = NewBatchEmbedder(failing, BatchConfig{MaxBatchSize: 1})
+	_, err = embedder.Embed(context.Background(), inputs, "docType")
+	assert.EqualError(t, err, "backend down")
+	assert.Len(t, failing.calls, 1)
+
+	// Batches with the wrong number of embeddings are rejected.
+	short := ContextEmbeddingFunc(func(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+		return [][]float64{{1}}, nil
+	})
+	embedder, _ = NewBatchEmbedder(short, BatchConfig{MaxBatchSize: 2})
+	_, err = embedder.Embed(context.Background(), inputs, "docType")
+	assert.Error(t, err)
+}
+
+// timeoutError is a network error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"Nil", nil, false},
+		{"Plain", errors.New("bad request"), false},
+		{"Marked", Transient(errors.New("overloaded")), true},
+		{"Wrapped Marked", fmt.Errorf("embedding: %w", Transient(errors.New("overloaded"))), true},
+		{"Network Timeout", &net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{"Canceled", Transient(context.Canceled), false},
+		{"Deadline", context.DeadlineExceeded, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsTransient(tt.err))
+		})
+	}
+
+	assert.Nil(t, Transient(nil))
+	assert.EqualError(t, Transient(errors.New("overloaded")), "overloaded")
+}
+
+// retryAfterError is a transient error that asks to wait before retrying.
+type retryAfterError struct {
+	after time.Duration
+}
+
+func (e retryAfterError) Error() string             { return "rate limited" }
+func (e retryAfterError) Transient() bool           { return true }
+func (e retryAfterError) RetryAfter() time.Duration { return e.after }
+
+func TestRetryEmbedder(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RetryConfig
+		wantErr bool
+	}{
+		{"Defaults", RetryConfig{}, false},
+		{"Negative Attempts", RetryConfig{MaxAttempts: -1}, true},
+		{"Multiplier Below One", RetryConfig{Multiplier: 0.5}, true},
+		{"Jitter Too Large", RetryConfig{Jitter: 2}, true},
+		{"No Jitter", RetryConfig{NoJitter: true}, false},
+		{"Jitter With No Jitter", RetryConfig{Jitter: 0.5, NoJitter: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRetryEmbedder(&recordingEmbedder{}, tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	newEmbedder := func(inner Embedder, config RetryConfig) (*RetryEmbedder, *[]time.Duration) {
+		embedder, err := NewRetryEmbedder(inner, config)
+		require.NoError(t, err)
+		var delays []time.Duration
+		embedder.sleep = func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return ctx.Err()
+		}
+		return embedder, &delays
+	}
+
+	t.Run("Backoff", func(t *testing.T) {
+		overloaded := Transient(errors.New("overloaded"))
+		inner := &recordingEmbedder{errs: []error{overloaded, overloaded, overloaded}}
+		embedder, delays := newEmbedder(inner, RetryConfig{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Jitter: 0.5})
+
+		embeddings, err := embedder.Embed(context.Background(), []string{"ab"}, "docType")
+		require.NoError(t, err)
+		assert.Equal(t, [][]float64{{2}}, embeddings)
+		assert.Len(t, inner.calls, 4)
+
+		require.Len(t, *delays, 3)
+		for i, max := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+			assert.LessOrEqual(t, (*delays)[i], max)
+			assert.GreaterOrEqual(t, (*delays)[i], max/2)
+		}
+	})
+
+	t.Run("No Jitter", func(t *testing.T) {
+		overloaded := Transient(errors.New("overloaded"))
+		inner := &recordingEmbedder{errs: []error{overloaded, overloaded, overloaded}}
+		embedder, delays := newEmbedder(inner, RetryConfig{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, NoJitter: true})
+
+		_, err := embedder.Embed(context.Background(), []string{"ab"}, "docType")
+		require.NoError(t, err)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, *delays)
+	})
+
+	t.Run("Exhausted", func(t *testing.T) {
+		inner := &recordingEmbedder{errs: []error{Transient(errors.New("1")), Transient(errors.New("2"))}}
+		embedder, _ := newEmbedder(inner, RetryConfig{MaxAttempts: 2})
+		_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+		assert.EqualError(t, err, "2")
+		assert.Len(t, inner.calls, 2)
+	})
+
+	t.Run("Not Transient", func(t *testing.T) {
+		inner := &recordingEmbedder{errs: []error{errors.New("bad request")}}
+		embedder, delays := newEmbedder(inner, RetryConfig{})
+		_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+		assert.EqualError(t, err, "bad request")
+		assert.Len(t, inner.calls, 1)
+		assert.Empty(t, *delays)
+	})
+
+	t.Run("Custom Classifier", func(t *testing.T) {
+		inner := &recordingEmbedder{errs: []error{errors.New("bad request")}}
+		embedder, _ := newEmbedder(inner, RetryConfig{IsTransient: func(err error) bool { return true }})
+		_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+		assert.NoError(t, err)
+		assert.Len(t, inner.calls, 2)
+	})
+
+	t.Run("Retry After", func(t *testing.T) {
+		inner := &recordingEmbedder{errs: []error{retryAfterError{after: time.Minute}}}
+		embedder, delays := newEmbedder(inner, RetryConfig{})
+		_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+		require.NoError(t, err)
+		assert.Equal(t, []time.Duration{time.Minute}, *delays)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		inner := &recordingEmbedder{errs: []error{Transient(errors.New("overloaded"))}}
+		embedder, _ := NewRetryEmbedder(inner, RetryConfig{InitialBackoff: time.Hour})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := embedder.Embed(ctx, []string{"a"}, "docType")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestRateLimitedEmbedder(t *testing.T) {
+	_, err := NewRateLimitedEmbedder(&recordingEmbedder{}, RateLimitConfig{})
+	assert.Error(t, err)
+	_, err = NewRateLimitedEmbedder(&recordingEmbedder{}, RateLimitConfig{Rate: 1, Burst: -1})
+	assert.Error(t, err)
+
+	newEmbedder := func(config RateLimitConfig) (*RateLimitedEmbedder, *time.Time, *[]time.Duration) {
+		embedder, err := NewRateLimitedEmbedder(&recordingEmbedder{}, config)
+		require.NoError(t, err)
+		now := time.Unix(0, 0)
+		embedder.last = now
+		embedder.now = func() time.Time { return now }
+		var delays []time.Duration
+		embedder.sleep = func(ctx context.Context, d time.Duration) error {
+			delays = append(delays, d)
+			return ctx.Err()
+		}
+		return embedder, &now, &delays
+	}
+
+	t.Run("Requests", func(t *testing.T) {
+		embedder, now, delays := newEmbedder(RateLimitConfig{Rate: 2, Burst: 2})
+
+		// The bucket starts full, and then refills at two tokens per second.
+		for i := 0; i < 4; i++ {
+			_, err := embedder.Embed(context.Background(), []string{"a", "b", "c"}, "docType")
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, *delays)
+
+		*now = now.Add(10 * time.Second)
+		*delays = nil
+		_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+		require.NoError(t, err)
+		assert.Empty(t, *delays)
+	})
+
+	t.Run("Per Input", func(t *testing.T) {
+		embedder, _, delays := newEmbedder(RateLimitConfig{Rate: 10, PerInput: true})
+
+		_, err := embedder.Embed(context.Background(), make([]string, 4), "docType")
+		require.NoError(t, err)
+		_, err = embedder.Embed(context.Background(), make([]string, 8), "docType")
+		require.NoError(t, err)
+		// A call larger than the burst waits for a full bucket and leaves it in debt.
+		_, err = embedder.Embed(context.Background(), make([]string, 20), "docType")
+		require.NoError(t, err)
+		assert.Equal(t, []time.Duration{200 * time.Millisecond, 1200 * time.Millisecond}, *delays)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		embedder, _, _ := newEmbedder(RateLimitConfig{Rate: 1})
+		_, err := embedder.Embed(context.Background(), []string{"a"}, "docType")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = embedder.Embed(ctx, []string{"a"}, "docType")
+		assert.ErrorIs(t, err, context.Canceled)
+		// The tokens of a canceled call are returned.
+		assert.Equal(t, 0.0, embedder.tokens)
+	})
+}
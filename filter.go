@@ -0,0 +1,548 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Filter is a filter expression over document metadata, modeled after MongoDB queries.
+//
+// Each key is either a metadata key or a logical operator. A metadata key maps to a value,
+// which matches documents whose metadata has an equal value, or to a map of comparison
+// operators that must all hold:
+//
+//	$eq, $ne             equal, not equal
+//	$gt, $gte, $lt, $lte numeric, time and string ranges
+//	$in, $nin            value is, or is not, one of a list of values
+//	$exists              key is present (true) or absent (false)
+//
+// The logical operators are $and and $or, which take a list of filters, and $not, which
+// takes a single filter. Several keys in one filter must all match.
+//
+// The $segment operator takes a filter over the metadata of segments, such as the headings
+// recorded by a MarkdownSplitter, and restricts a query to the segments that match it:
+//
+//	vector.Filter{"$segment": vector.Filter{vector.HeadingsKey: "Linux"}}
+//
+// Numbers of different types compare by value, and times by instant: time.Time values and
+// strings in RFC 3339 format compare with each other whatever their time zones, in equality
+// as well as in ranges, so two strings for the same instant are equal. If the metadata value
+// is a slice, equality and $in match when any element matches, as with MongoDB arrays.
+//
+//	vector.Filter{
+//		"tenant_id": "acme",
+//		"year":      vector.Filter{"$gte": 2020},
+//		"$or": []vector.Filter{
+//			{"lang": vector.Filter{"$in": []string{"en", "de"}}},
+//			{"translated": true},
+//		},
+//	}
+type Filter map[string]interface{}
+
+// metadataMatcher reports whether the metadata of a document, and of one of its segments,
+// matches a compiled filter. The segment metadata is nil when matching a document as a whole.
+type metadataMatcher func(metadata, segmentMetadata map[string]interface{}) bool
+
+// compileFilter validates a filter and compiles it into a matcher.
+// An empty filter matches every document.
+func compileFilter(filter Filter) (metadataMatcher, error) {
+	// Sort the keys so that errors are reported deterministically.
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	matchers := make([]metadataMatcher, 0, len(keys))
+	for _, key := range keys {
+		matcher, err := compileFilterKey(key, filter[key])
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+
+	return func(metadata, segmentMetadata map[string]interface{}) bool {
+		for _, matcher := range matchers {
+			if !matcher(metadata, segmentMetadata) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// compileFilterKey compiles a single key of a filter and its value.
+func compileFilterKey(key string, value interface{}) (metadataMatcher, error) {
+	switch key {
+	case "$and", "$or":
+		filters, err := toFilterList(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		matchers := make([]metadataMatcher, len(filters))
+		for i, f := range filters {
+			if matchers[i], err = compileFilter(f); err != nil {
+				return nil, err
+			}
+		}
+		if key == "$and" {
+			return func(metadata, segmentMetadata map[string]interface{}) bool {
+				for _, matcher := range matchers {
+					if !matcher(metadata, segmentMetadata) {
+						return false
+					}
+				}
+				return true
+			}, nil
+		}
+		return func(metadata, segmentMetadata map[string]interface{}) bool {
+			for _, matcher := range matchers {
+				if matcher(metadata, segmentMetadata) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "$not":
+		f, ok := toFilter(value)
+		if !ok {
+			return nil, errors.New("$not: value must be a filter")
+		}
+		matcher, err := compileFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(metadata, segmentMetadata map[string]interface{}) bool {
+			return !matcher(metadata, segmentMetadata)
+		}, nil
+	case "$segment":
+		f, ok := toFilter(value)
+		if !ok {
+			return nil, errors.New("$segment: value must be a filter")
+		}
+		matcher, err := compileFilter(f)
+		if err != nil {
+			return nil, err
+		}
+		return func(metadata, segmentMetadata map[string]interface{}) bool {
+			return matcher(segmentMetadata, nil)
+		}, nil
+	}
+
+	if strings.HasPrefix(key, "$") {
+		return nil, fmt.Errorf("unknown logical operator %s", key)
+	}
+
+	operators, ok := toFilter(value)
+	if !ok || !isOperatorFilter(operators) {
+		// A plain value is shorthand for $eq.
+		operators = Filter{"$eq": value}
+	}
+	return compileOperators(key, operators)
+}
+
+// isOperatorFilter reports whether every key of f is an operator.
+func isOperatorFilter(f Filter) bool {
+	if len(f) == 0 {
+		return false
+	}
+	for key := range f {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// compileOperators compiles the comparison operators applied to the metadata key.
+func compileOperators(key string, operators Filter) (metadataMatcher, error) {
+	names := make([]string, 0, len(operators))
+	for name := range operators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type predicate func(value interface{}, exists bool) bool
+	predicates := make([]predicate, 0, len(names))
+
+	for _, name := range names {
+		operand := operators[name]
+		switch name {
+		case "$eq":
+			predicates = append(predicates, func(value interface{}, exists bool) bool {
+				return exists && matchesAny(value, operand)
+			})
+		case "$ne":
+			predicates = append(predicates, func(value interface{}, exists bool) bool {
+				return !exists || !matchesAny(value, operand)
+			})
+		case "$gt", "$gte", "$lt", "$lte":
+			if !isComparable(operand) {
+				return nil, fmt.Errorf("%s: %s operand must be a number, string or time", key, name)
+			}
+			name := name
+			predicates = append(predicates, func(value interface{}, exists bool) bool {
+				if !exists {
+					return false
+				}
+				cmp, ok := compareValues(value, operand)
+				if !ok {
+					return false
+				}
+				switch name {
+				case "$gt":
+					return cmp > 0
+				case "$gte":
+					return cmp >= 0
+				case "$lt":
+					return cmp < 0
+				default:
+					return cmp <= 0
+				}
+			})
+		case "$in", "$nin":
+			list, ok := toList(operand)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s operand must be a list", key, name)
+			}
+			in := func(value interface{}) bool {
+				for _, candidate := range list {
+					if matchesAny(value, candidate) {
+						return true
+					}
+				}
+				return false
+			}
+			if name == "$in" {
+				predicates = append(predicates, func(value interface{}, exists bool) bool {
+					return exists && in(value)
+				})
+			} else {
+				predicates = append(predicates, func(value interface{}, exists bool) bool {
+					return !exists || !in(value)
+				})
+			}
+		case "$exists":
+			want, ok := operand.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: $exists operand must be a boolean", key)
+			}
+			predicates = append(predicates, func(value interface{}, exists bool) bool {
+				return exists == want
+			})
+		default:
+			return nil, fmt.Errorf("%s: unknown operator %s", key, name)
+		}
+	}
+
+	return func(metadata, segmentMetadata map[string]interface{}) bool {
+		value, exists := metadata[key]
+		for _, p := range predicates {
+			if !p(value, exists) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// toFilter converts a filter operand to a Filter.
+func toFilter(value interface{}) (Filter, bool) {
+	switch v := value.(type) {
+	case Filter:
+		return v, true
+	case map[string]interface{}:
+		return Filter(v), true
+	default:
+		return nil, false
+	}
+}
+
+// toFilterList converts the operand of $and and $or to a list of filters.
+func toFilterList(value interface{}) ([]Filter, error) {
+	if filters, ok := value.([]Filter); ok {
+		return filters, nil
+	}
+	list, ok := toList(value)
+	if !ok {
+		return nil, errors.New("value must be a list of filters")
+	}
+	filters := make([]Filter, len(list))
+	for i, item := range list {
+		f, ok := toFilter(item)
+		if !ok {
+			return nil, errors.New("value must be a list of filters")
+		}
+		filters[i] = f
+	}
+	return filters, nil
+}
+
+// toList converts a slice or array of any element type to a list of values.
+func toList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	// Byte slices are treated as scalar values.
+	if v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// matchesAny reports whether value equals operand or, if value is a list, any of its elements does.
+func matchesAny(value, operand interface{}) bool {
+	if list, ok := toList(value); ok {
+		if _, operandIsList := toList(operand); !operandIsList {
+			for _, element := range list {
+				if valuesEqual(element, operand) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return valuesEqual(value, operand)
+}
+
+// valuesEqual reports whether two metadata values are equal, comparing numbers by value
+// and times by instant.
+func valuesEqual(a, b interface{}) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// isComparable reports whether value can be used as a range operand.
+func isComparable(value interface{}) bool {
+	if _, ok := toFloat(value); ok {
+		return true
+	}
+	switch value.(type) {
+	case string, time.Time:
+		return true
+	}
+	return false
+}
+
+// compareValues compares two numbers, times or strings, returning -1, 0 or 1.
+// Strings in RFC 3339 format compare as times, so that offsets are taken into account.
+// It reports false if the values cannot be compared.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	if ta, ok := toTime(a); ok {
+		if tb, ok := toTime(b); ok {
+			return ta.Compare(tb), true
+		}
+	}
+	sa, aIsString := a.(string)
+	sb, bIsString := b.(string)
+	if aIsString && bIsString {
+		return strings.Compare(sa, sb), true
+	}
+	return 0, false
+}
+
+// toFloat converts any numeric value to a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// toTime converts a time.Time or a string in RFC 3339 format to a time.Time.
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
+// selection is the set of documents a query is restricted to.
+type selection struct {
+	// candidates is a superset of the matching document IDs planned from the metadata
+	// indexes, or nil if every document must be checked.
+	candidates map[string]struct{}
+	match      func(doc *Document) bool
+	// matchSegment is nil unless the filter uses $segment, in which case only some segments
+	// of a selected document may match.
+	matchSegment func(doc *Document, segment *Segment) bool
+}
+
+// selects reports whether doc is selected. A nil selection selects every document.
+func (sel *selection) selects(doc *Document) bool {
+	return sel == nil || sel.match(doc)
+}
+
+// selectsSegment reports whether segment of the selected document doc is selected.
+func (sel *selection) selectsSegment(doc *Document, segment *Segment) bool {
+	return sel == nil || sel.matchSegment == nil || sel.matchSegment(doc, segment)
+}
+
+// selectDocuments returns the selection of documents matching the compiled filter,
+// or nil if the filter is empty. The candidates are planned from the metadata indexes.
+// The caller must hold c.documentsLock.
+func (c *Collection) selectDocuments(filter Filter, matcher metadataMatcher) *selection {
+	if len(filter) == 0 {
+		return nil
+	}
+	sel := &selection{
+		match: func(doc *Document) bool {
+			return matcher(doc.Metadata, nil)
+		},
+	}
+	if usesSegmentFilter(filter) {
+		// A document is selected if any of its segments matches.
+		sel.matchSegment = func(doc *Document, segment *Segment) bool {
+			return matcher(doc.Metadata, segment.Metadata)
+		}
+		sel.match = func(doc *Document) bool {
+			for _, segment := range doc.Segments {
+				if sel.matchSegment(doc, segment) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if candidates, ok := c.planFilter(filter); ok {
+		sel.candidates = candidates
+	}
+	return sel
+}
+
+// usesSegmentFilter reports whether filter matches segment metadata with $segment.
+func usesSegmentFilter(filter Filter) bool {
+	for key, value := range filter {
+		switch key {
+		case "$segment":
+			return true
+		case "$and", "$or":
+			filters, _ := toFilterList(value)
+			for _, f := range filters {
+				if usesSegmentFilter(f) {
+					return true
+				}
+			}
+		case "$not":
+			if f, ok := toFilter(value); ok && usesSegmentFilter(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contextCheckInterval is the number of documents visited between checks of whether
+// the context of a scan is done.
+const contextCheckInterval = 1024
+
+// eachSelectedDocument calls fn for every selected document, visiting only the planned
+// candidates if there are any. It stops early and returns the error of ctx if ctx is done.
+// The caller must hold c.documentsLock.
+func (c *Collection) eachSelectedDocument(ctx context.Context, sel *selection, fn func(doc *Document)) error {
+	visited := 0
+	visit := func(doc *Document) error {
+		if visited%contextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		visited++
+		if sel.selects(doc) {
+			fn(doc)
+		}
+		return nil
+	}
+
+	if sel != nil && sel.candidates != nil {
+		for id := range sel.candidates {
+			if doc, ok := c.documents[id]; ok {
+				if err := visit(doc); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, doc := range c.documents {
+		if err := visit(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query retrieves the top N segments most similar to the given query among the documents
+// whose metadata matches filter. The filter is applied before the top N are selected,
+// so up to topN matching results are returned even if most documents do not match.
+// Metadata keys with an index created by CreateMetadataIndex narrow down the documents
+// to check before any segment is scored.
+// A nil filter matches every document.
+func (c *Collection) Query(query string, topN int, filter Filter) ([]Result, error) {
+	return c.QueryContext(context.Background(), query, topN, filter)
+}
+
+// QueryContext is like Query, but gives up when ctx is done.
+func (c *Collection) QueryContext(ctx context.Context, query string, topN int, filter Filter) ([]Result, error) {
+	return c.getTopNSimilarDocuments(ctx, query, topN, false, filter)
+}
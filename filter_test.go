@@ -0,0 +1,245 @@
+package vector
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileFilter(t *testing.T) {
+	published := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	metadata := map[string]interface{}{
+		"author":    "Alice",
+		"year":      2024,
+		"rating":    4.5,
+		"tags":      []string{"go", "search"},
+		"published": published,
+		"updated":   "2024-06-01T00:00:00Z",
+		"draft":     false,
+	}
+
+	testCases := []struct {
+		name     string
+		filter   Filter
+		expected bool
+	}{
+		{"Empty", nil, true},
+		{"Equality", Filter{"author": "Alice"}, true},
+		{"Equality Mismatch", Filter{"author": "Bob"}, false},
+		{"Equality Missing Key", Filter{"editor": "Alice"}, false},
+		{"Equality Across Number Types", Filter{"year": 2024.0}, true},
+		{"Equality With Slice Element", Filter{"tags": "go"}, true},
+		{"Equality With Whole Slice", Filter{"tags": []string{"go", "search"}}, true},
+		{"Eq Operator", Filter{"draft": Filter{"$eq": false}}, true},
+		{"Ne Operator", Filter{"author": Filter{"$ne": "Bob"}}, true},
+		{"Ne Missing Key", Filter{"editor": Filter{"$ne": "Bob"}}, true},
+		{"Range", Filter{"year": Filter{"$gte": 2020, "$lt": 2025}}, true},
+		{"Range Mismatch", Filter{"rating": Filter{"$gt": 4.5}}, false},
+		{"Range Inclusive", Filter{"rating": Filter{"$lte": 4.5}}, true},
+		{"Range On Missing Key", Filter{"pages": Filter{"$gt": 1}}, false},
+		{"Range On Wrong Type", Filter{"author": Filter{"$gt": 1}}, false},
+		{"String Range", Filter{"author": Filter{"$gte": "A", "$lt": "B"}}, true},
+		{"Time Range", Filter{"published": Filter{"$gte": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}, true},
+		{"Time Range With String Operand", Filter{"published": Filter{"$lt": "2024-02-01T00:00:00Z"}}, false},
+		{"Time Range On String Value", Filter{"updated": Filter{"$gt": published}}, true},
+		{"Time String Range Across Offsets", Filter{"updated": Filter{"$gt": "2024-06-01T01:00:00+02:00"}}, true},
+		{"Time String Equal Across Offsets", Filter{"updated": "2024-06-01T02:00:00+02:00"}, true},
+		{"In", Filter{"author": Filter{"$in": []string{"Bob", "Alice"}}}, true},
+		{"In Mismatch", Filter{"author": Filter{"$in": []interface{}{"Bob", 1}}}, false},
+		{"In With Slice Value", Filter{"tags": Filter{"$in": []string{"rust", "search"}}}, true},
+		{"Nin", Filter{"author": Filter{"$nin": []string{"Bob"}}}, true},
+		{"Nin Mismatch", Filter{"year": Filter{"$nin": []int{2023, 2024}}}, false},
+		{"Exists", Filter{"author": Filter{"$exists": true}}, true},
+		{"Not Exists", Filter{"editor": Filter{"$exists": false}}, true},
+		{"Implicit And", Filter{"author": "Alice", "year": 2023}, false},
+		{"And", Filter{"$and": []Filter{{"author": "Alice"}, {"year": 2024}}}, true},
+		{"Or", Filter{"$or": []Filter{{"author": "Bob"}, {"year": 2024}}}, true},
+		{"Or Mismatch", Filter{"$or": []interface{}{map[string]interface{}{"author": "Bob"}, Filter{"year": 2023}}}, false},
+		{"Not", Filter{"$not": Filter{"author": "Bob"}}, true},
+		{"Nested", Filter{"$or": []Filter{{"$not": Filter{"draft": false}}, {"$and": []Filter{{"tags": "go"}, {"rating": Filter{"$gte": 4}}}}}}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matcher, err := compileFilter(tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, matcher(metadata, nil))
+		})
+	}
+}
+
+func TestCompileFilter_Segment(t *testing.T) {
+	metadata := map[string]interface{}{"author": "Alice"}
+	segmentMetadata := map[string]interface{}{"headings": []string{"Install", "Linux"}}
+
+	testCases := []struct {
+		name     string
+		filter   Filter
+		expected bool
+	}{
+		{"Segment", Filter{"$segment": Filter{"headings": "Linux"}}, true},
+		{"Segment Mismatch", Filter{"$segment": Filter{"headings": "macOS"}}, false},
+		{"Segment Ignores Document", Filter{"$segment": Filter{"author": "Alice"}}, false},
+		{"Document Ignores Segment", Filter{"headings": "Linux"}, false},
+		{"With Document", Filter{"author": "Alice", "$segment": Filter{"headings": "Install"}}, true},
+		{"In Or", Filter{"$or": []Filter{{"author": "Bob"}, {"$segment": Filter{"headings": "Linux"}}}}, true},
+		{"In Not", Filter{"$not": Filter{"$segment": Filter{"headings": "Linux"}}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			matcher, err := compileFilter(tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, matcher(metadata, segmentMetadata))
+		})
+	}
+
+	// Without segment metadata, segment filters match as if it were empty.
+	matcher, err := compileFilter(Filter{"$segment": Filter{"headings": Filter{"$exists": false}}})
+	require.NoError(t, err)
+	assert.True(t, matcher(metadata, nil))
+}
+
+func TestCompileFilter_Invalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter Filter
+	}{
+		{"Unknown Operator", Filter{"year": Filter{"$between": 1}}},
+		{"Unknown Logical Operator", Filter{"$xor": []Filter{}}},
+		{"In Without List", Filter{"year": Filter{"$in": 2024}}},
+		{"Exists Without Bool", Filter{"year": Filter{"$exists": "yes"}}},
+		{"Range Without Comparable", Filter{"year": Filter{"$gt": []int{1}}}},
+		{"And Without List", Filter{"$and": Filter{"year": 2024}}},
+		{"Or With Non Filter", Filter{"$or": []interface{}{"year"}}},
+		{"Not Without Filter", Filter{"$not": "year"}},
+		{"Nested Invalid", Filter{"$and": []Filter{{"year": Filter{"$bad": 1}}}}},
+		{"Segment Without Filter", Filter{"$segment": "headings"}},
+		{"Segment Invalid", Filter{"$segment": Filter{"headings": Filter{"$bad": 1}}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := compileFilter(tc.filter)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// newFilterTestCollection creates a collection of n documents with random embeddings,
+// where every tenth document belongs to tenant "a" and the rest to tenant "b".
+func newFilterTestCollection(t *testing.T, n int) *Collection {
+	rng := rand.New(rand.NewSource(11))
+	vectors := randomNormalizedVectors(rng, n+1, 8)
+
+	contents := map[string][]float64{"query": vectors[n]}
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		embeddings := make([][]float64, len(inputs))
+		for i, input := range inputs {
+			embeddings[i] = contents[input]
+		}
+		return embeddings, nil
+	}
+
+	collection, err := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		content := fmt.Sprintf("content %d", i)
+		contents[content] = vectors[i]
+		tenant := "b"
+		if i%10 == 0 {
+			tenant = "a"
+		}
+		require.NoError(t, collection.AddDocument(&Document{
+			ID:       fmt.Sprintf("doc%d", i),
+			Content:  content,
+			Metadata: map[string]interface{}{"tenant": tenant, "n": i},
+		}))
+	}
+	return collection
+}
+
+func TestCollection_Query(t *testing.T) {
+	collection := newFilterTestCollection(t, 200)
+
+	// A selective filter still returns topN matching results.
+	results, err := collection.Query("query", 5, Filter{"tenant": "a"})
+	require.NoError(t, err)
+	require.Len(t, results, 5)
+	for _, result := range results {
+		assert.Equal(t, "a", result.Document.Metadata["tenant"])
+	}
+
+	// Fewer matches than topN return every match.
+	results, err = collection.Query("query", 5, Filter{"n": Filter{"$lt": 3}})
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+
+	results, err = collection.Query("query", 5, Filter{"tenant": "c"})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = collection.Query("query", 5, Filter{"tenant": Filter{"$bad": 1}})
+	assert.Error(t, err)
+
+	// Without a filter, the query matches GetTopNSimilarDocuments.
+	all, err := collection.Query("query", 5, nil)
+	require.NoError(t, err)
+	expected, err := collection.GetTopNSimilarDocuments("query", 5)
+	require.NoError(t, err)
+	assert.Equal(t, expected, all)
+}
+
+func TestCollection_QueryWithIndex(t *testing.T) {
+	collection := newFilterTestCollection(t, 500)
+
+	filters := []Filter{
+		{"tenant": "a"},
+		{"tenant": "b"},
+		{"n": Filter{"$gte": 250}},
+		{"n": Filter{"$in": []int{1, 2, 3}}},
+	}
+
+	// Compute the expected results with an exact scan before setting the index.
+	expected := make([][]Result, len(filters))
+	for i, filter := range filters {
+		results, err := collection.Query("query", 10, filter)
+		require.NoError(t, err)
+		expected[i] = results
+	}
+
+	index, _ := NewHNSWIndex(HNSWConfig{Seed: 1})
+	require.NoError(t, collection.SetIndex(index))
+
+	for i, filter := range filters {
+		results, err := collection.Query("query", 10, filter)
+		require.NoError(t, err)
+		require.Len(t, results, len(expected[i]), "filter %v", filter)
+
+		matcher, _ := compileFilter(filter)
+		for _, result := range results {
+			assert.True(t, matcher(result.Document.Metadata, nil))
+		}
+		assert.Equal(t, expected[i][0].Document.ID, results[0].Document.ID)
+	}
+}
+
+func TestCollection_QueryQuantized(t *testing.T) {
+	collection := newFilterTestCollection(t, 100)
+	require.NoError(t, collection.EnableQuantization(NewScalarQuantizer(false), QuantizationOptions{}))
+
+	results, err := collection.Query("query", 3, Filter{"tenant": "a"})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		assert.Equal(t, "a", result.Document.Metadata["tenant"])
+	}
+
+	results, err = collection.Query("query", 3, Filter{"tenant": "c"})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
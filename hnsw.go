@@ -0,0 +1,423 @@
+package vector
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// HNSWConfig configures a hierarchical navigable small world index.
+// Zero values are replaced by the defaults noted on each field.
+type HNSWConfig struct {
+	// M is the number of neighbors each node keeps on the upper layers.
+	// The bottom layer keeps up to 2*M neighbors. Defaults to 16.
+	M int
+	// EfConstruction is the size of the candidate list used while inserting. Defaults to 200.
+	EfConstruction int
+	// EfSearch is the size of the candidate list used while searching. Larger values
+	// improve recall at the cost of speed. It is raised to topN when smaller. Defaults to 50.
+	EfSearch int
+	// Seed seeds the random level generator, which makes the graph reproducible.
+	Seed int64
+}
+
+const (
+	defaultHNSWM              = 16
+	defaultHNSWEfConstruction = 200
+	defaultHNSWEfSearch       = 50
+)
+
+// HNSWIndex is an approximate nearest neighbor index based on the HNSW algorithm
+// (Malkov and Yashunin, 2016). Similarity is the dot product of normalized vectors.
+//
+// Removed entries are marked as deleted and skipped in results, but stay in the graph
+// to keep it connected. The graph is rebuilt once deleted entries outnumber live ones.
+type HNSWIndex struct {
+	mu             sync.RWMutex
+	m              int
+	mMax0          int
+	efConstruction int
+	efSearch       int
+	levelMult      float64
+	rng            *rand.Rand
+	nodes          []*hnswNode
+	ids            map[string]int
+	entry          int
+	maxLevel       int
+	deleted        int
+}
+
+// hnswNode is a single vector in the graph with its neighbor lists per layer.
+type hnswNode struct {
+	id        string
+	vector    []float64
+	neighbors [][]int
+	deleted   bool
+}
+
+// NewHNSWIndex creates an empty HNSW index.
+func NewHNSWIndex(config HNSWConfig) (*HNSWIndex, error) {
+	if config.M < 0 || config.EfConstruction < 0 || config.EfSearch < 0 {
+		return nil, errors.New("HNSW parameters must be greater than or equal to zero")
+	}
+	if config.M == 0 {
+		config.M = defaultHNSWM
+	}
+	if config.M < 2 {
+		return nil, errors.New("HNSW M must be at least 2")
+	}
+	if config.EfConstruction == 0 {
+		config.EfConstruction = defaultHNSWEfConstruction
+	}
+	if config.EfSearch == 0 {
+		config.EfSearch = defaultHNSWEfSearch
+	}
+
+	return &HNSWIndex{
+		m:              config.M,
+		mMax0:          2 * config.M,
+		efConstruction: config.EfConstruction,
+		efSearch:       config.EfSearch,
+		levelMult:      1 / math.Log(float64(config.M)),
+		rng:            rand.New(rand.NewSource(config.Seed)),
+		ids:            make(map[string]int),
+		entry:          -1,
+	}, nil
+}
+
+// SetEfSearch changes the size of the candidate list used by later searches.
+func (h *HNSWIndex) SetEfSearch(ef int) error {
+	if ef <= 0 {
+		return errors.New("efSearch must be greater than zero")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.efSearch = ef
+	return nil
+}
+
+// Len returns the number of live entries in the index.
+func (h *HNSWIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.ids)
+}
+
+// Add inserts the normalized embedding under the given ID, replacing any previous entry.
+func (h *HNSWIndex) Add(id string, embedding []float64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(embedding) == 0 {
+		return errors.New("embedding is empty")
+	}
+	if h.entry >= 0 && len(embedding) != len(h.nodes[h.entry].vector) {
+		return errors.New("embedding length does not match the index")
+	}
+
+	h.remove(id)
+	h.insert(id, embedding)
+	return nil
+}
+
+// Remove deletes the entry with the given ID.
+func (h *HNSWIndex) Remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(id)
+}
+
+// remove marks the node with the given ID as deleted.
+// The caller must hold h.mu for writing.
+func (h *HNSWIndex) remove(id string) {
+	node, ok := h.ids[id]
+	if !ok {
+		return
+	}
+
+	h.nodes[node].deleted = true
+	delete(h.ids, id)
+	h.deleted++
+
+	if len(h.ids) == 0 {
+		h.reset()
+	} else if h.deleted > len(h.ids) {
+		h.rebuild()
+	}
+}
+
+// reset drops every node from the graph.
+// The caller must hold h.mu for writing.
+func (h *HNSWIndex) reset() {
+	h.nodes = nil
+	h.ids = make(map[string]int)
+	h.entry = -1
+	h.maxLevel = 0
+	h.deleted = 0
+}
+
+// rebuild reinserts every live node into a fresh graph, dropping deleted nodes.
+// The caller must hold h.mu for writing.
+func (h *HNSWIndex) rebuild() {
+	nodes := h.nodes
+	h.reset()
+	for _, node := range nodes {
+		if !node.deleted {
+			h.insert(node.id, node.vector)
+		}
+	}
+}
+
+// randomLevel draws the top layer of a new node from an exponentially decaying distribution.
+func (h *HNSWIndex) randomLevel() int {
+	return int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+}
+
+// insert adds a new node to the graph.
+// The caller must hold h.mu for writing.
+func (h *HNSWIndex) insert(id string, vector []float64) {
+	level := h.randomLevel()
+	node := len(h.nodes)
+	h.nodes = append(h.nodes, &hnswNode{
+		id:        id,
+		vector:    vector,
+		neighbors: make([][]int, level+1),
+	})
+	h.ids[id] = node
+
+	if h.entry < 0 {
+		h.entry = node
+		h.maxLevel = level
+		return
+	}
+
+	// Greedily descend through the layers above the new node's top layer.
+	entry := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		entry = h.greedyClosest(vector, entry, l)
+	}
+
+	entryPoints := []hnswCandidate{{node: entry, score: h.score(vector, entry)}}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		candidates := h.searchLayer(vector, entryPoints, h.efConstruction, l, false)
+		neighbors := h.selectNeighbors(candidates, h.m)
+
+		h.nodes[node].neighbors[l] = neighbors
+		for _, neighbor := range neighbors {
+			h.connect(neighbor, node, l)
+		}
+		entryPoints = candidates
+	}
+
+	if level > h.maxLevel {
+		h.entry = node
+		h.maxLevel = level
+	}
+}
+
+// connect adds a link from node to neighbor on layer l, pruning the neighbor list when it grows too long.
+func (h *HNSWIndex) connect(node, neighbor, l int) {
+	maxNeighbors := h.m
+	if l == 0 {
+		maxNeighbors = h.mMax0
+	}
+
+	links := append(h.nodes[node].neighbors[l], neighbor)
+	if len(links) <= maxNeighbors {
+		h.nodes[node].neighbors[l] = links
+		return
+	}
+
+	vector := h.nodes[node].vector
+	candidates := make([]hnswCandidate, len(links))
+	for i, link := range links {
+		candidates[i] = hnswCandidate{node: link, score: h.score(vector, link)}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	h.nodes[node].neighbors[l] = h.selectNeighbors(candidates, maxNeighbors)
+}
+
+// selectNeighbors picks up to m neighbors from candidates sorted by descending score,
+// preferring candidates that are closer to the base vector than to any neighbor already
+// selected. This keeps links pointing in diverse directions. Remaining slots are filled
+// with the best skipped candidates.
+func (h *HNSWIndex) selectNeighbors(candidates []hnswCandidate, m int) []int {
+	selected := make([]int, 0, m)
+	var skipped []int
+
+	for _, candidate := range candidates {
+		if len(selected) == m {
+			break
+		}
+		diverse := true
+		for _, s := range selected {
+			if h.score(h.nodes[candidate.node].vector, s) > candidate.score {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate.node)
+		} else {
+			skipped = append(skipped, candidate.node)
+		}
+	}
+
+	for _, node := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, node)
+	}
+	return selected
+}
+
+// greedyClosest walks layer l from entry towards the node most similar to vector.
+func (h *HNSWIndex) greedyClosest(vector []float64, entry, l int) int {
+	best := entry
+	bestScore := h.score(vector, entry)
+	for changed := true; changed; {
+		changed = false
+		for _, neighbor := range h.nodes[best].neighbors[l] {
+			if score := h.score(vector, neighbor); score > bestScore {
+				best, bestScore = neighbor, score
+				changed = true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns up to ef nodes on layer l most similar to vector,
+// sorted by descending score, starting the search at entryPoints.
+// If liveOnly is set, deleted nodes are traversed but left out of the results.
+func (h *HNSWIndex) searchLayer(vector []float64, entryPoints []hnswCandidate, ef, l int, liveOnly bool) []hnswCandidate {
+	visited := make(map[int]struct{}, ef*4)
+	candidates := &hnswHeap{max: true}
+	results := &hnswHeap{}
+
+	for _, ep := range entryPoints {
+		visited[ep.node] = struct{}{}
+		heap.Push(candidates, ep)
+		if liveOnly && h.nodes[ep.node].deleted {
+			continue
+		}
+		heap.Push(results, ep)
+		if results.Len() > ef {
+			heap.Pop(results)
+		}
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && current.score < results.items[0].score {
+			break
+		}
+
+		for _, neighbor := range h.nodes[current.node].neighbors[l] {
+			if _, ok := visited[neighbor]; ok {
+				continue
+			}
+			visited[neighbor] = struct{}{}
+
+			score := h.score(vector, neighbor)
+			if results.Len() < ef || score > results.items[0].score {
+				heap.Push(candidates, hnswCandidate{node: neighbor, score: score})
+				if liveOnly && h.nodes[neighbor].deleted {
+					continue
+				}
+				heap.Push(results, hnswCandidate{node: neighbor, score: score})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := results.items
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].score > sorted[j].score
+	})
+	return sorted
+}
+
+// score returns the similarity between vector and the given node.
+func (h *HNSWIndex) score(vector []float64, node int) float64 {
+	other := h.nodes[node].vector
+	var dotP float64
+	for i := range vector {
+		dotP += vector[i] * other[i]
+	}
+	return dotP
+}
+
+// Search returns up to topN entries most similar to the normalized query embedding.
+func (h *HNSWIndex) Search(query []float64, topN int) ([]Similarity, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if topN <= 0 || h.entry < 0 {
+		return nil, nil
+	}
+	if len(query) != len(h.nodes[h.entry].vector) {
+		return nil, errors.New("query embedding length does not match embeddings")
+	}
+
+	entry := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		entry = h.greedyClosest(query, entry, l)
+	}
+
+	ef := max(h.efSearch, topN)
+	candidates := h.searchLayer(query, []hnswCandidate{{node: entry, score: h.score(query, entry)}}, ef, 0, true)
+
+	similarities := make([]Similarity, 0, topN)
+	for _, candidate := range candidates {
+		similarities = append(similarities, Similarity{ID: h.nodes[candidate.node].id, Score: candidate.score})
+		if len(similarities) == topN {
+			break
+		}
+	}
+	return similarities, nil
+}
+
+// hnswCandidate is a node with its similarity to the vector being searched or inserted.
+type hnswCandidate struct {
+	node  int
+	score float64
+}
+
+// hnswHeap is a heap of candidates ordered by score.
+// It is a min-heap unless max is set.
+type hnswHeap struct {
+	items []hnswCandidate
+	max   bool
+}
+
+func (h *hnswHeap) Len() int { return len(h.items) }
+
+func (h *hnswHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].score > h.items[j].score
+	}
+	return h.items[i].score < h.items[j].score
+}
+
+func (h *hnswHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *hnswHeap) Push(x interface{}) { h.items = append(h.items, x.(hnswCandidate)) }
+
+func (h *hnswHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
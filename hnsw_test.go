@@ -0,0 +1,216 @@
+package vector
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// randomNormalizedVectors generates n random normalized vectors with the given dimension.
+func randomNormalizedVectors(rng *rand.Rand, n, dim int) [][]float64 {
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		v := make([]float64, dim)
+		for j := range v {
+			v[j] = rng.NormFloat64()
+		}
+		vectors[i], _ = normalizeVector(v)
+	}
+	return vectors
+}
+
+// recallAt returns the fraction of the expected IDs found in got.
+func recallAt(expected, got []Similarity) float64 {
+	found := make(map[string]bool, len(got))
+	for _, sim := range got {
+		found[sim.ID] = true
+	}
+	var hits int
+	for _, sim := range expected {
+		if found[sim.ID] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(expected))
+}
+
+func TestNewHNSWIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  HNSWConfig
+		wantErr bool
+	}{
+		{"Defaults", HNSWConfig{}, false},
+		{"Custom", HNSWConfig{M: 8, EfConstruction: 100, EfSearch: 20}, false},
+		{"M Too Small", HNSWConfig{M: 1}, true},
+		{"Negative EfSearch", HNSWConfig{EfSearch: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHNSWIndex(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHNSWIndex_Recall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vectors := randomNormalizedVectors(rng, 2000, 16)
+	ids := make([]string, len(vectors))
+
+	index, err := NewHNSWIndex(HNSWConfig{M: 12, EfConstruction: 100, EfSearch: 64, Seed: 1})
+	require.NoError(t, err)
+	for i, v := range vectors {
+		ids[i] = fmt.Sprintf("v%d", i)
+		require.NoError(t, index.Add(ids[i], v))
+	}
+	assert.Equal(t, len(vectors), index.Len())
+
+	var total float64
+	queries := randomNormalizedVectors(rng, 50, 16)
+	for _, q := range queries {
+		expected, err := getTopNSimilarEmbeddings(q, vectors, ids, 10)
+		require.NoError(t, err)
+		got, err := index.Search(q, 10)
+		require.NoError(t, err)
+		require.Len(t, got, 10)
+		total += recallAt(expected, got)
+	}
+	assert.GreaterOrEqual(t, total/float64(len(queries)), 0.9)
+
+	_, err = index.Search([]float64{1, 0}, 10)
+	assert.Error(t, err)
+	assert.Error(t, index.Add("bad", []float64{1, 0}))
+}
+
+func TestHNSWIndex_RemoveAndReplace(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vectors := randomNormalizedVectors(rng, 200, 8)
+
+	index, _ := NewHNSWIndex(HNSWConfig{M: 8, Seed: 2})
+	for i, v := range vectors {
+		require.NoError(t, index.Add(fmt.Sprintf("v%d", i), v))
+	}
+
+	// Removed entries never come back, even when most of the graph is deleted and rebuilt.
+	for i := 0; i < 150; i++ {
+		index.Remove(fmt.Sprintf("v%d", i))
+	}
+	index.Remove("unknown")
+	assert.Equal(t, 50, index.Len())
+
+	got, err := index.Search(vectors[0], 50)
+	require.NoError(t, err)
+	assert.Len(t, got, 50)
+	for _, sim := range got {
+		var n int
+		fmt.Sscanf(sim.ID, "v%d", &n)
+		assert.GreaterOrEqual(t, n, 150)
+	}
+
+	// Adding an existing ID replaces its vector.
+	require.NoError(t, index.Add("v199", vectors[0]))
+	assert.Equal(t, 50, index.Len())
+	got, err = index.Search(vectors[0], 1)
+	require.NoError(t, err)
+	assert.Equal(t, "v199", got[0].ID)
+	assert.InDelta(t, 1.0, got[0].Score, 1e-9)
+
+	for i := 150; i < 200; i++ {
+		index.Remove(fmt.Sprintf("v%d", i))
+	}
+	assert.Equal(t, 0, index.Len())
+	got, err = index.Search(vectors[0], 5)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestCollection_SetIndex(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", []string{"first"}, mock.Anything).Return([][]float64{{1.0, 0.0}}, nil)
+	embeddingFunc.On("Embed", []string{"second"}, mock.Anything).Return([][]float64{{0.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"third"}, mock.Anything).Return([][]float64{{1.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"query"}, mock.Anything).Return([][]float64{{0.1, 1.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second"}))
+
+	index, _ := NewHNSWIndex(HNSWConfig{})
+	require.NoError(t, collection.SetIndex(index))
+	assert.Equal(t, 2, index.Len())
+
+	// Later mutations keep the index in sync.
+	require.NoError(t, collection.AddDocument(&Document{ID: "3", Content: "third"}))
+	assert.Equal(t, 3, index.Len())
+	require.NoError(t, collection.DeleteDocument("2"))
+	assert.Equal(t, 2, index.Len())
+
+	results, err := collection.GetTopNSimilarDocuments("query", 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "3", results[0].Document.ID)
+
+	exact, err := collection.GetTopNSimilarDocumentsExact("query", 2)
+	require.NoError(t, err)
+	require.Len(t, exact, 2)
+	for i := range exact {
+		assert.Equal(t, exact[i].Document.ID, results[i].Document.ID)
+		assert.InDelta(t, exact[i].Similarity, results[i].Similarity, 1e-9)
+	}
+
+	require.NoError(t, collection.SetIndex(nil))
+	results, err = collection.GetTopNSimilarDocuments("query", 2)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+// failingIndex is an HNSW index whose Add fails while failing is set.
+type failingIndex struct {
+	*HNSWIndex
+	failing bool
+}
+
+func (f *failingIndex) Add(id string, embedding []float64) error {
+	if f.failing {
+		return errors.New("index full")
+	}
+	return f.HNSWIndex.Add(id, embedding)
+}
+
+func TestCollection_SetIndex_AddFails(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, _ := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 10, embedder)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first"}))
+
+	hnsw, _ := NewHNSWIndex(HNSWConfig{Seed: 1})
+	index := &failingIndex{HNSWIndex: hnsw}
+	require.NoError(t, collection.SetIndex(index))
+
+	// A segment missing from the index is still found, by scanning.
+	index.failing = true
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second"}))
+	assert.Equal(t, 1, index.Len())
+	results, err := collection.GetTopNSimilarDocuments("query", 5)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	// Rebuilding fails while the index does, and then searches the index again.
+	assert.Error(t, collection.RetrainIndex())
+	index.failing = false
+	require.NoError(t, collection.RetrainIndex())
+	assert.Equal(t, 2, index.Len())
+	results, err = collection.GetTopNSimilarDocuments("query", 5)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+}
@@ -0,0 +1,208 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// FusionMethod selects how HybridSearch combines the vector and keyword rankings.
+type FusionMethod int
+
+const (
+	// ReciprocalRankFusion scores every segment by the sum of weight/(k+rank) over the
+	// rankings it appears in (Cormack et al., 2009). It only uses ranks, so the scores of
+	// the two signals need no calibration.
+	ReciprocalRankFusion FusionMethod = iota
+	// WeightedFusion scales the scores of each signal to [0, 1] with min-max normalization
+	// over its candidates, and blends them with the signal weights.
+	WeightedFusion
+)
+
+// HybridOptions configures HybridSearch.
+// Zero values are replaced by the defaults noted on each field.
+type HybridOptions struct {
+	// Fusion is the method used to combine the rankings. Defaults to ReciprocalRankFusion.
+	Fusion FusionMethod
+	// VectorWeight and KeywordWeight weigh the two signals. If both are zero, the signals
+	// are weighted equally.
+	VectorWeight  float64
+	KeywordWeight float64
+	// RRFK is the rank constant of reciprocal rank fusion. Larger values flatten the
+	// difference between top and lower ranks. Defaults to 60.
+	RRFK int
+	// CandidateFactor is the number of candidates each signal retrieves for fusion,
+	// as a multiple of topN. Defaults to 4.
+	CandidateFactor int
+	// Filter restricts both signals to the documents whose metadata matches it, as in Query.
+	Filter Filter
+}
+
+const (
+	defaultRRFK                  = 60
+	defaultHybridCandidateFactor = 4
+)
+
+// hybridScore accumulates the scores of a segment across the signals.
+type hybridScore struct {
+	id      string
+	fused   float64
+	vector  float64
+	keyword float64
+}
+
+// HybridSearch retrieves the top N segments for the query by running embedding similarity,
+// as GetTopNSimilarDocuments does, and BM25 keyword search side by side, and fusing the two
+// rankings with the method selected in opts. The Similarity of each result is its fused
+// score, and VectorScore and KeywordScore report the score of each signal.
+// It requires a keyword index set with SetKeywordIndex.
+func (c *Collection) HybridSearch(query string, topN int, opts HybridOptions) ([]Result, error) {
+	return c.HybridSearchContext(context.Background(), query, topN, opts)
+}
+
+// HybridSearchContext is like HybridSearch, but gives up when ctx is done.
+func (c *Collection) HybridSearchContext(ctx context.Context, query string, topN int, opts HybridOptions) ([]Result, error) {
+	if opts.VectorWeight < 0 || opts.KeywordWeight < 0 {
+		return nil, errors.New("hybrid search weights must be greater than or equal to zero")
+	}
+	if opts.RRFK < 0 || opts.CandidateFactor < 0 {
+		return nil, errors.New("hybrid search parameters must be greater than or equal to zero")
+	}
+	if opts.Fusion != ReciprocalRankFusion && opts.Fusion != WeightedFusion {
+		return nil, fmt.Errorf("unknown fusion method %d", int(opts.Fusion))
+	}
+	if opts.VectorWeight == 0 && opts.KeywordWeight == 0 {
+		opts.VectorWeight, opts.KeywordWeight = 1, 1
+	}
+	if opts.RRFK == 0 {
+		opts.RRFK = defaultRRFK
+	}
+	if opts.CandidateFactor == 0 {
+		opts.CandidateFactor = defaultHybridCandidateFactor
+	}
+
+	matcher, err := compileFilter(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	queryEmbedding, err := c.embedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	sel := c.selectDocuments(opts.Filter, matcher)
+	candidates := topN * opts.CandidateFactor
+
+	keywordSimilarities, err := c.keywordSearch(ctx, query, candidates, sel)
+	if err != nil {
+		return nil, err
+	}
+	vectorSimilarities, err := c.search(ctx, queryEmbedding, candidates, false, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := fuseRankings(vectorSimilarities, keywordSimilarities, opts)
+	if topN < len(scores) {
+		scores = scores[:max(topN, 0)]
+	}
+
+	results := make([]Result, 0, len(scores))
+	for _, score := range scores {
+		doc, segment, ok := c.resolveSegment(score.id)
+		if !ok {
+			continue
+		}
+		results = append(results, Result{
+			Document:     doc,
+			Segment:      segment,
+			Similarity:   score.fused,
+			VectorScore:  score.vector,
+			KeywordScore: score.keyword,
+		})
+	}
+	return results, nil
+}
+
+// fuseRankings combines the vector and keyword rankings, each sorted by descending score,
+// into a single ranking sorted by descending fused score.
+func fuseRankings(vectorSimilarities, keywordSimilarities []Similarity, opts HybridOptions) []*hybridScore {
+	scores := make(map[string]*hybridScore)
+	get := func(id string) *hybridScore {
+		score, ok := scores[id]
+		if !ok {
+			score = &hybridScore{id: id}
+			scores[id] = score
+		}
+		return score
+	}
+
+	switch opts.Fusion {
+	case WeightedFusion:
+		total := opts.VectorWeight + opts.KeywordWeight
+		vectorNormalized := normalizeScores(vectorSimilarities)
+		for i, sim := range vectorSimilarities {
+			score := get(sim.ID)
+			score.vector = sim.Score
+			score.fused += opts.VectorWeight / total * vectorNormalized[i]
+		}
+		keywordNormalized := normalizeScores(keywordSimilarities)
+		for i, sim := range keywordSimilarities {
+			score := get(sim.ID)
+			score.keyword = sim.Score
+			score.fused += opts.KeywordWeight / total * keywordNormalized[i]
+		}
+	default:
+		for rank, sim := range vectorSimilarities {
+			score := get(sim.ID)
+			score.vector = sim.Score
+			score.fused += opts.VectorWeight / float64(opts.RRFK+rank+1)
+		}
+		for rank, sim := range keywordSimilarities {
+			score := get(sim.ID)
+			score.keyword = sim.Score
+			score.fused += opts.KeywordWeight / float64(opts.RRFK+rank+1)
+		}
+	}
+
+	fused := make([]*hybridScore, 0, len(scores))
+	for _, score := range scores {
+		fused = append(fused, score)
+	}
+	// Break ties by ID so that results are deterministic.
+	sort.Slice(fused, func(i, j int) bool {
+		if fused[i].fused != fused[j].fused {
+			return fused[i].fused > fused[j].fused
+		}
+		return fused[i].id < fused[j].id
+	})
+	return fused
+}
+
+// normalizeScores scales scores to [0, 1] with min-max normalization.
+// If every score is the same, they are all scaled to 1.
+func normalizeScores(similarities []Similarity) []float64 {
+	normalized := make([]float64, len(similarities))
+	if len(similarities) == 0 {
+		return normalized
+	}
+
+	lowest, highest := similarities[0].Score, similarities[0].Score
+	for _, sim := range similarities {
+		lowest = min(lowest, sim.Score)
+		highest = max(highest, sim.Score)
+	}
+	for i, sim := range similarities {
+		if highest == lowest {
+			normalized[i] = 1
+		} else {
+			normalized[i] = (sim.Score - lowest) / (highest - lowest)
+		}
+	}
+	return normalized
+}
@@ -0,0 +1,140 @@
+package vector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuseRankings(t *testing.T) {
+	vectorSimilarities := []Similarity{{ID: "a", Score: 0.9}, {ID: "b", Score: 0.5}, {ID: "c", Score: 0.1}}
+	keywordSimilarities := []Similarity{{ID: "c", Score: 12}, {ID: "d", Score: 4}}
+
+	t.Run("Reciprocal Rank Fusion", func(t *testing.T) {
+		fused := fuseRankings(vectorSimilarities, keywordSimilarities, HybridOptions{VectorWeight: 1, KeywordWeight: 1, RRFK: 60})
+		require.Len(t, fused, 4)
+		assert.Equal(t, "c", fused[0].id)
+		assert.InDelta(t, 1.0/63+1.0/61, fused[0].fused, 1e-12)
+		assert.Equal(t, 0.1, fused[0].vector)
+		assert.Equal(t, 12.0, fused[0].keyword)
+		assert.Equal(t, "a", fused[1].id)
+		assert.Equal(t, 0.0, fused[1].keyword)
+		assert.Equal(t, "b", fused[2].id)
+		assert.Equal(t, "d", fused[3].id)
+	})
+
+	t.Run("Weighted Fusion", func(t *testing.T) {
+		fused := fuseRankings(vectorSimilarities, keywordSimilarities, HybridOptions{Fusion: WeightedFusion, VectorWeight: 3, KeywordWeight: 1})
+		require.Len(t, fused, 4)
+		assert.Equal(t, "a", fused[0].id)
+		assert.InDelta(t, 0.75, fused[0].fused, 1e-12)
+		assert.Equal(t, "b", fused[1].id)
+		assert.InDelta(t, 0.75*0.5, fused[1].fused, 1e-12)
+		assert.Equal(t, "c", fused[2].id)
+		assert.InDelta(t, 0.25, fused[2].fused, 1e-12)
+		assert.Equal(t, "d", fused[3].id)
+		assert.InDelta(t, 0.0, fused[3].fused, 1e-12)
+	})
+}
+
+func TestNormalizeScores(t *testing.T) {
+	assert.Empty(t, normalizeScores(nil))
+	assert.Equal(t, []float64{1, 1}, normalizeScores([]Similarity{{Score: 2}, {Score: 2}}))
+	assert.Equal(t, []float64{1, 0.5, 0}, normalizeScores([]Similarity{{Score: 4}, {Score: 3}, {Score: 2}}))
+}
+
+// newHybridTestCollection creates a collection where the query is closest to documents 1 and 3
+// by embedding, while only document 2 contains its keyword.
+func newHybridTestCollection(t *testing.T) *Collection {
+	embeddings := map[string][]float64{
+		"alpha red":     {1.0, 0.0},
+		"bravo SKU-123": {0.0, 1.0},
+		"charlie":       {0.9, 0.1},
+		"SKU-123":       {1.0, 0.0},
+	}
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		result := make([][]float64, len(inputs))
+		for i, input := range inputs {
+			result[i] = embeddings[input]
+		}
+		return result, nil
+	}
+
+	collection, err := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc)
+	require.NoError(t, err)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "alpha red", Metadata: map[string]interface{}{"lang": "en"}}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "bravo SKU-123", Metadata: map[string]interface{}{"lang": "de"}}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "3", Content: "charlie", Metadata: map[string]interface{}{"lang": "en"}}))
+	return collection
+}
+
+func TestCollection_HybridSearch(t *testing.T) {
+	collection := newHybridTestCollection(t)
+
+	// A keyword index is required.
+	_, err := collection.HybridSearch("SKU-123", 3, HybridOptions{})
+	assert.Error(t, err)
+
+	index, _ := NewKeywordIndex(KeywordIndexConfig{})
+	collection.SetKeywordIndex(index)
+
+	// With reciprocal rank fusion, the only keyword match ranks first even though its
+	// embedding is the least similar.
+	results, err := collection.HybridSearch("SKU-123", 3, HybridOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "2", results[0].Document.ID)
+	assert.Greater(t, results[0].KeywordScore, 0.0)
+	assert.InDelta(t, 0.0, results[0].VectorScore, 1e-12)
+	assert.InDelta(t, 1.0/61+1.0/63, results[0].Similarity, 1e-12)
+	assert.Equal(t, "1", results[1].Document.ID)
+	assert.InDelta(t, 1.0, results[1].VectorScore, 1e-12)
+	assert.Equal(t, 0.0, results[1].KeywordScore)
+	assert.Equal(t, "3", results[2].Document.ID)
+
+	// Weighted fusion follows the weights.
+	results, err = collection.HybridSearch("SKU-123", 1, HybridOptions{Fusion: WeightedFusion, VectorWeight: 1, KeywordWeight: 2})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+	assert.InDelta(t, 2.0/3, results[0].Similarity, 1e-12)
+
+	results, err = collection.HybridSearch("SKU-123", 1, HybridOptions{Fusion: WeightedFusion, VectorWeight: 2, KeywordWeight: 1})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "1", results[0].Document.ID)
+
+	// The filter applies to both signals.
+	results, err = collection.HybridSearch("SKU-123", 3, HybridOptions{Filter: Filter{"lang": "en"}})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, "en", result.Document.Metadata["lang"])
+		assert.Equal(t, 0.0, result.KeywordScore)
+	}
+}
+
+func TestCollection_HybridSearch_InvalidOptions(t *testing.T) {
+	collection := newHybridTestCollection(t)
+	index, _ := NewKeywordIndex(KeywordIndexConfig{})
+	collection.SetKeywordIndex(index)
+
+	tests := []struct {
+		name string
+		opts HybridOptions
+	}{
+		{"Negative Weight", HybridOptions{VectorWeight: -1}},
+		{"Negative RRFK", HybridOptions{RRFK: -1}},
+		{"Negative Candidate Factor", HybridOptions{CandidateFactor: -1}},
+		{"Unknown Fusion", HybridOptions{Fusion: FusionMethod(5)}},
+		{"Invalid Filter", HybridOptions{Filter: Filter{"lang": Filter{"$bad": 1}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := collection.HybridSearch("SKU-123", 3, tt.opts)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package vector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Index is a nearest neighbor index over the normalized segment embeddings of a collection.
+// Once an index is set with Collection.SetIndex, the collection keeps it up to date as documents
+// are added, updated and deleted, and uses it to answer similarity queries.
+type Index interface {
+	// Add stores the normalized embedding under the given ID, replacing any previous entry.
+	Add(id string, embedding []float64) error
+	// Remove deletes the entry with the given ID. Removing an unknown ID is a no-op.
+	Remove(id string)
+	// Search returns up to topN entries most similar to the normalized query embedding,
+	// sorted by similarity score in descending order.
+	Search(query []float64, topN int) ([]Similarity, error)
+	// Len returns the number of entries in the index.
+	Len() int
+}
+
+// segmentID returns the ID under which a segment is stored in an index.
+func segmentID(docID string, segmentIndex int) string {
+	return fmt.Sprintf("%s_%d", docID, segmentIndex)
+}
+
+// parseSegmentID parses the document ID and segment index from an ID returned by segmentID.
+func parseSegmentID(id string) (string, int, error) {
+	lastUnderscoreIndex := strings.LastIndex(id, "_")
+	if lastUnderscoreIndex == -1 {
+		return "", 0, fmt.Errorf("invalid ID format: %s", id)
+	}
+
+	docID := id[:lastUnderscoreIndex]
+	segmentIndex, err := strconv.Atoi(id[lastUnderscoreIndex+1:])
+	if err != nil {
+		return "", 0, err
+	}
+
+	return docID, segmentIndex, nil
+}
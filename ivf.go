@@ -0,0 +1,501 @@
+package vector
+
+import (
+	"encoding"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Trainer is implemented by indexes that learn their structure from the embeddings they store.
+// Collection.SetIndex trains such an index after adding the existing segments, and
+// Collection.RetrainIndex trains it again on demand.
+type Trainer interface {
+	// Train (re)builds the index structure from the embeddings currently stored.
+	Train() error
+}
+
+// IVFConfig configures an inverted file index.
+// Zero values are replaced by the defaults noted on each field.
+type IVFConfig struct {
+	// NList is the number of k-means centroids, and therefore posting lists.
+	// It is lowered to the number of stored embeddings when training on fewer. Defaults to 100.
+	NList int
+	// NProbe is the number of posting lists scanned per query. Defaults to 8.
+	NProbe int
+	// Iterations is the maximum number of k-means iterations while training. Defaults to 20.
+	Iterations int
+	// Seed seeds the centroid initialization, which makes training reproducible.
+	Seed int64
+	// Codec, if set, compresses the embeddings in the posting lists, for example a
+	// ProductQuantizer for IVF-PQ. Queries are then scored against the codes.
+	// An untrained codec is trained together with the index. Once trained, it is saved with
+	// the collection of the index, and an untrained codec of the same kind passed to SetIndex
+	// after loading the collection gets the saved codebooks instead of being trained again.
+	Codec Codec
+}
+
+const (
+	defaultIVFNList      = 100
+	defaultIVFNProbe     = 8
+	defaultIVFIterations = 20
+)
+
+// IVFIndex is an approximate nearest neighbor index that partitions embeddings into posting
+// lists around k-means centroids. A query only scans the NProbe lists whose centroids are
+// closest to it.
+//
+// Until the index is trained, every query scans all embeddings. New embeddings added after
+// training are assigned to their nearest centroid; call Train again (for example through
+// Collection.RetrainIndex) to rebalance the lists after heavy ingestion.
+//
+// With a codec, embeddings are stored as codes once the codec is trained, and the
+// full-precision embeddings are not kept.
+type IVFIndex struct {
+	mu         sync.RWMutex
+	nlist      int
+	nprobe     int
+	iterations int
+	rng        *rand.Rand
+	codec      Codec
+	dim        int
+	centroids  [][]float64
+	lists      [][]ivfEntry
+	locations  map[string]ivfLocation
+}
+
+// ivfEntry is a single embedding in a posting list.
+// It holds either the full-precision vector or, once the codec is trained, the code.
+type ivfEntry struct {
+	id     string
+	vector []float64
+	code   []byte
+}
+
+// ivfLocation is the position of an entry in the posting lists.
+type ivfLocation struct {
+	list   int
+	offset int
+}
+
+// NewIVFIndex creates an empty, untrained IVF index.
+func NewIVFIndex(config IVFConfig) (*IVFIndex, error) {
+	if config.NList < 0 || config.NProbe < 0 || config.Iterations < 0 {
+		return nil, errors.New("IVF parameters must be greater than or equal to zero")
+	}
+	if config.NList == 0 {
+		config.NList = defaultIVFNList
+	}
+	if config.NProbe == 0 {
+		config.NProbe = defaultIVFNProbe
+	}
+	if config.Iterations == 0 {
+		config.Iterations = defaultIVFIterations
+	}
+
+	return &IVFIndex{
+		nlist:      config.NList,
+		nprobe:     config.NProbe,
+		iterations: config.Iterations,
+		rng:        rand.New(rand.NewSource(config.Seed)),
+		codec:      config.Codec,
+		// Before training, all embeddings live in a single list.
+		lists:     make([][]ivfEntry, 1),
+		locations: make(map[string]ivfLocation),
+	}, nil
+}
+
+// SetNProbe changes the number of posting lists scanned by later queries.
+func (ivf *IVFIndex) SetNProbe(nprobe int) error {
+	if nprobe <= 0 {
+		return errors.New("nprobe must be greater than zero")
+	}
+
+	ivf.mu.Lock()
+	defer ivf.mu.Unlock()
+
+	ivf.nprobe = nprobe
+	return nil
+}
+
+// Trained reports whether the index has centroids.
+func (ivf *IVFIndex) Trained() bool {
+	ivf.mu.RLock()
+	defer ivf.mu.RUnlock()
+
+	return ivf.centroids != nil
+}
+
+// Len returns the number of embeddings in the index.
+func (ivf *IVFIndex) Len() int {
+	ivf.mu.RLock()
+	defer ivf.mu.RUnlock()
+
+	return len(ivf.locations)
+}
+
+// Add stores the normalized embedding under the given ID in the posting list of its nearest centroid.
+func (ivf *IVFIndex) Add(id string, embedding []float64) error {
+	ivf.mu.Lock()
+	defer ivf.mu.Unlock()
+
+	if len(embedding) == 0 {
+		return errors.New("embedding is empty")
+	}
+	if ivf.dim != 0 && len(embedding) != ivf.dim {
+		return errors.New("embedding length does not match the index")
+	}
+	ivf.dim = len(embedding)
+
+	entry := ivfEntry{id: id, vector: embedding}
+	if ivf.codec != nil && codecTrained(ivf.codec) {
+		code, err := ivf.codec.Encode(embedding)
+		if err != nil {
+			return err
+		}
+		entry = ivfEntry{id: id, code: code}
+	}
+
+	ivf.remove(id)
+
+	list := 0
+	if ivf.centroids != nil {
+		list = nearestCentroid(embedding, ivf.centroids)
+	}
+	ivf.locations[id] = ivfLocation{list: list, offset: len(ivf.lists[list])}
+	ivf.lists[list] = append(ivf.lists[list], entry)
+	return nil
+}
+
+// codecTrained reports whether codec is ready to encode embeddings.
+// Codecs that do not report their training state are assumed to be ready.
+func codecTrained(codec Codec) bool {
+	if trained, ok := codec.(interface{ Trained() bool }); ok {
+		return trained.Trained()
+	}
+	return true
+}
+
+// restoreCodec gives the codec of the index the state saved in snapshot, unless the codec is
+// already trained or of another kind.
+func (ivf *IVFIndex) restoreCodec(snapshot *codecSnapshot) error {
+	ivf.mu.Lock()
+	defer ivf.mu.Unlock()
+
+	if ivf.codec == nil || codecTrained(ivf.codec) {
+		return nil
+	}
+	if kind, ok := codecKind(ivf.codec); !ok || kind != snapshot.Kind {
+		return nil
+	}
+	unmarshaler, ok := ivf.codec.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return nil
+	}
+	return unmarshaler.UnmarshalBinary(snapshot.State)
+}
+
+// trainedCodec returns the codec of the index, or nil if it has none or it is not trained.
+func (ivf *IVFIndex) trainedCodec() Codec {
+	ivf.mu.RLock()
+	defer ivf.mu.RUnlock()
+
+	if ivf.codec == nil || !codecTrained(ivf.codec) {
+		return nil
+	}
+	return ivf.codec
+}
+
+// Remove deletes the embedding with the given ID.
+func (ivf *IVFIndex) Remove(id string) {
+	ivf.mu.Lock()
+	defer ivf.mu.Unlock()
+
+	ivf.remove(id)
+}
+
+// remove deletes the entry with the given ID by moving the last entry of its list into its place.
+// The caller must hold ivf.mu for writing.
+func (ivf *IVFIndex) remove(id string) {
+	loc, ok := ivf.locations[id]
+	if !ok {
+		return
+	}
+
+	list := ivf.lists[loc.list]
+	last := len(list) - 1
+	if loc.offset != last {
+		list[loc.offset] = list[last]
+		ivf.locations[list[loc.offset].id] = loc
+	}
+	ivf.lists[loc.list] = list[:last]
+	delete(ivf.locations, id)
+
+	if len(ivf.locations) == 0 {
+		ivf.dim = 0
+	}
+}
+
+// Train runs k-means over the stored embeddings and redistributes them over the posting lists.
+func (ivf *IVFIndex) Train() error {
+	ivf.mu.Lock()
+	defer ivf.mu.Unlock()
+
+	entries := make([]ivfEntry, 0, len(ivf.locations))
+	for _, list := range ivf.lists {
+		entries = append(entries, list...)
+	}
+	if len(entries) == 0 {
+		return errors.New("no embeddings to train on")
+	}
+
+	vectors := make([][]float64, len(entries))
+	for i, entry := range entries {
+		vectors[i] = entry.vector
+		if entry.code != nil {
+			// Train on the reconstructed embedding if only the code is stored.
+			vector, err := ivf.codec.Decode(entry.code)
+			if err != nil {
+				return err
+			}
+			vectors[i] = vector
+		}
+	}
+
+	if ivf.codec != nil && !codecTrained(ivf.codec) {
+		if err := ivf.codec.Train(vectors); err != nil {
+			return err
+		}
+	}
+
+	// Encode every entry before changing anything, so that a failure leaves the index untouched.
+	if ivf.codec != nil {
+		for i, entry := range entries {
+			if entry.code != nil {
+				continue
+			}
+			code, err := ivf.codec.Encode(entry.vector)
+			if err != nil {
+				return err
+			}
+			entries[i] = ivfEntry{id: entry.id, code: code}
+		}
+	}
+
+	ivf.centroids = kmeans(vectors, min(ivf.nlist, len(vectors)), ivf.iterations, ivf.rng)
+	ivf.lists = make([][]ivfEntry, len(ivf.centroids))
+	for i, entry := range entries {
+		list := nearestCentroid(vectors[i], ivf.centroids)
+		ivf.locations[entry.id] = ivfLocation{list: list, offset: len(ivf.lists[list])}
+		ivf.lists[list] = append(ivf.lists[list], entry)
+	}
+	return nil
+}
+
+// Search scans the posting lists of the NProbe centroids closest to the query.
+func (ivf *IVFIndex) Search(query []float64, topN int) ([]Similarity, error) {
+	ivf.mu.RLock()
+	defer ivf.mu.RUnlock()
+
+	if topN <= 0 || len(ivf.locations) == 0 {
+		return nil, nil
+	}
+	if len(query) != ivf.dim {
+		return nil, errors.New("query embedding length does not match embeddings")
+	}
+
+	probes := []int{0}
+	if ivf.centroids != nil {
+		probes = nearestCentroids(query, ivf.centroids, ivf.nprobe)
+	}
+
+	var scorer func(code []byte) float64
+	if ivf.codec != nil && codecTrained(ivf.codec) {
+		var err error
+		if scorer, err = ivf.codec.Scorer(query); err != nil {
+			return nil, err
+		}
+	}
+
+	var similarities []Similarity
+	for _, list := range probes {
+		for _, entry := range ivf.lists[list] {
+			if entry.code != nil {
+				similarities = append(similarities, Similarity{ID: entry.id, Score: scorer(entry.code)})
+				continue
+			}
+			var dotP float64
+			for i := range query {
+				dotP += query[i] * entry.vector[i]
+			}
+			similarities = append(similarities, Similarity{ID: entry.id, Score: dotP})
+		}
+	}
+
+	sort.Slice(similarities, func(i, j int) bool {
+		return similarities[i].Score > similarities[j].Score
+	})
+	if topN > len(similarities) {
+		topN = len(similarities)
+	}
+	return similarities[:topN], nil
+}
+
+// RetrainIndex trains the collection's index again if it implements Trainer,
+// for example to rebalance the posting lists of an IVF index after heavy ingestion.
+// If segments could not be added to the index, they are added again first, so that
+// queries search the index again.
+func (c *Collection) RetrainIndex() error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.index == nil {
+		return errors.New("collection has no index")
+	}
+	if c.indexStale {
+		if err := c.addSegmentsToIndex(c.index); err != nil {
+			return err
+		}
+		c.indexStale = false
+		if _, ok := c.index.(Trainer); !ok {
+			return nil
+		}
+	}
+	trainer, ok := c.index.(Trainer)
+	if !ok {
+		return errors.New("index does not support training")
+	}
+	return trainer.Train()
+}
+
+// squaredDistance returns the squared Euclidean distance between two vectors of the same length.
+func squaredDistance(vec1, vec2 []float64) float64 {
+	var sum float64
+	for i := range vec1 {
+		d := vec1[i] - vec2[i]
+		sum += d * d
+	}
+	return sum
+}
+
+// nearestCentroid returns the index of the centroid closest to vector.
+func nearestCentroid(vector []float64, centroids [][]float64) int {
+	best := 0
+	bestDistance := math.Inf(1)
+	for i, centroid := range centroids {
+		if d := squaredDistance(vector, centroid); d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return best
+}
+
+// nearestCentroids returns the indexes of the n centroids closest to vector, closest first.
+func nearestCentroids(vector []float64, centroids [][]float64, n int) []int {
+	order := make([]int, len(centroids))
+	distances := make([]float64, len(centroids))
+	for i, centroid := range centroids {
+		order[i] = i
+		distances[i] = squaredDistance(vector, centroid)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return distances[order[i]] < distances[order[j]]
+	})
+	if n > len(order) {
+		n = len(order)
+	}
+	return order[:n]
+}
+
+// kmeans clusters vectors into k groups and returns the centroids.
+// Centroids are seeded with k-means++ and refined with Lloyd's algorithm for at most
+// the given number of iterations. Clusters that end up empty are reseeded with the
+// vector farthest from its centroid.
+func kmeans(vectors [][]float64, k, iterations int, rng *rand.Rand) [][]float64 {
+	dim := len(vectors[0])
+	centroids := make([][]float64, 0, k)
+
+	// k-means++ seeding: pick each new centroid with probability proportional to its
+	// squared distance from the nearest centroid chosen so far.
+	first := vectors[rng.Intn(len(vectors))]
+	centroids = append(centroids, append([]float64(nil), first...))
+	distances := make([]float64, len(vectors))
+	for i, v := range vectors {
+		distances[i] = squaredDistance(v, centroids[0])
+	}
+	for len(centroids) < k {
+		var total float64
+		for _, d := range distances {
+			total += d
+		}
+		next := rng.Intn(len(vectors))
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range distances {
+				target -= d
+				if target <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+		centroid := append([]float64(nil), vectors[next]...)
+		centroids = append(centroids, centroid)
+		for i, v := range vectors {
+			distances[i] = math.Min(distances[i], squaredDistance(v, centroid))
+		}
+	}
+
+	assignments := make([]int, len(vectors))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		changed := false
+		for i, v := range vectors {
+			nearest := nearestCentroid(v, centroids)
+			if nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		counts := make([]int, k)
+		sums := make([][]float64, k)
+		for j := range sums {
+			sums[j] = make([]float64, dim)
+		}
+		for i, v := range vectors {
+			counts[assignments[i]]++
+			for d, x := range v {
+				sums[assignments[i]][d] += x
+			}
+		}
+
+		for j := range centroids {
+			if counts[j] == 0 {
+				// Reseed an empty cluster with the vector that is farthest from its centroid.
+				farthest, farthestDistance := 0, -1.0
+				for i, v := range vectors {
+					if d := squaredDistance(v, centroids[assignments[i]]); d > farthestDistance {
+						farthest, farthestDistance = i, d
+					}
+				}
+				centroids[j] = append([]float64(nil), vectors[farthest]...)
+				assignments[farthest] = j
+				continue
+			}
+			for d := range sums[j] {
+				centroids[j][d] = sums[j][d] / float64(counts[j])
+			}
+		}
+	}
+
+	return centroids
+}
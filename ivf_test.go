@@ -0,0 +1,177 @@
+package vector
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIVFIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  IVFConfig
+		wantErr bool
+	}{
+		{"Defaults", IVFConfig{}, false},
+		{"Custom", IVFConfig{NList: 10, NProbe: 2, Iterations: 5}, false},
+		{"Negative NList", IVFConfig{NList: -1}, true},
+		{"Negative NProbe", IVFConfig{NProbe: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewIVFIndex(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKmeans(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Two well separated clusters.
+	var vectors [][]float64
+	for i := 0; i < 50; i++ {
+		vectors = append(vectors, []float64{10 + rng.Float64(), 10 + rng.Float64()})
+		vectors = append(vectors, []float64{-10 - rng.Float64(), -10 - rng.Float64()})
+	}
+
+	centroids := kmeans(vectors, 2, 20, rng)
+	require.Len(t, centroids, 2)
+	a := nearestCentroid([]float64{10.5, 10.5}, centroids)
+	b := nearestCentroid([]float64{-10.5, -10.5}, centroids)
+	assert.NotEqual(t, a, b)
+	assert.InDelta(t, 10.5, centroids[a][0], 0.2)
+	assert.InDelta(t, -10.5, centroids[b][0], 0.2)
+}
+
+func TestIVFIndex_Search(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	vectors := randomNormalizedVectors(rng, 1000, 8)
+	ids := make([]string, len(vectors))
+
+	index, err := NewIVFIndex(IVFConfig{NList: 16, NProbe: 16, Seed: 3})
+	require.NoError(t, err)
+	for i, v := range vectors {
+		ids[i] = fmt.Sprintf("v%d", i)
+		require.NoError(t, index.Add(ids[i], v))
+	}
+
+	// An untrained index scans every embedding.
+	assert.False(t, index.Trained())
+	q := randomNormalizedVectors(rng, 1, 8)[0]
+	expected, err := getTopNSimilarEmbeddings(q, vectors, ids, 10)
+	require.NoError(t, err)
+	got, err := index.Search(q, 10)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+
+	require.NoError(t, index.Train())
+	assert.True(t, index.Trained())
+	assert.Equal(t, len(vectors), index.Len())
+
+	// Probing every list is exact.
+	got, err = index.Search(q, 10)
+	require.NoError(t, err)
+	assert.Equal(t, expected, got)
+
+	// Probing fewer lists still finds most neighbors.
+	require.NoError(t, index.SetNProbe(4))
+	assert.Error(t, index.SetNProbe(0))
+	var total float64
+	queries := randomNormalizedVectors(rng, 50, 8)
+	for _, q := range queries {
+		expected, _ := getTopNSimilarEmbeddings(q, vectors, ids, 10)
+		got, err := index.Search(q, 10)
+		require.NoError(t, err)
+		total += recallAt(expected, got)
+	}
+	assert.GreaterOrEqual(t, total/float64(len(queries)), 0.6)
+
+	_, err = index.Search([]float64{1, 0}, 10)
+	assert.Error(t, err)
+}
+
+func TestIVFIndex_AddRemoveAfterTraining(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	vectors := randomNormalizedVectors(rng, 100, 4)
+
+	index, _ := NewIVFIndex(IVFConfig{NList: 4, NProbe: 4, Seed: 4})
+	assert.Error(t, index.Train())
+
+	for i, v := range vectors[:50] {
+		require.NoError(t, index.Add(fmt.Sprintf("v%d", i), v))
+	}
+	require.NoError(t, index.Train())
+
+	// New embeddings are assigned to their nearest centroid.
+	for i, v := range vectors[50:] {
+		require.NoError(t, index.Add(fmt.Sprintf("v%d", i+50), v))
+	}
+	assert.Equal(t, 100, index.Len())
+
+	got, err := index.Search(vectors[75], 1)
+	require.NoError(t, err)
+	assert.Equal(t, "v75", got[0].ID)
+
+	for i := 0; i < 100; i += 2 {
+		index.Remove(fmt.Sprintf("v%d", i))
+	}
+	index.Remove("unknown")
+	assert.Equal(t, 50, index.Len())
+
+	got, err = index.Search(vectors[0], 50)
+	require.NoError(t, err)
+	assert.Len(t, got, 50)
+	for _, sim := range got {
+		var n int
+		fmt.Sscanf(sim.ID, "v%d", &n)
+		assert.Equal(t, 1, n%2)
+	}
+
+	// Retraining keeps every embedding.
+	require.NoError(t, index.Train())
+	assert.Equal(t, 50, index.Len())
+	got, err = index.Search(vectors[1], 50)
+	require.NoError(t, err)
+	assert.Len(t, got, 50)
+}
+
+func TestCollection_RetrainIndex(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", []string{"first"}, mock.Anything).Return([][]float64{{1.0, 0.0}}, nil)
+	embeddingFunc.On("Embed", []string{"second"}, mock.Anything).Return([][]float64{{0.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"third"}, mock.Anything).Return([][]float64{{1.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"query"}, mock.Anything).Return([][]float64{{1.0, 0.1}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	assert.Error(t, collection.RetrainIndex())
+
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second"}))
+
+	index, _ := NewIVFIndex(IVFConfig{NList: 2, NProbe: 2})
+	require.NoError(t, collection.SetIndex(index))
+	assert.True(t, index.Trained())
+
+	require.NoError(t, collection.AddDocument(&Document{ID: "3", Content: "third"}))
+	require.NoError(t, collection.RetrainIndex())
+	assert.Equal(t, 3, index.Len())
+
+	results, err := collection.GetTopNSimilarDocuments("query", 3)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "1", results[0].Document.ID)
+
+	hnsw, _ := NewHNSWIndex(HNSWConfig{})
+	require.NoError(t, collection.SetIndex(hnsw))
+	assert.Error(t, collection.RetrainIndex())
+}
@@ -0,0 +1,332 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// KeywordTokenizer splits text into the terms indexed and searched by a KeywordIndex.
+type KeywordTokenizer interface {
+	Tokenize(text string) []string
+}
+
+// WordTokenizer is the default KeywordTokenizer. It lowercases text and splits it into
+// runs of letters and digits. Runs joined by '-', '_', '.' or '/', such as error codes,
+// SKUs and version numbers, are kept as a single term followed by their parts, so that
+// "ERR-1042" is matched by both "err-1042" and "1042".
+type WordTokenizer struct{}
+
+// isTermConnector reports whether r joins runs of letters and digits into a single term.
+func isTermConnector(r rune) bool {
+	return r == '-' || r == '_' || r == '.' || r == '/'
+}
+
+// Tokenize splits text into lowercase terms.
+func (WordTokenizer) Tokenize(text string) []string {
+	var terms []string
+	flush := func(word string) {
+		word = strings.TrimFunc(word, isTermConnector)
+		if word == "" {
+			return
+		}
+		terms = append(terms, word)
+		if strings.IndexFunc(word, isTermConnector) >= 0 {
+			terms = append(terms, strings.FieldsFunc(word, isTermConnector)...)
+		}
+	}
+
+	var word strings.Builder
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || isTermConnector(r) {
+			word.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		flush(word.String())
+		word.Reset()
+	}
+	flush(word.String())
+	return terms
+}
+
+// EnglishStopWords is a list of common English words that can be passed as
+// KeywordIndexConfig.StopWords.
+var EnglishStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into",
+	"is", "it", "no", "not", "of", "on", "or", "such", "that", "the", "their", "then",
+	"there", "these", "they", "this", "to", "was", "will", "with",
+}
+
+// KeywordIndexConfig configures a BM25 keyword index.
+// Zero values are replaced by the defaults noted on each field.
+type KeywordIndexConfig struct {
+	// Tokenizer splits segment text and queries into terms. Defaults to WordTokenizer.
+	Tokenizer KeywordTokenizer
+	// StopWords are terms left out of the index and of queries, compared with the terms
+	// produced by the tokenizer. Defaults to none; see EnglishStopWords.
+	StopWords []string
+	// K1 controls how quickly repeated terms saturate. Defaults to 1.2; see BinaryTermFrequency for 0.
+	K1 float64
+	// B controls how much scores are normalized by segment length, from 0 to 1. Defaults to 0.75;
+	// see NoLengthNormalization for 0.
+	B float64
+	// BinaryTermFrequency sets K1 to 0, so that a term counts once however often it occurs.
+	BinaryTermFrequency bool
+	// NoLengthNormalization sets B to 0, so that scores do not depend on segment length.
+	NoLengthNormalization bool
+}
+
+const (
+	defaultBM25K1 = 1.2
+	defaultBM25B  = 0.75
+)
+
+// KeywordIndex is an inverted index over segment text that ranks segments with BM25
+// (Robertson et al., 1994). Unlike embedding similarity, it matches exact terms such as
+// identifiers, error codes and product SKUs.
+type KeywordIndex struct {
+	mu          sync.RWMutex
+	tokenizer   KeywordTokenizer
+	stopWords   map[string]struct{}
+	k1          float64
+	b           float64
+	postings    map[string]map[string]int // term frequencies by term and ID.
+	terms       map[string][]string       // distinct terms by ID.
+	lengths     map[string]int            // number of terms by ID.
+	totalLength int
+}
+
+// NewKeywordIndex creates an empty keyword index.
+func NewKeywordIndex(config KeywordIndexConfig) (*KeywordIndex, error) {
+	if config.K1 < 0 {
+		return nil, errors.New("BM25 K1 must be greater than or equal to zero")
+	}
+	if config.B < 0 || config.B > 1 {
+		return nil, errors.New("BM25 B must be between 0 and 1")
+	}
+	if config.BinaryTermFrequency && config.K1 != 0 {
+		return nil, errors.New("BM25 K1 cannot be set with binary term frequency")
+	}
+	if config.NoLengthNormalization && config.B != 0 {
+		return nil, errors.New("BM25 B cannot be set without length normalization")
+	}
+	if config.Tokenizer == nil {
+		config.Tokenizer = WordTokenizer{}
+	}
+	if config.K1 == 0 && !config.BinaryTermFrequency {
+		config.K1 = defaultBM25K1
+	}
+	if config.B == 0 && !config.NoLengthNormalization {
+		config.B = defaultBM25B
+	}
+
+	stopWords := make(map[string]struct{}, len(config.StopWords))
+	for _, word := range config.StopWords {
+		stopWords[word] = struct{}{}
+	}
+
+	return &KeywordIndex{
+		tokenizer: config.Tokenizer,
+		stopWords: stopWords,
+		k1:        config.K1,
+		b:         config.B,
+		postings:  make(map[string]map[string]int),
+		terms:     make(map[string][]string),
+		lengths:   make(map[string]int),
+	}, nil
+}
+
+// tokenize splits text into terms and drops stop words.
+func (k *KeywordIndex) tokenize(text string) []string {
+	terms := k.tokenizer.Tokenize(text)
+	kept := terms[:0]
+	for _, term := range terms {
+		if _, ok := k.stopWords[term]; !ok && term != "" {
+			kept = append(kept, term)
+		}
+	}
+	return kept
+}
+
+// Len returns the number of texts in the index.
+func (k *KeywordIndex) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return len(k.lengths)
+}
+
+// Add indexes text under the given ID, replacing any text already stored under it.
+func (k *KeywordIndex) Add(id, text string) {
+	terms := k.tokenize(text)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.remove(id)
+
+	frequencies := make(map[string]int)
+	for _, term := range terms {
+		frequencies[term]++
+	}
+	distinct := make([]string, 0, len(frequencies))
+	for term, frequency := range frequencies {
+		if k.postings[term] == nil {
+			k.postings[term] = make(map[string]int)
+		}
+		k.postings[term][id] = frequency
+		distinct = append(distinct, term)
+	}
+	k.terms[id] = distinct
+	k.lengths[id] = len(terms)
+	k.totalLength += len(terms)
+}
+
+// Remove removes the text stored under the given ID, if any.
+func (k *KeywordIndex) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.remove(id)
+}
+
+// remove removes the text stored under the given ID.
+// The caller must hold k.mu for writing.
+func (k *KeywordIndex) remove(id string) {
+	length, ok := k.lengths[id]
+	if !ok {
+		return
+	}
+	for _, term := range k.terms[id] {
+		delete(k.postings[term], id)
+		if len(k.postings[term]) == 0 {
+			delete(k.postings, term)
+		}
+	}
+	delete(k.terms, id)
+	delete(k.lengths, id)
+	k.totalLength -= length
+}
+
+// Search returns the IDs of the top N texts ranked by their BM25 score for the query,
+// in descending order of score. Texts that contain none of the query terms are not returned.
+func (k *KeywordIndex) Search(query string, topN int) []Similarity {
+	return k.search(query, topN, nil)
+}
+
+// search is like Search, but only returns the IDs accepted by accept.
+// A nil accept function accepts every ID.
+func (k *KeywordIndex) search(query string, topN int, accept func(id string) bool) []Similarity {
+	terms := k.tokenize(query)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if topN <= 0 || len(k.lengths) == 0 {
+		return nil
+	}
+
+	n := float64(len(k.lengths))
+	averageLength := float64(k.totalLength) / n
+	scores := make(map[string]float64)
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := k.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, frequency := range postings {
+			if accept != nil && !accept(id) {
+				continue
+			}
+			tf := float64(frequency)
+			norm := 1 - k.b
+			if averageLength > 0 {
+				norm += k.b * float64(k.lengths[id]) / averageLength
+			}
+			scores[id] += idf * tf * (k.k1 + 1) / (tf + k.k1*norm)
+		}
+	}
+
+	similarities := make([]Similarity, 0, len(scores))
+	for id, score := range scores {
+		similarities = append(similarities, Similarity{ID: id, Score: score})
+	}
+	// Break ties by ID so that results are deterministic.
+	sort.Slice(similarities, func(i, j int) bool {
+		if similarities[i].Score != similarities[j].Score {
+			return similarities[i].Score > similarities[j].Score
+		}
+		return similarities[i].ID < similarities[j].ID
+	})
+	if topN < len(similarities) {
+		similarities = similarities[:topN]
+	}
+	return similarities
+}
+
+// SetKeywordIndex builds the given keyword index over the text of every segment in the
+// collection and uses it for KeywordSearch from then on. The index is kept up to date as
+// documents are added, updated and deleted. Passing nil removes the keyword index.
+func (c *Collection) SetKeywordIndex(index *KeywordIndex) {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if index != nil {
+		for docID, doc := range c.documents {
+			for i, segment := range doc.Segments {
+				index.Add(segmentID(docID, i), segment.Text)
+			}
+		}
+	}
+	c.keywordIndex = index
+}
+
+// KeywordSearch retrieves the top N segments that best match the terms of the query,
+// ranked by BM25. The Similarity of each result is its BM25 score, which is not bounded.
+// It requires a keyword index set with SetKeywordIndex.
+func (c *Collection) KeywordSearch(query string, topN int) ([]Result, error) {
+	return c.KeywordSearchContext(context.Background(), query, topN)
+}
+
+// KeywordSearchContext is like KeywordSearch, but gives up when ctx is done.
+func (c *Collection) KeywordSearchContext(ctx context.Context, query string, topN int) ([]Result, error) {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	similarities, err := c.keywordSearch(ctx, query, topN, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.resultsFromSimilarities(similarities), nil
+}
+
+// keywordSearch returns the top N segments of the selected documents ranked by BM25.
+// The caller must hold c.documentsLock.
+func (c *Collection) keywordSearch(ctx context.Context, query string, topN int, sel *selection) ([]Similarity, error) {
+	if c.keywordIndex == nil {
+		return nil, errors.New("keyword search requires a keyword index, set one with SetKeywordIndex")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var accept func(id string) bool
+	if sel != nil {
+		accept = func(id string) bool {
+			return c.selectsSegmentID(sel, id)
+		}
+	}
+	return c.keywordIndex.search(query, topN, accept), nil
+}
@@ -0,0 +1,183 @@
+package vector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWordTokenizer(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{"Empty", "", nil},
+		{"Words", "Hello, World!", []string{"hello", "world"}},
+		{"Digits", "Call 555 now", []string{"call", "555", "now"}},
+		{"Identifier", "Error ERR-1042 occurred.", []string{"error", "err-1042", "err", "1042", "occurred"}},
+		{"Version", "v1.2.3", []string{"v1.2.3", "v1", "2", "3"}},
+		{"Trailing Connectors", "--flag_ ", []string{"flag"}},
+		{"Unicode", "Größe straße", []string{"größe", "straße"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, WordTokenizer{}.Tokenize(tt.text))
+		})
+	}
+}
+
+func TestNewKeywordIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  KeywordIndexConfig
+		wantErr bool
+	}{
+		{"Defaults", KeywordIndexConfig{}, false},
+		{"Custom", KeywordIndexConfig{K1: 2, B: 0.5, StopWords: EnglishStopWords}, false},
+		{"Negative K1", KeywordIndexConfig{K1: -1}, true},
+		{"B Too Large", KeywordIndexConfig{B: 1.5}, true},
+		{"Zero K1 And B", KeywordIndexConfig{BinaryTermFrequency: true, NoLengthNormalization: true}, false},
+		{"K1 With Binary Term Frequency", KeywordIndexConfig{K1: 2, BinaryTermFrequency: true}, true},
+		{"B Without Length Normalization", KeywordIndexConfig{B: 0.5, NoLengthNormalization: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeywordIndex(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	index, err := NewKeywordIndex(KeywordIndexConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultBM25K1, index.k1)
+	assert.Equal(t, defaultBM25B, index.b)
+
+	index, err = NewKeywordIndex(KeywordIndexConfig{BinaryTermFrequency: true, NoLengthNormalization: true})
+	require.NoError(t, err)
+	assert.Zero(t, index.k1)
+	assert.Zero(t, index.b)
+}
+
+func TestKeywordIndex(t *testing.T) {
+	index, err := NewKeywordIndex(KeywordIndexConfig{StopWords: EnglishStopWords})
+	require.NoError(t, err)
+
+	index.Add("1", "the quick brown fox")
+	index.Add("2", "the lazy dog sleeps all day")
+	index.Add("3", "fox fox fox")
+	index.Add("4", "error SKU-7781 in the brown warehouse")
+	assert.Equal(t, 4, index.Len())
+
+	// Repeated terms score higher, and rarer terms weigh more.
+	got := index.Search("fox", 10)
+	require.Len(t, got, 2)
+	assert.Equal(t, "3", got[0].ID)
+	assert.Equal(t, "1", got[1].ID)
+	assert.Greater(t, got[0].Score, got[1].Score)
+
+	got = index.Search("brown fox", 1)
+	require.Len(t, got, 1)
+	assert.Equal(t, "1", got[0].ID)
+
+	// Identifiers match as a whole and by their parts.
+	got = index.Search("sku-7781", 10)
+	require.Len(t, got, 1)
+	assert.Equal(t, "4", got[0].ID)
+	got = index.Search("7781", 10)
+	require.Len(t, got, 1)
+	assert.Equal(t, "4", got[0].ID)
+
+	// Stop words are not indexed.
+	assert.Empty(t, index.Search("the", 10))
+	assert.Empty(t, index.Search("cat", 10))
+	assert.Empty(t, index.Search("fox", 0))
+
+	// Adding under an existing ID replaces the text.
+	index.Add("3", "a cat")
+	got = index.Search("fox", 10)
+	require.Len(t, got, 1)
+	assert.Equal(t, "1", got[0].ID)
+
+	index.Remove("1")
+	index.Remove("missing")
+	assert.Empty(t, index.Search("fox", 10))
+	assert.Equal(t, 3, index.Len())
+	assert.NotContains(t, index.postings, "fox")
+}
+
+// caseSensitiveTokenizer splits on whitespace without lowercasing.
+type caseSensitiveTokenizer struct{}
+
+func (caseSensitiveTokenizer) Tokenize(text string) []string {
+	return strings.Fields(text)
+}
+
+func TestKeywordIndex_Tokenizer(t *testing.T) {
+	index, _ := NewKeywordIndex(KeywordIndexConfig{Tokenizer: caseSensitiveTokenizer{}})
+	index.Add("1", "Go")
+	index.Add("2", "go")
+
+	got := index.Search("Go", 10)
+	require.Len(t, got, 1)
+	assert.Equal(t, "1", got[0].ID)
+}
+
+func TestCollection_KeywordSearch(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 0.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+
+	_, err := collection.KeywordSearch("error", 5)
+	assert.Error(t, err)
+
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "Disk full: error E1001"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Network timeout: error E2002"}))
+
+	index, _ := NewKeywordIndex(KeywordIndexConfig{})
+	collection.SetKeywordIndex(index)
+
+	results, err := collection.KeywordSearch("E2002", 5)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+	assert.Equal(t, "Network timeout: error E2002", results[0].Segment.Text)
+	assert.Greater(t, results[0].Similarity, 0.0)
+
+	results, err = collection.KeywordSearch("error", 5)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	// The index follows additions, updates and deletions.
+	require.NoError(t, collection.AddDocument(&Document{ID: "3", Content: "Printer jammed: error E3003"}))
+	require.NoError(t, collection.UpdateDocument(&Document{
+		ID:       "2",
+		Content:  "Network restored",
+		Segments: []*Segment{{Text: "Network restored", Embedding: []float64{1.0, 0.0}}},
+	}))
+	require.NoError(t, collection.DeleteDocument("1"))
+
+	results, err = collection.KeywordSearch("E3003", 5)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "3", results[0].Document.ID)
+
+	results, err = collection.KeywordSearch("E2002 E1001", 5)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	assert.Equal(t, 2, index.Len())
+
+	collection.SetKeywordIndex(nil)
+	_, err = collection.KeywordSearch("error", 5)
+	assert.Error(t, err)
+}
@@ -0,0 +1,405 @@
+package vector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Metadata keys of the segments split by a MarkdownSplitter.
+const (
+	// HeadingsKey holds the headings of the section of a segment, from the top level down,
+	// as a []string.
+	HeadingsKey = "headings"
+	// HeadingPathKey holds the headings of the section of a segment joined with " > ",
+	// such as "Install > Linux > Troubleshooting".
+	HeadingPathKey = "heading_path"
+)
+
+// MarkdownSplitter splits Markdown along its heading hierarchy. Every section is split on its
+// own, so that no segment spans two sections, and the headings of the section are recorded in
+// the metadata of its segments under HeadingsKey and HeadingPathKey, leaving out empty headings.
+// Within a section, segments end between blocks: paragraphs, lists, tables and code blocks,
+// fenced or indented. A heading stays with the block after it. Code blocks are never split,
+// even if they are longer than chunkSize. Tables that are too long are split between rows, and
+// every part repeats the header. Paragraphs that are too long are split like a
+// RecursiveSplitter does.
+type MarkdownSplitter struct {
+	// Tokenizer measures chunkSize and chunkOverlap in tokens. By default they are measured in runes.
+	Tokenizer Tokenizer
+}
+
+// String describes the tokenizer of the splitter.
+func (s MarkdownSplitter) String() string {
+	if s.Tokenizer == nil {
+		return "MarkdownSplitter"
+	}
+	return fmt.Sprintf("MarkdownSplitter(%s)", description(s.Tokenizer))
+}
+
+// Split splits text into chunks of at most chunkSize, sharing up to chunkOverlap within a section.
+func (s MarkdownSplitter) Split(text string, chunkSize, chunkOverlap int) ([]string, error) {
+	chunks, err := s.SplitChunks(text, chunkSize, chunkOverlap)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	return texts, nil
+}
+
+// SplitChunks is like Split, but also returns the headings of the section of each chunk.
+func (s MarkdownSplitter) SplitChunks(text string, chunkSize, chunkOverlap int) ([]Chunk, error) {
+	if err := checkChunkSizes(chunkSize, chunkOverlap); err != nil {
+		return nil, err
+	}
+	length := runeLength
+	if s.Tokenizer != nil {
+		length = func(text string) int {
+			return len(s.Tokenizer.Encode(text))
+		}
+	}
+
+	var chunks []Chunk
+	for _, section := range parseMarkdown(text) {
+		for _, chunk := range splitMarkdownBlocks(section.blocks, chunkSize, chunkOverlap, length) {
+			var metadata map[string]interface{}
+			if headings := section.nonEmptyHeadings(); len(headings) > 0 {
+				metadata = map[string]interface{}{
+					HeadingsKey:    headings,
+					HeadingPathKey: strings.Join(headings, " > "),
+				}
+			}
+			chunks = append(chunks, Chunk{Text: chunk, Metadata: metadata})
+		}
+	}
+	return chunks, nil
+}
+
+// markdownBlockKind is the kind of a block of Markdown.
+type markdownBlockKind int
+
+const (
+	markdownParagraph markdownBlockKind = iota
+	markdownCode
+	markdownTable
+	markdownHeading
+)
+
+// markdownBlock is a block of Markdown that is split only if it does not fit in a chunk.
+type markdownBlock struct {
+	kind  markdownBlockKind
+	lines []string // with their line breaks.
+}
+
+// markdownSection is the text under a heading, up to the next heading, and the heading itself.
+type markdownSection struct {
+	headings []string // from the top level down, empty before the first heading.
+	blocks   []markdownBlock
+}
+
+// nonEmptyHeadings returns the headings of the section, without those that have no text, such as "#".
+func (s markdownSection) nonEmptyHeadings() []string {
+	var headings []string
+	for _, heading := range s.headings {
+		if heading != "" {
+			headings = append(headings, heading)
+		}
+	}
+	return headings
+}
+
+var (
+	// atxHeading matches headings such as "## Install ##", capturing the level and the text.
+	atxHeading = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	// setextUnderline matches the line under a heading of level 1 (=) or 2 (-).
+	setextUnderline = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	// tableDelimiter matches the row between the header and the body of a table.
+	tableDelimiter = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+// parseMarkdown splits text into sections by heading and the sections into blocks.
+func parseMarkdown(text string) []markdownSection {
+	sections := []markdownSection{{}}
+	var levels []int // levels of the headings of the current section.
+	var block *markdownBlock
+	var fenceChar byte
+	var fenceLength int
+	var blankLines []string // blank lines in an indented code block, kept if the block goes on.
+
+	flush := func() {
+		blankLines = nil
+		if block != nil {
+			section := &sections[len(sections)-1]
+			section.blocks = append(section.blocks, *block)
+			block = nil
+		}
+	}
+	startSection := func(level int, heading string) {
+		flush()
+		current := sections[len(sections)-1]
+		headings := append([]string(nil), current.headings...)
+		for len(levels) > 0 && levels[len(levels)-1] >= level {
+			levels = levels[:len(levels)-1]
+			headings = headings[:len(headings)-1]
+		}
+		levels = append(levels, level)
+		headings = append(headings, heading)
+		// Drop the section before the first heading if it is empty.
+		if len(current.headings) == 0 && len(current.blocks) == 0 {
+			sections = sections[:len(sections)-1]
+		}
+		sections = append(sections, markdownSection{headings: headings})
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		content := strings.TrimRight(line, "\r\n")
+
+		if fenceChar != 0 {
+			block.lines = append(block.lines, line)
+			if char, length, rest := markdownFence(content); char == fenceChar && length >= fenceLength && strings.TrimSpace(rest) == "" {
+				fenceChar = 0
+				flush()
+			}
+			continue
+		}
+
+		if char, length, _ := markdownFence(content); char != 0 {
+			flush()
+			fenceChar, fenceLength = char, length
+			block = &markdownBlock{kind: markdownCode, lines: []string{line}}
+			continue
+		}
+
+		// An indented line starts a code block, unless it continues a paragraph or a table.
+		inIndentedCode := block != nil && block.kind == markdownCode
+		if markdownIndented(content) && (block == nil || inIndentedCode) {
+			if block == nil {
+				block = &markdownBlock{kind: markdownCode}
+			}
+			block.lines = append(block.lines, blankLines...)
+			block.lines = append(block.lines, line)
+			blankLines = nil
+			continue
+		}
+		if inIndentedCode && strings.TrimSpace(content) == "" {
+			blankLines = append(blankLines, line)
+			continue
+		}
+
+		if match := atxHeading.FindStringSubmatch(content); match != nil {
+			// The text of "# #" is a closing sequence, so the heading is empty.
+			heading := strings.TrimSpace(match[2])
+			if strings.Trim(heading, "#") == "" {
+				heading = ""
+			}
+			startSection(len(match[1]), heading)
+			// An empty heading has no text to keep.
+			if heading != "" {
+				block = &markdownBlock{kind: markdownHeading, lines: []string{line}}
+				flush()
+			}
+			continue
+		}
+
+		if match := setextUnderline.FindStringSubmatch(content); match != nil && block != nil && block.kind == markdownParagraph {
+			// The last line of the paragraph is the heading.
+			heading := block.lines[len(block.lines)-1]
+			block.lines = block.lines[:len(block.lines)-1]
+			if len(block.lines) == 0 {
+				block = nil
+			}
+			level := 2
+			if match[1][0] == '=' {
+				level = 1
+			}
+			startSection(level, strings.TrimSpace(heading))
+			block = &markdownBlock{kind: markdownHeading, lines: []string{heading, line}}
+			flush()
+			continue
+		}
+
+		if strings.TrimSpace(content) == "" {
+			flush()
+			continue
+		}
+
+		// A table goes on up to a blank line, and starts with a row that begins with "|" or with
+		// a header followed by its delimiter row.
+		kind := markdownParagraph
+		if block != nil && block.kind == markdownTable || strings.HasPrefix(strings.TrimSpace(content), "|") ||
+			i+1 < len(lines) && markdownTableHeader(content, strings.TrimRight(lines[i+1], "\r\n")) {
+			kind = markdownTable
+		}
+		if block != nil && block.kind != kind {
+			flush()
+		}
+		if block == nil {
+			block = &markdownBlock{kind: kind}
+		}
+		block.lines = append(block.lines, line)
+	}
+	flush()
+
+	if len(sections) == 1 && len(sections[0].blocks) == 0 {
+		return nil
+	}
+	return sections
+}
+
+// markdownFence returns the character and the length of the fence that starts line, and the
+// rest of the line, if line opens or closes a fenced code block.
+func markdownFence(line string) (byte, int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return 0, 0, ""
+	}
+	for _, char := range []byte{'`', '~'} {
+		length := 0
+		for length < len(trimmed) && trimmed[length] == char {
+			length++
+		}
+		if length >= 3 {
+			return char, length, trimmed[length:]
+		}
+	}
+	return 0, 0, ""
+}
+
+// markdownIndented reports whether line is indented by at least four columns, like the lines of
+// an indented code block. Tabs stop every four columns.
+func markdownIndented(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return false
+	}
+	column := 0
+	for i := 0; i < len(line) && column < 4; i++ {
+		switch line[i] {
+		case ' ':
+			column++
+		case '\t':
+			column += 4 - column%4
+		default:
+			return false
+		}
+	}
+	return column >= 4
+}
+
+// markdownTableHeader reports whether line is the header of a table whose delimiter row is next,
+// with as many cells.
+func markdownTableHeader(line, next string) bool {
+	return strings.Contains(line, "|") && strings.Contains(next, "|") && tableDelimiter.MatchString(next) &&
+		markdownTableCells(line) == markdownTableCells(next)
+}
+
+// markdownTableCells counts the cells of a table row.
+func markdownTableCells(row string) int {
+	row = strings.TrimSpace(row)
+	row = strings.TrimPrefix(row, "|")
+	row = strings.TrimSuffix(row, "|")
+	return strings.Count(row, "|") - strings.Count(row, `\|`) + 1
+}
+
+// splitMarkdownBlocks packs the blocks of a section into chunks, splitting the blocks that do
+// not fit in a chunk, except for code blocks. A heading is joined to the block after it, whose
+// first part is made smaller to leave room for it, unless the heading leaves no room at all.
+func splitMarkdownBlocks(blocks []markdownBlock, chunkSize, chunkOverlap int, length func(string) int) []string {
+	var pieces []string
+	var heading string // the heading before the current block, if any.
+	for _, block := range blocks {
+		text := strings.Join(block.lines, "")
+		// Blocks are separated by a blank line, which is trimmed from the ends of the chunks.
+		piece := strings.TrimRight(text, "\r\n") + "\n\n"
+		if block.kind == markdownHeading {
+			if heading != "" {
+				pieces = append(pieces, heading)
+			}
+			heading = piece
+			continue
+		}
+		if length(heading+piece) <= chunkSize || block.kind == markdownCode {
+			pieces = append(pieces, heading+piece)
+			heading = ""
+			continue
+		}
+
+		firstSize := chunkSize
+		if heading != "" {
+			if firstSize = chunkSize - length(heading); firstSize <= chunkOverlap {
+				pieces = append(pieces, heading)
+				heading, firstSize = "", chunkSize
+			}
+		}
+		for _, part := range splitMarkdownBlock(block, firstSize, chunkSize, chunkOverlap, length) {
+			pieces = append(pieces, heading+part+"\n\n")
+			heading = ""
+		}
+	}
+	if heading != "" {
+		pieces = append(pieces, heading)
+	}
+	return mergePieces(pieces, chunkSize, chunkOverlap, length, trimMarkdownChunk)
+}
+
+// splitMarkdownBlock splits a block that does not fit in a chunk into parts of at most chunkSize,
+// except for the first one, which has at most firstSize.
+func splitMarkdownBlock(block markdownBlock, firstSize, chunkSize, chunkOverlap int, length func(string) int) []string {
+	if block.kind == markdownTable {
+		return splitMarkdownTable(block.lines, firstSize, chunkSize, length)
+	}
+	text := strings.Join(block.lines, "")
+	if firstSize == chunkSize {
+		return splitRecursive(text, DefaultSeparators, chunkSize, chunkOverlap, length)
+	}
+	parts := splitRecursive(text, DefaultSeparators, firstSize, chunkOverlap, length)
+	if len(parts) == 0 {
+		return nil
+	}
+	// Split the text after the first part again, into parts of the full size.
+	rest := text[strings.Index(text, parts[0])+len(parts[0]):]
+	return append(parts[:1], splitRecursive(rest, DefaultSeparators, chunkSize, chunkOverlap, length)...)
+}
+
+// trimMarkdownChunk trims the blank lines around a chunk, keeping the indentation of its first
+// line, which starts an indented code block.
+func trimMarkdownChunk(chunk string) string {
+	chunk = strings.TrimRightFunc(chunk, unicode.IsSpace)
+	if end := strings.LastIndex(chunk[:len(chunk)-len(strings.TrimLeftFunc(chunk, unicode.IsSpace))], "\n"); end >= 0 {
+		chunk = chunk[end+1:]
+	}
+	return chunk
+}
+
+// splitMarkdownTable splits the rows of a table into parts of at most chunkSize, except for the
+// first one, which has at most firstSize. Each part starts with the header of the table. A row
+// that does not fit even alone makes a longer part, and so does a header without rows.
+func splitMarkdownTable(lines []string, firstSize, chunkSize int, length func(string) int) []string {
+	var header []string
+	if len(lines) > 1 && tableDelimiter.MatchString(strings.TrimRight(lines[1], "\r\n")) {
+		header, lines = lines[:2], lines[2:]
+	}
+
+	var parts []string
+	current := append([]string(nil), header...)
+	size := firstSize
+	for _, row := range lines {
+		if len(current) > len(header) && length(strings.Join(current, "")+row) > size {
+			parts = append(parts, strings.TrimRight(strings.Join(current, ""), "\r\n"))
+			current = append([]string(nil), header...)
+			size = chunkSize
+		}
+		current = append(current, row)
+	}
+	if len(current) > len(header) || len(parts) == 0 {
+		parts = append(parts, strings.TrimRight(strings.Join(current, ""), "\r\n"))
+	}
+	return parts
+}
@@ -0,0 +1,270 @@
+package vector
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMarkdown = `Preamble before any heading.
+
+# Install
+
+Download the archive.
+
+## Linux
+
+Run the installer.
+
+### Troubleshooting
+
+Check the permissions.
+
+## macOS
+
+Use Homebrew.
+`
+
+func TestMarkdownSplitter(t *testing.T) {
+	chunks, err := MarkdownSplitter{}.SplitChunks(testMarkdown, 100, 0)
+	require.NoError(t, err)
+
+	var texts, paths []string
+	for _, chunk := range chunks {
+		texts = append(texts, chunk.Text)
+		path, _ := chunk.Metadata[HeadingPathKey].(string)
+		paths = append(paths, path)
+	}
+	assert.Equal(t, []string{
+		"Preamble before any heading.",
+		"# Install\n\nDownload the archive.",
+		"## Linux\n\nRun the installer.",
+		"### Troubleshooting\n\nCheck the permissions.",
+		"## macOS\n\nUse Homebrew.",
+	}, texts)
+	assert.Equal(t, []string{"", "Install", "Install > Linux", "Install > Linux > Troubleshooting", "Install > macOS"}, paths)
+
+	assert.Nil(t, chunks[0].Metadata)
+	assert.Equal(t, []string{"Install", "Linux", "Troubleshooting"}, chunks[3].Metadata[HeadingsKey])
+
+	// Split returns the same texts.
+	split, err := MarkdownSplitter{}.Split(testMarkdown, 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, texts, split)
+
+	_, err = MarkdownSplitter{}.Split(testMarkdown, 10, 10)
+	assert.Error(t, err)
+}
+
+func TestMarkdownSplitter_Headings(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{
+			name:     "closing hashes",
+			text:     "## Setup ##\n\ntext",
+			expected: []string{"Setup"},
+		},
+		{
+			name:     "setext",
+			text:     "Guide\n=====\n\nintro\n\nUsage\n-----\n\ntext",
+			expected: []string{"Guide", "Usage"},
+		},
+		{
+			name:     "skipped levels",
+			text:     "# A\n\n### B\n\n## C\n\ntext",
+			expected: []string{"A", "C"},
+		},
+		{
+			name:     "hashtag is not a heading",
+			text:     "#hashtag\n\ntext",
+			expected: nil,
+		},
+		{
+			name:     "heading in code is not a heading",
+			text:     "# A\n\n```sh\n# not a heading\n```",
+			expected: []string{"A"},
+		},
+		{
+			name:     "empty heading",
+			text:     "# A\n\n##\n\ntext",
+			expected: []string{"A"},
+		},
+		{
+			name:     "only empty headings",
+			text:     "# A\n\n# #\n\ntext",
+			expected: nil,
+		},
+		{
+			name:     "underlined row is not a table",
+			text:     "a | b\n---\n\ntext",
+			expected: []string{"a | b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := MarkdownSplitter{}.SplitChunks(tt.text, 1000, 0)
+			require.NoError(t, err)
+			last := chunks[len(chunks)-1]
+			if tt.expected == nil {
+				assert.Nil(t, last.Metadata)
+			} else {
+				assert.Equal(t, tt.expected, last.Metadata[HeadingsKey])
+				assert.Equal(t, strings.Join(tt.expected, " > "), last.Metadata[HeadingPathKey])
+			}
+		})
+	}
+}
+
+func TestMarkdownSplitter_Blocks(t *testing.T) {
+	code := "```go\nfunc main() {\n\n\tfmt.Println(\"hello\")\n}\n```"
+	text := "# Code\n\nSome intro text.\n\n" + code + "\n\nAfter the code."
+
+	// A code block longer than the chunk size is kept whole.
+	chunks, err := MarkdownSplitter{}.Split(text, 30, 0)
+	require.NoError(t, err)
+	assert.Contains(t, chunks, code)
+	for _, chunk := range chunks {
+		assert.Equal(t, 0, strings.Count(chunk, "```")%2, chunk)
+	}
+
+	// A fence closes only with the same character and at least the same length.
+	chunks, err = MarkdownSplitter{}.Split("~~~~\n~~~\n```\n# no\n~~~~\n\n# Yes", 1000, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"~~~~\n~~~\n```\n# no\n~~~~", "# Yes"}, chunks)
+
+	// A long table is split between rows, repeating the header.
+	table := "| Name | Value |\n| --- | --- |\n| a | 1 |\n| b | 2 |\n| c | 3 |\n| d | 4 |"
+	chunks, err = MarkdownSplitter{}.Split(table, 55, 0)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	for _, chunk := range chunks {
+		assert.True(t, strings.HasPrefix(chunk, "| Name | Value |\n| --- | --- |\n| "), chunk)
+		assert.LessOrEqual(t, runeLength(chunk), 55)
+	}
+
+	// So is an indented code block, with its blank lines, while an indented line in a paragraph
+	// continues it.
+	indented := "    func main() {\n\n    \t# not a heading\n\t}"
+	chunks, err = MarkdownSplitter{}.Split("Intro text.\n\n"+indented+"\n\n    \nAfter the code,\n    and more.", 30, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Intro text.", indented, "After the code,\n    and more."}, chunks)
+
+	// A table without rows is kept whole too.
+	header := "| column one | column two | column three |\n|---|---|---|"
+	chunks, err = MarkdownSplitter{}.Split("# T\n\nintro\n\n"+header+"\n\nafter\n", 20, 0)
+	require.NoError(t, err)
+	assert.Contains(t, chunks, header)
+
+	// Tables are found without leading pipes too.
+	table = "Name | Value\n--- | ---\na | 1\nb | 2\nc | 3\nd | 4"
+	chunks, err = MarkdownSplitter{}.Split("Intro.\n"+table, 40, 0)
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+	assert.Equal(t, "Intro.", chunks[0])
+	for _, chunk := range chunks[1:] {
+		assert.True(t, strings.HasPrefix(chunk, "Name | Value\n--- | ---\n"), chunk)
+		assert.LessOrEqual(t, runeLength(chunk), 40)
+	}
+
+	// A long paragraph is split at sentences, and its heading stays with the first one.
+	chunks, err = MarkdownSplitter{}.Split("# Long\n\nFirst sentence here. Second sentence here.", 30, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"# Long\n\nFirst sentence here.", "Second sentence here."}, chunks)
+
+	// The heading of a long table stays with its first part.
+	chunks, err = MarkdownSplitter{}.Split("# Table\n\n"+table, 40, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"# Table\n\nName | Value\n--- | ---\na | 1", "Name | Value\n--- | ---\nb | 2\nc | 3\nd | 4"}, chunks)
+
+	// A heading that leaves no room for the text after it is a chunk of its own, but an empty
+	// heading is dropped.
+	chunks, err = MarkdownSplitter{}.Split("# A very long title\n\nSome text.\n\n#\n\n##", 18, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"# A very long title", "Some text."}, chunks)
+
+	// Short blocks of a section share a chunk, with overlap between chunks.
+	chunks, err = MarkdownSplitter{}.Split("one\n\ntwo\n\nthree\n\nfour", 13, 7)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one\n\ntwo", "two\n\nthree", "three\n\nfour"}, chunks)
+}
+
+func TestMarkdownSplitter_Tokenizer(t *testing.T) {
+	splitter := MarkdownSplitter{Tokenizer: wordTokenizer{}}
+	chunks, err := splitter.Split("# Title\n\none two three four", 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"# Title\n\none", "two three four"}, chunks)
+
+	assert.NotEqual(t, MarkdownSplitter{}.String(), splitter.String())
+}
+
+func TestCollection_MarkdownSplitter(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, err := NewCollectionWithEmbedder("test", "docType", "queryType", 100, 0, embedder)
+	require.NoError(t, err)
+	collection.Splitter = MarkdownSplitter{}
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: testMarkdown}))
+	require.NoError(t, collection.AddDocuments([]*Document{{ID: "2", Content: "# Install\n\n## Windows\n\nRun setup.exe."}}, AddDocumentsOptions{}))
+
+	doc, _ := collection.GetDocument("1")
+	require.Len(t, doc.Segments, 5)
+	assert.Equal(t, "Install > Linux > Troubleshooting", doc.Segments[3].Metadata[HeadingPathKey])
+
+	// Filters match the headings of the segments.
+	results, err := collection.Query("query", 10, Filter{"$segment": Filter{HeadingsKey: "Linux"}})
+	require.NoError(t, err)
+	var texts []string
+	for _, result := range results {
+		texts = append(texts, result.Segment.Text)
+	}
+	assert.ElementsMatch(t, []string{"## Linux\n\nRun the installer.", "### Troubleshooting\n\nCheck the permissions."}, texts)
+
+	results, err = collection.Query("query", 10, Filter{"$segment": Filter{HeadingPathKey: "Install > Windows"}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+
+	// Segments without headings match $exists: false.
+	results, err = collection.Query("query", 10, Filter{"$segment": Filter{HeadingsKey: Filter{"$exists": false}}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "Preamble before any heading.", results[0].Segment.Text)
+
+	// So does the index.
+	hnsw, err := NewHNSWIndex(HNSWConfig{Seed: 1})
+	require.NoError(t, err)
+	require.NoError(t, collection.SetIndex(hnsw))
+	results, err = collection.Query("query", 10, Filter{"$segment": Filter{HeadingsKey: "Linux"}})
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	// Keyword search applies the same filter.
+	index, err := NewKeywordIndex(KeywordIndexConfig{})
+	require.NoError(t, err)
+	collection.SetKeywordIndex(index)
+	results, err = collection.HybridSearch("installer permissions homebrew", 10, HybridOptions{Filter: Filter{"$segment": Filter{HeadingsKey: "macOS"}}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "## macOS\n\nUse Homebrew.", results[0].Segment.Text)
+
+	// The metadata of the segments is saved with the collection.
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollectionWithContextFunc(&buf, embedder.Embed)
+	require.NoError(t, err)
+	loadedDoc, _ := loaded.GetDocument("1")
+	assert.Equal(t, []string{"Install", "Linux", "Troubleshooting"}, loadedDoc.Segments[3].Metadata[HeadingsKey])
+
+	// Updating the content keeps the embeddings of unchanged segments, with their new headings.
+	embedder.calls = nil
+	require.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: strings.Replace(testMarkdown, "## macOS", "## Darwin", 1)}))
+	doc, _ = collection.GetDocument("1")
+	assert.Equal(t, [][]string{{"## Darwin\n\nUse Homebrew."}}, embedder.calls)
+	assert.Equal(t, "Install > Darwin", doc.Segments[4].Metadata[HeadingPathKey])
+}
@@ -0,0 +1,500 @@
+package vector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetadataIndexType is the type of a metadata index created by CreateMetadataIndex.
+type MetadataIndexType int
+
+const (
+	// HashIndex maps every value of a metadata key to the documents that have it,
+	// and answers equality and $in filters.
+	HashIndex MetadataIndexType = iota
+	// SortedIndex keeps the numeric and time values of a metadata key in order,
+	// and answers $gt, $gte, $lt and $lte filters.
+	SortedIndex
+)
+
+// String returns the name of the index type.
+func (t MetadataIndexType) String() string {
+	switch t {
+	case HashIndex:
+		return "hash"
+	case SortedIndex:
+		return "sorted"
+	default:
+		return fmt.Sprintf("MetadataIndexType(%d)", int(t))
+	}
+}
+
+// metadataIndex is a secondary index over the values of a single metadata key.
+type metadataIndex interface {
+	// indexType returns the type the index was created with.
+	indexType() MetadataIndexType
+	// add indexes the metadata value of the document with the given ID.
+	add(id string, value interface{})
+	// remove removes the document with the given ID from the index.
+	remove(id string)
+	// lookup returns a superset of the IDs of the documents whose value satisfies every
+	// operator the index can answer. It reports false if it cannot answer any of them.
+	lookup(operators Filter) (map[string]struct{}, bool)
+}
+
+// newMetadataIndex creates an empty metadata index of the given type.
+func newMetadataIndex(indexType MetadataIndexType) (metadataIndex, error) {
+	switch indexType {
+	case HashIndex:
+		return newHashIndex(), nil
+	case SortedIndex:
+		return newSortedIndex(), nil
+	default:
+		return nil, fmt.Errorf("unknown metadata index type %d", int(indexType))
+	}
+}
+
+// CreateMetadataIndex creates an index of the given type over the values of a metadata key.
+// Filters passed to Query that constrain an indexed key only check the documents selected
+// by the index, instead of evaluating the filter against every document.
+// The index is kept up to date as documents are added, updated and deleted, but metadata
+// changed in place on a stored document is not seen by the index.
+func (c *Collection) CreateMetadataIndex(key string, indexType MetadataIndexType) error {
+	if key == "" {
+		return errors.New("metadata key is required")
+	}
+	if strings.HasPrefix(key, "$") {
+		return fmt.Errorf("metadata key %s must not start with $", key)
+	}
+	index, err := newMetadataIndex(indexType)
+	if err != nil {
+		return err
+	}
+
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if _, ok := c.metadataIndexes[key]; ok {
+		return fmt.Errorf("metadata key %s is already indexed", key)
+	}
+	for id, doc := range c.documents {
+		if value, ok := doc.Metadata[key]; ok {
+			index.add(id, value)
+		}
+	}
+	if c.metadataIndexes == nil {
+		c.metadataIndexes = make(map[string]metadataIndex)
+	}
+	c.metadataIndexes[key] = index
+	return nil
+}
+
+// DropMetadataIndex removes the index over a metadata key.
+func (c *Collection) DropMetadataIndex(key string) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if _, ok := c.metadataIndexes[key]; !ok {
+		return fmt.Errorf("metadata key %s is not indexed", key)
+	}
+	delete(c.metadataIndexes, key)
+	return nil
+}
+
+// MetadataIndexes returns the type of the index over every indexed metadata key.
+func (c *Collection) MetadataIndexes() map[string]MetadataIndexType {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	indexes := make(map[string]MetadataIndexType, len(c.metadataIndexes))
+	for key, index := range c.metadataIndexes {
+		indexes[key] = index.indexType()
+	}
+	return indexes
+}
+
+// planFilter returns a superset of the IDs of the documents matching filter, computed
+// from the metadata indexes. It reports false if no part of the filter can be answered
+// by an index, in which case every document has to be checked.
+// The caller must hold c.documentsLock.
+func (c *Collection) planFilter(filter Filter) (map[string]struct{}, bool) {
+	if len(c.metadataIndexes) == 0 {
+		return nil, false
+	}
+
+	var candidates map[string]struct{}
+	planned := false
+	for key, value := range filter {
+		ids, ok := c.planFilterKey(key, value)
+		if !ok {
+			continue
+		}
+		if planned {
+			candidates = intersectIDs(candidates, ids)
+		} else {
+			candidates, planned = ids, true
+		}
+	}
+	return candidates, planned
+}
+
+// planFilterKey plans a single key of a filter and its value.
+// The caller must hold c.documentsLock.
+func (c *Collection) planFilterKey(key string, value interface{}) (map[string]struct{}, bool) {
+	switch key {
+	case "$and":
+		filters, err := toFilterList(value)
+		if err != nil {
+			return nil, false
+		}
+		var candidates map[string]struct{}
+		planned := false
+		for _, f := range filters {
+			ids, ok := c.planFilter(f)
+			if !ok {
+				continue
+			}
+			if planned {
+				candidates = intersectIDs(candidates, ids)
+			} else {
+				candidates, planned = ids, true
+			}
+		}
+		return candidates, planned
+	case "$or":
+		// A branch that cannot be planned may match any document, and so may the $or.
+		filters, err := toFilterList(value)
+		if err != nil {
+			return nil, false
+		}
+		candidates := make(map[string]struct{})
+		for _, f := range filters {
+			ids, ok := c.planFilter(f)
+			if !ok {
+				return nil, false
+			}
+			for id := range ids {
+				candidates[id] = struct{}{}
+			}
+		}
+		return candidates, true
+	}
+
+	// $not, $ne, $nin and $exists match the documents an index does not point to,
+	// so they are left to the filter.
+	if strings.HasPrefix(key, "$") {
+		return nil, false
+	}
+	index, ok := c.metadataIndexes[key]
+	if !ok {
+		return nil, false
+	}
+	operators, ok := toFilter(value)
+	if !ok || !isOperatorFilter(operators) {
+		operators = Filter{"$eq": value}
+	}
+	return index.lookup(operators)
+}
+
+// intersectIDs returns the IDs contained in both sets.
+func intersectIDs(a, b map[string]struct{}) map[string]struct{} {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	ids := make(map[string]struct{}, len(a))
+	for id := range a {
+		if _, ok := b[id]; ok {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids
+}
+
+// hashIndex is a metadata index from values to the documents that have them.
+// Slice values are indexed by each of their elements, like equality filters match them.
+type hashIndex struct {
+	ids  map[interface{}]map[string]struct{} // document IDs by hash key.
+	keys map[string][]interface{}            // hash keys by document ID.
+	// unhashable holds the documents with values that have no hash key, such as NaN.
+	// They are returned by every lookup.
+	unhashable map[string]struct{}
+}
+
+// newHashIndex creates an empty hash index.
+func newHashIndex() *hashIndex {
+	return &hashIndex{
+		ids:        make(map[interface{}]map[string]struct{}),
+		keys:       make(map[string][]interface{}),
+		unhashable: make(map[string]struct{}),
+	}
+}
+
+// timeKey is the hash key of a time, which identifies its instant whatever its time zone.
+type timeKey struct {
+	seconds     int64
+	nanoseconds int
+}
+
+// hashKey returns the key under which a value is indexed, so that values that are equal
+// according to valuesEqual share the same key. Numbers are keyed by their float64 value,
+// and times, including strings in RFC 3339 format, by their instant.
+// It reports false for values without a key.
+func hashKey(value interface{}) (interface{}, bool) {
+	if f, ok := toFloat(value); ok {
+		if math.IsNaN(f) {
+			return nil, false
+		}
+		return f, true
+	}
+	if t, ok := toTime(value); ok {
+		return timeKey{t.Unix(), t.Nanosecond()}, true
+	}
+	switch v := value.(type) {
+	case string, bool:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+func (h *hashIndex) indexType() MetadataIndexType {
+	return HashIndex
+}
+
+func (h *hashIndex) add(id string, value interface{}) {
+	h.remove(id)
+
+	values, ok := toList(value)
+	if !ok {
+		values = []interface{}{value}
+	}
+	var keys []interface{}
+	for _, v := range values {
+		key, ok := hashKey(v)
+		if !ok {
+			h.unhashable[id] = struct{}{}
+			continue
+		}
+		if h.ids[key] == nil {
+			h.ids[key] = make(map[string]struct{})
+		}
+		h.ids[key][id] = struct{}{}
+		keys = append(keys, key)
+	}
+	if len(keys) > 0 {
+		h.keys[id] = keys
+	}
+}
+
+func (h *hashIndex) remove(id string) {
+	for _, key := range h.keys[id] {
+		delete(h.ids[key], id)
+		if len(h.ids[key]) == 0 {
+			delete(h.ids, key)
+		}
+	}
+	delete(h.keys, id)
+	delete(h.unhashable, id)
+}
+
+func (h *hashIndex) lookup(operators Filter) (map[string]struct{}, bool) {
+	var candidates map[string]struct{}
+	planned := false
+	for name, operand := range operators {
+		var operands []interface{}
+		switch name {
+		case "$eq":
+			operands = []interface{}{operand}
+		case "$in":
+			list, ok := toList(operand)
+			if !ok {
+				continue
+			}
+			operands = list
+		default:
+			continue
+		}
+
+		ids, ok := h.lookupValues(operands)
+		if !ok {
+			continue
+		}
+		if planned {
+			candidates = intersectIDs(candidates, ids)
+		} else {
+			candidates, planned = ids, true
+		}
+	}
+	return candidates, planned
+}
+
+// lookupValues returns the documents with a value equal to any of the operands.
+// It reports false if an operand has no hash key.
+func (h *hashIndex) lookupValues(operands []interface{}) (map[string]struct{}, bool) {
+	ids := make(map[string]struct{}, len(h.unhashable))
+	for _, operand := range operands {
+		key, ok := hashKey(operand)
+		if !ok {
+			return nil, false
+		}
+		for id := range h.ids[key] {
+			ids[id] = struct{}{}
+		}
+	}
+	for id := range h.unhashable {
+		ids[id] = struct{}{}
+	}
+	return ids, true
+}
+
+// sortedEntry is a value in a sorted index, either a float64 or a time.Time.
+type sortedEntry struct {
+	value interface{}
+	id    string
+}
+
+// sortedIndex is a metadata index that keeps numbers and times in order.
+// Times include strings in RFC 3339 format, which range filters compare with times.
+// Other values never match a numeric or time range, so they are not indexed.
+type sortedIndex struct {
+	numbers []sortedEntry          // ordered by value, then ID.
+	times   []sortedEntry          // ordered by value, then ID.
+	values  map[string]interface{} // indexed value by document ID.
+	// unordered holds the documents with a NaN value, which compares equal to every number.
+	// They are returned by every numeric lookup.
+	unordered map[string]struct{}
+}
+
+// newSortedIndex creates an empty sorted index.
+func newSortedIndex() *sortedIndex {
+	return &sortedIndex{
+		values:    make(map[string]interface{}),
+		unordered: make(map[string]struct{}),
+	}
+}
+
+func (s *sortedIndex) indexType() MetadataIndexType {
+	return SortedIndex
+}
+
+// entries returns the slice that holds value, or nil if value is neither a number nor a time.
+func (s *sortedIndex) entries(value interface{}) *[]sortedEntry {
+	switch value.(type) {
+	case float64:
+		return &s.numbers
+	case time.Time:
+		return &s.times
+	default:
+		return nil
+	}
+}
+
+// searchSorted returns the position of the first entry not less than (value, id).
+func searchSorted(entries []sortedEntry, value interface{}, id string) int {
+	return sort.Search(len(entries), func(i int) bool {
+		cmp, _ := compareValues(entries[i].value, value)
+		return cmp > 0 || cmp == 0 && entries[i].id >= id
+	})
+}
+
+func (s *sortedIndex) add(id string, value interface{}) {
+	s.remove(id)
+
+	if f, ok := toFloat(value); ok {
+		if math.IsNaN(f) {
+			s.unordered[id] = struct{}{}
+			return
+		}
+		value = f
+	} else if t, ok := toTime(value); ok {
+		value = t
+	} else {
+		return
+	}
+
+	entries := s.entries(value)
+	i := searchSorted(*entries, value, id)
+	*entries = append(*entries, sortedEntry{})
+	copy((*entries)[i+1:], (*entries)[i:])
+	(*entries)[i] = sortedEntry{value: value, id: id}
+	s.values[id] = value
+}
+
+func (s *sortedIndex) remove(id string) {
+	delete(s.unordered, id)
+	value, ok := s.values[id]
+	if !ok {
+		return
+	}
+	delete(s.values, id)
+
+	entries := s.entries(value)
+	i := searchSorted(*entries, value, id)
+	if i < len(*entries) && (*entries)[i].id == id {
+		*entries = append((*entries)[:i], (*entries)[i+1:]...)
+	}
+}
+
+func (s *sortedIndex) lookup(operators Filter) (map[string]struct{}, bool) {
+	// Range operands must all be numbers or all be times. String operands also compare with
+	// the strings that are not times by their bytes, which are not indexed.
+	var numeric, temporal bool
+	bounds := make(map[string]interface{})
+	for name, operand := range operators {
+		switch name {
+		case "$gt", "$gte", "$lt", "$lte":
+		default:
+			continue
+		}
+		if f, ok := toFloat(operand); ok && !math.IsNaN(f) {
+			bounds[name], numeric = f, true
+		} else if t, ok := operand.(time.Time); ok {
+			bounds[name], temporal = t, true
+		} else {
+			return nil, false
+		}
+	}
+	if numeric == temporal {
+		return nil, false
+	}
+	entries := s.times
+	if numeric {
+		entries = s.numbers
+	}
+
+	lo, hi := 0, len(entries)
+	for name, bound := range bounds {
+		// The position of the first entry greater than, or not less than, the bound.
+		first := func(strict bool) int {
+			return sort.Search(len(entries), func(i int) bool {
+				cmp, _ := compareValues(entries[i].value, bound)
+				return cmp > 0 || !strict && cmp == 0
+			})
+		}
+		switch name {
+		case "$gt":
+			lo = max(lo, first(true))
+		case "$gte":
+			lo = max(lo, first(false))
+		case "$lt":
+			hi = min(hi, first(false))
+		case "$lte":
+			hi = min(hi, first(true))
+		}
+	}
+
+	ids := make(map[string]struct{})
+	for i := lo; i < hi; i++ {
+		ids[entries[i].id] = struct{}{}
+	}
+	if numeric {
+		for id := range s.unordered {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, true
+}
@@ -0,0 +1,236 @@
+package vector
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sortedIDs returns the IDs of a set in order.
+func sortedIDs(ids map[string]struct{}) []string {
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestHashIndex(t *testing.T) {
+	index := newHashIndex()
+	index.add("1", "acme")
+	index.add("2", 7)
+	index.add("3", []string{"go", "acme"})
+	index.add("4", math.NaN())
+	index.add("5", 7.0)
+	index.add("6", true)
+	index.add("7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name      string
+		operators Filter
+		expected  []string
+		planned   bool
+	}{
+		{"Eq String", Filter{"$eq": "acme"}, []string{"1", "3", "4"}, true},
+		{"Eq Number Across Types", Filter{"$eq": int64(7)}, []string{"2", "4", "5"}, true},
+		{"Eq Bool", Filter{"$eq": true}, []string{"4", "6"}, true},
+		{"In", Filter{"$in": []interface{}{"go", 7}}, []string{"2", "3", "4", "5"}, true},
+		{"Eq And In", Filter{"$eq": "acme", "$in": []string{"acme", "go"}}, []string{"1", "3", "4"}, true},
+		{"Eq Time", Filter{"$eq": time.Date(2024, 1, 1, 2, 0, 0, 0, time.FixedZone("", 2*60*60))}, []string{"4", "7"}, true},
+		{"Eq Time String", Filter{"$eq": "2023-12-31T19:00:00-05:00"}, []string{"4", "7"}, true},
+		{"Eq Whole Slice", Filter{"$eq": []string{"go", "acme"}}, nil, false},
+		{"Range", Filter{"$gt": 1}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, ok := index.lookup(tt.operators)
+			assert.Equal(t, tt.planned, ok)
+			if tt.planned {
+				assert.Equal(t, tt.expected, sortedIDs(ids))
+			}
+		})
+	}
+
+	index.remove("3")
+	index.remove("4")
+	index.add("5", "acme")
+	ids, _ := index.lookup(Filter{"$eq": "acme"})
+	assert.Equal(t, []string{"1", "5"}, sortedIDs(ids))
+	ids, _ = index.lookup(Filter{"$eq": 7})
+	assert.Equal(t, []string{"2"}, sortedIDs(ids))
+	assert.NotContains(t, index.ids, "go")
+}
+
+func TestSortedIndex(t *testing.T) {
+	index := newSortedIndex()
+	for i, value := range []interface{}{5, 1.5, int64(10), uint8(3), 5.0, "not a number", []int{4}} {
+		index.add(string(rune('a'+i)), value)
+	}
+	index.add("h", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	index.add("i", "2024-06-01T00:00:00Z")
+	index.add("j", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name      string
+		operators Filter
+		expected  []string
+		planned   bool
+	}{
+		{"Gt", Filter{"$gt": 5}, []string{"c"}, true},
+		{"Gte", Filter{"$gte": 5}, []string{"a", "c", "e"}, true},
+		{"Lt", Filter{"$lt": 5}, []string{"b", "d"}, true},
+		{"Lte", Filter{"$lte": 5.0}, []string{"a", "b", "d", "e"}, true},
+		{"Between", Filter{"$gt": 1.5, "$lt": 10}, []string{"a", "d", "e"}, true},
+		{"Empty Range", Filter{"$gt": 10, "$lt": 1}, []string{}, true},
+		{"Time Range", Filter{"$gte": time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, []string{"i", "j"}, true},
+		{"String Operand", Filter{"$gte": "2024"}, nil, false},
+		{"Mixed Operands", Filter{"$gte": 1, "$lt": time.Now()}, nil, false},
+		{"Equality", Filter{"$eq": 5}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, ok := index.lookup(tt.operators)
+			assert.Equal(t, tt.planned, ok)
+			if tt.planned {
+				assert.Equal(t, tt.expected, sortedIDs(ids))
+			}
+		})
+	}
+
+	// A NaN compares equal to every number, so it is returned by every numeric lookup.
+	index.add("k", math.NaN())
+	ids, _ := index.lookup(Filter{"$gt": 100})
+	assert.Equal(t, []string{"k"}, sortedIDs(ids))
+
+	index.remove("a")
+	index.remove("k")
+	index.add("c", 0)
+	ids, _ = index.lookup(Filter{"$gte": 5})
+	assert.Equal(t, []string{"e"}, sortedIDs(ids))
+	assert.Len(t, index.numbers, 4)
+}
+
+func TestCollection_CreateMetadataIndex(t *testing.T) {
+	collection := newFilterTestCollection(t, 20)
+
+	assert.Error(t, collection.CreateMetadataIndex("", HashIndex))
+	assert.Error(t, collection.CreateMetadataIndex("$or", HashIndex))
+	assert.Error(t, collection.CreateMetadataIndex("tenant", MetadataIndexType(9)))
+	require.NoError(t, collection.CreateMetadataIndex("tenant", HashIndex))
+	require.NoError(t, collection.CreateMetadataIndex("n", SortedIndex))
+	assert.Error(t, collection.CreateMetadataIndex("tenant", SortedIndex))
+	assert.Equal(t, map[string]MetadataIndexType{"tenant": HashIndex, "n": SortedIndex}, collection.MetadataIndexes())
+
+	// The indexes are built from the existing documents.
+	ids, ok := collection.planFilter(Filter{"tenant": "a"})
+	require.True(t, ok)
+	assert.Equal(t, []string{"doc0", "doc10"}, sortedIDs(ids))
+
+	// The indexes follow additions, updates and deletions.
+	require.NoError(t, collection.AddDocument(&Document{ID: "new", Content: "content 1", Metadata: map[string]interface{}{"tenant": "a", "n": 100}}))
+	require.NoError(t, collection.UpdateDocument(&Document{ID: "doc0", Content: "content 0", Metadata: map[string]interface{}{"tenant": "b", "n": 0}}))
+	require.NoError(t, collection.DeleteDocument("doc10"))
+	ids, _ = collection.planFilter(Filter{"tenant": "a"})
+	assert.Equal(t, []string{"new"}, sortedIDs(ids))
+	ids, _ = collection.planFilter(Filter{"n": Filter{"$gte": 18}})
+	assert.Equal(t, []string{"doc18", "doc19", "new"}, sortedIDs(ids))
+
+	// The index definitions are saved with the collection and rebuilt when loading.
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollectionWithContextFunc(&buf, collection.embeddingFunc)
+	require.NoError(t, err)
+	assert.Equal(t, collection.MetadataIndexes(), loaded.MetadataIndexes())
+	ids, _ = loaded.planFilter(Filter{"tenant": "a"})
+	assert.Equal(t, []string{"new"}, sortedIDs(ids))
+
+	require.NoError(t, collection.DropMetadataIndex("tenant"))
+	assert.Error(t, collection.DropMetadataIndex("tenant"))
+	_, ok = collection.planFilter(Filter{"tenant": "a"})
+	assert.False(t, ok)
+}
+
+func TestCollection_PlanFilter(t *testing.T) {
+	collection := newFilterTestCollection(t, 30)
+	require.NoError(t, collection.CreateMetadataIndex("tenant", HashIndex))
+	require.NoError(t, collection.CreateMetadataIndex("n", SortedIndex))
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []string
+		planned  bool
+	}{
+		{"Equality", Filter{"tenant": "a"}, []string{"doc0", "doc10", "doc20"}, true},
+		{"Range", Filter{"n": Filter{"$lt": 2}}, []string{"doc0", "doc1"}, true},
+		{"Implicit And", Filter{"tenant": "a", "n": Filter{"$gt": 5}}, []string{"doc10", "doc20"}, true},
+		{"And With Unindexed Key", Filter{"$and": []Filter{{"tenant": "a"}, {"other": 1}}}, []string{"doc0", "doc10", "doc20"}, true},
+		{"Or", Filter{"$or": []Filter{{"tenant": "a"}, {"n": Filter{"$gte": 28}}}}, []string{"doc0", "doc10", "doc20", "doc28", "doc29"}, true},
+		{"Or With Unindexed Branch", Filter{"$or": []Filter{{"tenant": "a"}, {"other": 1}}}, nil, false},
+		{"Not", Filter{"$not": Filter{"tenant": "a"}}, nil, false},
+		{"Ne", Filter{"tenant": Filter{"$ne": "a"}}, nil, false},
+		{"Unindexed", Filter{"other": 1}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collection.documentsLock.RLock()
+			defer collection.documentsLock.RUnlock()
+
+			ids, ok := collection.planFilter(tt.filter)
+			assert.Equal(t, tt.planned, ok)
+			if tt.planned {
+				assert.Equal(t, tt.expected, sortedIDs(ids))
+			}
+		})
+	}
+}
+
+func TestCollection_QueryWithMetadataIndex(t *testing.T) {
+	collection := newFilterTestCollection(t, 300)
+
+	filters := []Filter{
+		{"tenant": "a"},
+		{"tenant": Filter{"$in": []string{"a", "c"}}, "n": Filter{"$gte": 100}},
+		{"n": Filter{"$gt": 10, "$lte": 20}},
+		{"$or": []Filter{{"tenant": "a"}, {"n": Filter{"$lt": 5}}}},
+		{"tenant": "b", "$not": Filter{"n": Filter{"$lt": 290}}},
+		{"tenant": "c"},
+	}
+
+	// The results with indexes match the results of evaluating the filter on every document.
+	expected := make([][]Result, len(filters))
+	for i, filter := range filters {
+		results, err := collection.Query("query", 5, filter)
+		require.NoError(t, err)
+		expected[i] = results
+	}
+
+	require.NoError(t, collection.CreateMetadataIndex("tenant", HashIndex))
+	require.NoError(t, collection.CreateMetadataIndex("n", SortedIndex))
+	for i, filter := range filters {
+		results, err := collection.Query("query", 5, filter)
+		require.NoError(t, err)
+		assert.Equal(t, expected[i], results, "filter %v", filter)
+	}
+
+	index, _ := NewHNSWIndex(HNSWConfig{Seed: 1})
+	require.NoError(t, collection.SetIndex(index))
+	for i, filter := range filters {
+		results, err := collection.Query("query", 5, filter)
+		require.NoError(t, err)
+		require.Len(t, results, len(expected[i]), "filter %v", filter)
+		matcher, _ := compileFilter(filter)
+		for _, result := range results {
+			assert.True(t, matcher(result.Document.Metadata, nil))
+		}
+	}
+}
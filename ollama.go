@@ -0,0 +1,148 @@
+package vector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrModelNotFound is returned by an OllamaEmbedder when the model has not been pulled.
+	ErrModelNotFound = errors.New("model not found")
+	// ErrContextLength is returned by an OllamaEmbedder when an input is longer than the
+	// context of the model and truncation is disabled.
+	ErrContextLength = errors.New("input exceeds the context length of the model")
+)
+
+// OllamaConfig configures an OllamaEmbedder.
+// Zero values are replaced by the defaults noted on each field.
+type OllamaConfig struct {
+	// BaseURL is the URL of the Ollama server. Defaults to http://localhost:11434.
+	BaseURL string
+	// Model is the name of the embedding model, such as "nomic-embed-text". It is required.
+	Model string
+	// Prefixes maps the embedding types of the collection to text prepended to every input,
+	// for models trained with instructions such as "search_document: " and "search_query: ".
+	Prefixes map[string]string
+	// MaxBatchSize is the largest number of inputs sent in a single request. Defaults to 32.
+	MaxBatchSize int
+	// Truncate cuts inputs that are longer than the context of the model. By default,
+	// they fail with ErrContextLength instead of being silently shortened.
+	Truncate bool
+	// Timeout limits each request. Defaults to 60 seconds.
+	Timeout time.Duration
+	// HTTPClient sends the requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+const (
+	defaultOllamaBaseURL      = "http://localhost:11434"
+	defaultOllamaMaxBatchSize = 32
+)
+
+// OllamaEmbedder generates embeddings with the /api/embed endpoint of an Ollama server.
+type OllamaEmbedder struct {
+	config OllamaConfig
+}
+
+// NewOllamaEmbedder creates an OllamaEmbedder.
+func NewOllamaEmbedder(config OllamaConfig) (*OllamaEmbedder, error) {
+	if config.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if config.MaxBatchSize < 0 {
+		return nil, errors.New("max batch size must be greater than or equal to zero")
+	}
+	if config.Timeout < 0 {
+		return nil, errors.New("timeout must be greater than or equal to zero")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = defaultOllamaBaseURL
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	if config.MaxBatchSize == 0 {
+		config.MaxBatchSize = defaultOllamaMaxBatchSize
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultHTTPTimeout
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &OllamaEmbedder{config: config}, nil
+}
+
+// ollamaRequest is the body of an /api/embed request.
+type ollamaRequest struct {
+	Model    string   `json:"model"`
+	Input    []string `json:"input"`
+	Truncate bool     `json:"truncate"`
+}
+
+// ollamaResponse is the body of a successful /api/embed response.
+type ollamaResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+// Embed generates an embedding for every input, sending one request per batch.
+func (e *OllamaEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	inputs = withPrefix(inputs, e.config.Prefixes[embeddingType])
+	embeddings := make([][]float64, 0, len(inputs))
+	for start := 0; start < len(inputs); start += e.config.MaxBatchSize {
+		batch := inputs[start:min(start+e.config.MaxBatchSize, len(inputs))]
+		batchEmbeddings, err := e.embed(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, batchEmbeddings...)
+	}
+	return embeddings, nil
+}
+
+// embed generates embeddings for a single batch.
+func (e *OllamaEmbedder) embed(ctx context.Context, inputs []string) ([][]float64, error) {
+	request := ollamaRequest{
+		Model:    e.config.Model,
+		Input:    inputs,
+		Truncate: e.config.Truncate,
+	}
+
+	var response ollamaResponse
+	if err := postJSON(ctx, e.config.HTTPClient, e.config.BaseURL+"/api/embed", nil, e.config.Timeout, request, &response); err != nil {
+		return nil, ollamaError(e.config.Model, err)
+	}
+	if len(response.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("%w: expected %d embeddings, got %d", ErrEmbeddingCountMismatch, len(inputs), len(response.Embeddings))
+	}
+	return response.Embeddings, nil
+}
+
+// ollamaError translates the error responses of Ollama that callers act on into
+// ErrModelNotFound and ErrContextLength, which are not transient. Other responses, such as a
+// 404 from a server without /api/embed, are returned as they are.
+func ollamaError(model string, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	message := strings.ToLower(apiErr.Message)
+	switch {
+	case strings.Contains(message, "model") && strings.Contains(message, "not found"):
+		return fmt.Errorf("%w: %q, pull it with \"ollama pull %s\": %w", ErrModelNotFound, model, model, apiErr)
+	case strings.Contains(message, "context length"):
+		// Ollama answers with a 500, but the same input fails again.
+		return &permanentError{err: fmt.Errorf("%w: %w", ErrContextLength, apiErr)}
+	}
+	return err
+}
+
+// EmbeddingFunc returns an EmbeddingFunc that calls Embed without a context, for use with
+// NewCollection. Use NewCollectionWithEmbedder to pass the context of each call on.
+func (e *OllamaEmbedder) EmbeddingFunc() EmbeddingFunc {
+	return func(inputs []string, embeddingType string) ([][]float64, error) {
+		return e.Embed(context.Background(), inputs, embeddingType)
+	}
+}
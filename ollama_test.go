@@ -0,0 +1,181 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOllamaEmbedder(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  OllamaConfig
+		wantErr bool
+	}{
+		{"Defaults", OllamaConfig{Model: "nomic-embed-text"}, false},
+		{"Missing Model", OllamaConfig{}, true},
+		{"Negative Batch Size", OllamaConfig{Model: "m", MaxBatchSize: -1}, true},
+		{"Negative Timeout", OllamaConfig{Model: "m", Timeout: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedder, err := NewOllamaEmbedder(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, defaultOllamaBaseURL, embedder.config.BaseURL)
+			assert.Equal(t, defaultOllamaMaxBatchSize, embedder.config.MaxBatchSize)
+			assert.Equal(t, defaultHTTPTimeout, embedder.config.Timeout)
+		})
+	}
+}
+
+func TestOllamaEmbedder_Embed(t *testing.T) {
+	var mu sync.Mutex
+	var requests []ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/embed", r.URL.Path)
+		var request ollamaRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		mu.Lock()
+		requests = append(requests, request)
+		mu.Unlock()
+
+		var response ollamaResponse
+		for _, input := range request.Input {
+			response.Embeddings = append(response.Embeddings, []float64{float64(len(input))})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer server.Close()
+
+	embedder, err := NewOllamaEmbedder(OllamaConfig{
+		BaseURL:      server.URL,
+		Model:        "nomic-embed-text",
+		Prefixes:     map[string]string{"docType": "search_document: ", "queryType": "search_query: "},
+		MaxBatchSize: 2,
+	})
+	require.NoError(t, err)
+
+	embeddings, err := embedder.Embed(context.Background(), []string{"a", "bb", "ccc"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{18}, {19}, {20}}, embeddings)
+	assert.Equal(t, []ollamaRequest{
+		{Model: "nomic-embed-text", Input: []string{"search_document: a", "search_document: bb"}},
+		{Model: "nomic-embed-text", Input: []string{"search_document: ccc"}},
+	}, requests)
+
+	// Nothing is sent for no inputs.
+	requests = nil
+	embeddings, err = embedder.Embed(context.Background(), nil, "docType")
+	require.NoError(t, err)
+	assert.Empty(t, embeddings)
+	assert.Empty(t, requests)
+
+	// The embedder plugs into a collection.
+	collection, err := NewCollection("test", "docType", "queryType", 100, 10, embedder.EmbeddingFunc())
+	require.NoError(t, err)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "content"}))
+	results, err := collection.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, []string{"search_query: query"}, requests[len(requests)-1].Input)
+}
+
+func TestOllamaEmbedder_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantErr       error
+		wantAPIError  bool
+		wantTransient bool
+	}{
+		{
+			name:         "Model Not Found",
+			status:       http.StatusNotFound,
+			body:         `{"error": "model \"missing\" not found, try pulling it first"}`,
+			wantErr:      ErrModelNotFound,
+			wantAPIError: true,
+		},
+		{
+			name:         "Endpoint Not Found",
+			status:       http.StatusNotFound,
+			body:         `404 page not found`,
+			wantAPIError: true,
+		},
+		{
+			name:         "Context Length",
+			status:       http.StatusInternalServerError,
+			body:         `{"error": "the input length exceeds the context length"}`,
+			wantErr:      ErrContextLength,
+			wantAPIError: true,
+		},
+		{
+			name:          "Overloaded",
+			status:        http.StatusServiceUnavailable,
+			body:          `{"error": "server busy, please try again"}`,
+			wantAPIError:  true,
+			wantTransient: true,
+		},
+		{
+			name:         "Bad Request",
+			status:       http.StatusBadRequest,
+			body:         `{"error": "invalid input type"}`,
+			wantAPIError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			embedder, err := NewOllamaEmbedder(OllamaConfig{BaseURL: server.URL, Model: "missing"})
+			require.NoError(t, err)
+			_, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+			require.Error(t, err)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NotErrorIs(t, err, ErrModelNotFound)
+			}
+			var apiErr *APIError
+			assert.Equal(t, tt.wantAPIError, errors.As(err, &apiErr))
+			assert.Equal(t, tt.wantTransient, IsTransient(err))
+		})
+	}
+}
+
+func TestOllamaEmbedder_Truncate(t *testing.T) {
+	var request ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, _ = w.Write([]byte(`{"embeddings": [[1]]}`))
+	}))
+	defer server.Close()
+
+	embedder, err := NewOllamaEmbedder(OllamaConfig{BaseURL: server.URL, Model: "m", Truncate: true})
+	require.NoError(t, err)
+	_, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+	require.NoError(t, err)
+	assert.True(t, request.Truncate)
+
+	// A response with the wrong number of embeddings is rejected.
+	_, err = embedder.Embed(context.Background(), []string{"a", "b"}, "docType")
+	assert.Error(t, err)
+}
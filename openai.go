@@ -0,0 +1,288 @@
+package vector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAIConfig configures an OpenAIEmbedder.
+// Zero values are replaced by the defaults noted on each field.
+type OpenAIConfig struct {
+	// BaseURL is the URL that the /embeddings path is appended to. Defaults to
+	// https://api.openai.com/v1. For other servers, it usually ends in /v1 as well.
+	BaseURL string
+	// Model is the name of the embedding model. It is required.
+	Model string
+	// APIKey is sent as a bearer token if it is set.
+	APIKey string
+	// Dimensions asks models that support it for shorter embeddings. Defaults to the
+	// size of the model.
+	Dimensions int
+	// Timeout limits each request. Defaults to 60 seconds.
+	Timeout time.Duration
+	// InputTypes maps the embedding types of the collection to the input_type field of the
+	// request, for servers that embed documents and queries differently. Embedding types
+	// that are not in the map are sent without an input_type.
+	InputTypes map[string]string
+	// Prefixes maps the embedding types of the collection to text prepended to every input,
+	// for models trained with instructions such as "search_document: " and "search_query: ".
+	Prefixes map[string]string
+	// HTTPClient sends the requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+const (
+	defaultOpenAIBaseURL = "https://api.openai.com/v1"
+	defaultHTTPTimeout   = 60 * time.Second
+)
+
+// OpenAIEmbedder generates embeddings with a server that implements the OpenAI
+// /v1/embeddings API, such as OpenAI, vLLM, LocalAI or text-embeddings-inference.
+//
+// Rate limiting and server errors are returned as transient *APIError values, so that a
+// RetryEmbedder retries them.
+type OpenAIEmbedder struct {
+	config OpenAIConfig
+}
+
+// NewOpenAIEmbedder creates an OpenAIEmbedder.
+func NewOpenAIEmbedder(config OpenAIConfig) (*OpenAIEmbedder, error) {
+	if config.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if config.Dimensions < 0 {
+		return nil, errors.New("dimensions must be greater than or equal to zero")
+	}
+	if config.Timeout < 0 {
+		return nil, errors.New("timeout must be greater than or equal to zero")
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = defaultOpenAIBaseURL
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	if config.Timeout == 0 {
+		config.Timeout = defaultHTTPTimeout
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &OpenAIEmbedder{config: config}, nil
+}
+
+// openAIRequest is the body of an embeddings request.
+type openAIRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	InputType      string   `json:"input_type,omitempty"`
+}
+
+// openAIResponse is the body of a successful embeddings response.
+type openAIResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed generates an embedding for every input with a single request.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, inputs []string, embeddingType string) ([][]float64, error) {
+	if len(inputs) == 0 {
+		return [][]float64{}, nil
+	}
+
+	request := openAIRequest{
+		Model:          e.config.Model,
+		Input:          withPrefix(inputs, e.config.Prefixes[embeddingType]),
+		EncodingFormat: "float",
+		Dimensions:     e.config.Dimensions,
+		InputType:      e.config.InputTypes[embeddingType],
+	}
+	header := make(http.Header)
+	if e.config.APIKey != "" {
+		header.Set("Authorization", "Bearer "+e.config.APIKey)
+	}
+
+	var response openAIResponse
+	if err := postJSON(ctx, e.config.HTTPClient, e.config.BaseURL+"/embeddings", header, e.config.Timeout, request, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Data) != len(inputs) {
+		return nil, fmt.Errorf("%w: expected %d embeddings, got %d", ErrEmbeddingCountMismatch, len(inputs), len(response.Data))
+	}
+
+	// The data is usually in the order of the inputs, but carries its index to be sure.
+	sort.Slice(response.Data, func(i, j int) bool { return response.Data[i].Index < response.Data[j].Index })
+	embeddings := make([][]float64, len(inputs))
+	for i, data := range response.Data {
+		if data.Index != i {
+			return nil, fmt.Errorf("missing embedding for input %d", i)
+		}
+		embeddings[i] = data.Embedding
+	}
+	return embeddings, nil
+}
+
+// EmbeddingFunc returns an EmbeddingFunc that calls Embed without a context, for use with
+// NewCollection. Use NewCollectionWithEmbedder to pass the context of each call on.
+func (e *OpenAIEmbedder) EmbeddingFunc() EmbeddingFunc {
+	return func(inputs []string, embeddingType string) ([][]float64, error) {
+		return e.Embed(context.Background(), inputs, embeddingType)
+	}
+}
+
+// withPrefix returns the inputs with prefix prepended to each of them.
+func withPrefix(inputs []string, prefix string) []string {
+	if prefix == "" {
+		return inputs
+	}
+	prefixed := make([]string, len(inputs))
+	for i, input := range inputs {
+		prefixed[i] = prefix + input
+	}
+	return prefixed
+}
+
+// APIError is an error response from an embedding server.
+// Rate limiting (429) and server errors (5xx) are transient.
+type APIError struct {
+	StatusCode int
+	// Message is the error message of the server, or the body of the response
+	// if it has no recognizable message.
+	Message string
+	// Type is the type or code of the error reported by the server, if any.
+	Type string
+	// retryAfter is the delay requested by the Retry-After header.
+	retryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("embedding request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("embedding request failed with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Transient reports whether the request is worth retrying.
+func (e *APIError) Transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// RetryAfter returns the delay requested by the server before retrying, or zero.
+func (e *APIError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// maxErrorBodySize limits how much of an error response is read.
+const maxErrorBodySize = 64 << 10
+
+// postJSON sends body as JSON to url and decodes the JSON response into result.
+// Responses other than 200 are returned as an *APIError. A request that takes longer
+// than timeout fails with a transient error, unless ctx is done first.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, timeout time.Duration, body, result interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(requestCtx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return requestError(ctx, requestCtx, timeout, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return newAPIError(response)
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		if requestCtx.Err() != nil {
+			return requestError(ctx, requestCtx, timeout, err)
+		}
+		return fmt.Errorf("failed to decode embedding response: %w", err)
+	}
+	return nil
+}
+
+// requestError returns the error of a request that failed with err. The deadline of the
+// caller is not transient, but the timeout of a single request is.
+func requestError(ctx, requestCtx context.Context, timeout time.Duration, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if requestCtx.Err() != nil {
+		return Transient(fmt.Errorf("embedding request timed out after %s", timeout))
+	}
+	return err
+}
+
+// newAPIError reads the error response of a failed request.
+func newAPIError(response *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodySize))
+	apiErr := &APIError{
+		StatusCode: response.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		retryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+
+	// OpenAI reports {"error": {"message": ..., "type": ...}}, and other servers
+	// {"error": "...", "error_type": ...} or {"message": ...}.
+	var payload struct {
+		Error     json.RawMessage `json:"error"`
+		ErrorType string          `json:"error_type"`
+		Message   string          `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return apiErr
+	}
+	var detail struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	}
+	var message string
+	switch {
+	case json.Unmarshal(payload.Error, &message) == nil && message != "":
+		apiErr.Message = message
+		apiErr.Type = payload.ErrorType
+	case json.Unmarshal(payload.Error, &detail) == nil && detail.Message != "":
+		apiErr.Message = detail.Message
+		apiErr.Type = detail.Type
+	case payload.Message != "":
+		apiErr.Message = payload.Message
+	}
+	return apiErr
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package vector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOpenAIEmbedder(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  OpenAIConfig
+		wantErr bool
+	}{
+		{"Defaults", OpenAIConfig{Model: "text-embedding-3-small"}, false},
+		{"Missing Model", OpenAIConfig{}, true},
+		{"Negative Dimensions", OpenAIConfig{Model: "m", Dimensions: -1}, true},
+		{"Negative Timeout", OpenAIConfig{Model: "m", Timeout: -time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedder, err := NewOpenAIEmbedder(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, defaultOpenAIBaseURL, embedder.config.BaseURL)
+			assert.Equal(t, defaultHTTPTimeout, embedder.config.Timeout)
+		})
+	}
+}
+
+func TestOpenAIEmbedder_Embed(t *testing.T) {
+	var received openAIRequest
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/embeddings", r.URL.Path)
+		header = r.Header
+		received = openAIRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		// Respond in reverse order to check that the embeddings are sorted by index.
+		type data struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		}
+		var response struct {
+			Data []data `json:"data"`
+		}
+		for i := len(received.Input) - 1; i >= 0; i-- {
+			response.Data = append(response.Data, data{Index: i, Embedding: []float64{float64(i), float64(len(received.Input[i]))}})
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer server.Close()
+
+	embedder, err := NewOpenAIEmbedder(OpenAIConfig{
+		BaseURL:    server.URL + "/v1/",
+		Model:      "test-model",
+		APIKey:     "secret",
+		Dimensions: 2,
+		InputTypes: map[string]string{"docType": "document", "queryType": "query"},
+		Prefixes:   map[string]string{"queryType": "query: "},
+	})
+	require.NoError(t, err)
+
+	embeddings, err := embedder.Embed(context.Background(), []string{"a", "bb", "ccc"}, "docType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{0, 1}, {1, 2}, {2, 3}}, embeddings)
+	assert.Equal(t, openAIRequest{Model: "test-model", Input: []string{"a", "bb", "ccc"}, EncodingFormat: "float", Dimensions: 2, InputType: "document"}, received)
+	assert.Equal(t, "Bearer secret", header.Get("Authorization"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+
+	embeddings, err = embedder.Embed(context.Background(), []string{"a"}, "queryType")
+	require.NoError(t, err)
+	assert.Equal(t, [][]float64{{0, 8}}, embeddings)
+	assert.Equal(t, []string{"query: a"}, received.Input)
+	assert.Equal(t, "query", received.InputType)
+
+	// Unknown embedding types are sent without an input type or prefix.
+	_, err = embedder.Embed(context.Background(), []string{"a"}, "other")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, received.Input)
+	assert.Empty(t, received.InputType)
+
+	// Nothing is sent for no inputs.
+	received = openAIRequest{}
+	embeddings, err = embedder.Embed(context.Background(), nil, "docType")
+	require.NoError(t, err)
+	assert.Empty(t, embeddings)
+	assert.Empty(t, received.Model)
+
+	// The embedder plugs into a collection.
+	collection, err := NewCollection("test", "docType", "queryType", 100, 10, embedder.EmbeddingFunc())
+	require.NoError(t, err)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "content"}))
+	results, err := collection.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, []string{"query: query"}, received.Input)
+}
+
+func TestOpenAIEmbedder_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		header        map[string]string
+		body          string
+		wantMessage   string
+		wantType      string
+		wantTransient bool
+		wantRetry     time.Duration
+	}{
+		{
+			name:        "OpenAI Error",
+			status:      http.StatusBadRequest,
+			body:        `{"error": {"message": "invalid model", "type": "invalid_request_error"}}`,
+			wantMessage: "invalid model",
+			wantType:    "invalid_request_error",
+		},
+		{
+			name:          "Rate Limited",
+			status:        http.StatusTooManyRequests,
+			header:        map[string]string{"Retry-After": "7"},
+			body:          `{"error": {"message": "slow down", "type": "rate_limit_exceeded"}}`,
+			wantMessage:   "slow down",
+			wantType:      "rate_limit_exceeded",
+			wantTransient: true,
+			wantRetry:     7 * time.Second,
+		},
+		{
+			name:          "Text Embeddings Inference Error",
+			status:        http.StatusRequestEntityTooLarge,
+			body:          `{"error": "batch size 64 > maximum allowed batch size 32", "error_type": "Validation"}`,
+			wantMessage:   "batch size 64 > maximum allowed batch size 32",
+			wantType:      "Validation",
+			wantTransient: false,
+		},
+		{
+			name:          "Plain Text",
+			status:        http.StatusBadGateway,
+			body:          "bad gateway\n",
+			wantMessage:   "bad gateway",
+			wantTransient: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tt.header {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			embedder, err := NewOpenAIEmbedder(OpenAIConfig{BaseURL: server.URL, Model: "m"})
+			require.NoError(t, err)
+			_, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+
+			var apiErr *APIError
+			require.ErrorAs(t, err, &apiErr)
+			assert.Equal(t, tt.status, apiErr.StatusCode)
+			assert.Equal(t, tt.wantMessage, apiErr.Message)
+			assert.Equal(t, tt.wantType, apiErr.Type)
+			assert.Equal(t, tt.wantRetry, apiErr.RetryAfter())
+			assert.Equal(t, tt.wantTransient, IsTransient(err))
+		})
+	}
+}
+
+func TestOpenAIEmbedder_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	embedder, err := NewOpenAIEmbedder(OpenAIConfig{BaseURL: server.URL, Model: "m", Timeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	// The timeout of a request is transient.
+	_, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+	require.Error(t, err)
+	assert.True(t, IsTransient(err))
+
+	// The deadline of the caller is not.
+	embedder.config.Timeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = embedder.Embed(ctx, []string{"a"}, "docType")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, IsTransient(err))
+}
+
+func TestOpenAIEmbedder_InvalidResponse(t *testing.T) {
+	body := `{"data": [{"index": 0, "embedding": [1]}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	embedder, err := NewOpenAIEmbedder(OpenAIConfig{BaseURL: server.URL, Model: "m"})
+	require.NoError(t, err)
+
+	// Too few embeddings.
+	_, err = embedder.Embed(context.Background(), []string{"a", "b"}, "docType")
+	assert.Error(t, err)
+
+	// Duplicate indexes.
+	body = `{"data": [{"index": 1, "embedding": [1]}, {"index": 1, "embedding": [2]}]}`
+	_, err = embedder.Embed(context.Background(), []string{"a", "b"}, "docType")
+	assert.Error(t, err)
+
+	// Not JSON.
+	body = "not json"
+	_, err = embedder.Embed(context.Background(), []string{"a"}, "docType")
+	assert.Error(t, err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-3"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+
+	delay := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.Greater(t, delay, 59*time.Minute)
+	assert.LessOrEqual(t, delay, time.Hour)
+}
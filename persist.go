@@ -0,0 +1,256 @@
+package vector
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// snapshotMagic identifies a file written by Collection.Save.
+const snapshotMagic = "simp-lee/vector collection"
+
+// snapshotVersion is the version of the snapshot format written by Collection.Save.
+// Readers accept every version up to and including this one, so files written by
+// older releases can still be loaded after the format grows.
+const snapshotVersion = 2
+
+func init() {
+	// Register the metadata value types that gob does not know about out of the box,
+	// so that they survive a round trip through a snapshot.
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register([]string{})
+	gob.Register(time.Time{})
+}
+
+// snapshotHeader is written in front of every snapshot.
+type snapshotHeader struct {
+	Magic   string
+	Version int
+}
+
+// collectionSnapshot is the on-disk representation of a collection.
+// New fields may be added in later versions; gob ignores fields that are missing
+// on either side, so older snapshots decode into the zero value of new fields.
+type collectionSnapshot struct {
+	Name                  string
+	Metadata              map[string]interface{}
+	EmbeddingDocumentType string
+	EmbeddingQueryType    string
+	ChunkSize             int
+	ChunkOverlap          int
+	Documents             []*Document
+	Codec                 *codecSnapshot               // Since version 2.
+	Quantization          QuantizationOptions          // Since version 2.
+	MetadataIndexes       map[string]MetadataIndexType // Since version 2.
+	Dimension             int                          // Since version 2.
+	EmbeddingModel        string                       // Since version 2.
+	IndexCodec            *codecSnapshot               // Since version 2. Codec of the IVF index, see IVFConfig.Codec.
+}
+
+// Save writes the collection, including the documents, their metadata and the
+// normalized embeddings of every segment, to w.
+// The embedding function is not saved and has to be provided again when loading.
+// Metadata indexes are saved by key and type, and rebuilt from the documents when loading.
+func (c *Collection) Save(w io.Writer) error {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	return c.writeSnapshot(w)
+}
+
+// writeSnapshot encodes the collection to w.
+// The caller must hold c.documentsLock.
+func (c *Collection) writeSnapshot(w io.Writer) error {
+	snapshot := collectionSnapshot{
+		Name:                  c.Name,
+		Metadata:              c.metadata,
+		EmbeddingDocumentType: c.embeddingDocumentType,
+		EmbeddingQueryType:    c.embeddingQueryType,
+		ChunkSize:             c.ChunkSize,
+		ChunkOverlap:          c.ChunkOverlap,
+		Documents:             make([]*Document, 0, len(c.documents)),
+		Dimension:             c.dimension,
+		EmbeddingModel:        c.EmbeddingModel,
+	}
+	for _, doc := range c.documents {
+		snapshot.Documents = append(snapshot.Documents, doc)
+	}
+	if c.codec != nil {
+		codec, err := marshalCodec(c.codec)
+		if err != nil {
+			return err
+		}
+		snapshot.Codec = codec
+		snapshot.Quantization = c.quantization
+	}
+	snapshot.IndexCodec = c.indexCodec
+	if ivf, ok := c.index.(*IVFIndex); ok {
+		if codec := ivf.trainedCodec(); codec != nil {
+			indexCodec, err := marshalCodec(codec)
+			if err != nil {
+				return err
+			}
+			snapshot.IndexCodec = indexCodec
+		}
+	}
+	if len(c.metadataIndexes) > 0 {
+		snapshot.MetadataIndexes = make(map[string]MetadataIndexType, len(c.metadataIndexes))
+		for key, index := range c.metadataIndexes {
+			snapshot.MetadataIndexes[key] = index.indexType()
+		}
+	}
+	// Sort the documents so that saving the same collection twice produces the same output.
+	sort.Slice(snapshot.Documents, func(i, j int) bool {
+		return snapshot.Documents[i].ID < snapshot.Documents[j].ID
+	})
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion}); err != nil {
+		return fmt.Errorf("failed to write snapshot header: %w", err)
+	}
+	if err := enc.Encode(&snapshot); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadCollection reads a collection written by Collection.Save from r.
+// The embedding function is used for documents and queries added after loading;
+// the stored embeddings are used as they are.
+func LoadCollection(r io.Reader, embeddingFunc EmbeddingFunc) (*Collection, error) {
+	if embeddingFunc == nil {
+		return nil, errors.New("embedding function is required")
+	}
+	return LoadCollectionWithContextFunc(r, embeddingFunc.WithContext())
+}
+
+// LoadCollectionWithContextFunc is like LoadCollection, but takes a context-aware embedding
+// function, as NewCollectionWithContextFunc does.
+func LoadCollectionWithContextFunc(r io.Reader, embeddingFunc ContextEmbeddingFunc) (*Collection, error) {
+	dec := gob.NewDecoder(r)
+
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+	if header.Magic != snapshotMagic {
+		return nil, errors.New("not a collection snapshot")
+	}
+	if header.Version < 1 || header.Version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	var snapshot collectionSnapshot
+	if err := dec.Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	c, err := NewCollectionWithContextFunc(snapshot.Name, snapshot.EmbeddingDocumentType, snapshot.EmbeddingQueryType,
+		snapshot.ChunkSize, snapshot.ChunkOverlap, embeddingFunc)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Metadata != nil {
+		c.metadata = snapshot.Metadata
+	}
+	c.dimension = snapshot.Dimension
+	c.EmbeddingModel = snapshot.EmbeddingModel
+	if snapshot.Codec != nil {
+		if c.codec, err = unmarshalCodec(snapshot.Codec); err != nil {
+			return nil, err
+		}
+		c.quantization = snapshot.Quantization
+	}
+	c.indexCodec = snapshot.IndexCodec
+	for key, indexType := range snapshot.MetadataIndexes {
+		index, err := newMetadataIndex(indexType)
+		if err != nil {
+			return nil, err
+		}
+		if c.metadataIndexes == nil {
+			c.metadataIndexes = make(map[string]metadataIndex)
+		}
+		c.metadataIndexes[key] = index
+	}
+
+	for _, doc := range snapshot.Documents {
+		if doc == nil || doc.ID == "" {
+			return nil, errors.New("snapshot contains a document without ID")
+		}
+		if _, ok := c.documents[doc.ID]; ok {
+			return nil, fmt.Errorf("snapshot contains duplicate document ID %s", doc.ID)
+		}
+		c.insertDocument(doc)
+	}
+
+	return c, nil
+}
+
+// SaveFile writes the collection to the file at path.
+// The file is replaced atomically, so a crash while saving never leaves a partial snapshot behind.
+func (c *Collection) SaveFile(path string) error {
+	c.documentsLock.RLock()
+	defer c.documentsLock.RUnlock()
+
+	return c.writeSnapshotFile(path)
+}
+
+// writeSnapshotFile writes the collection to a temporary file next to path and renames it into place.
+// The directory is flushed after the rename, so that the new snapshot survives a power loss.
+// The caller must hold c.documentsLock.
+func (c *Collection) writeSnapshotFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	// Remove the temporary file if anything goes wrong before the rename.
+	defer os.Remove(tmpPath)
+
+	if err := c.writeSnapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the entries of the directory at path to stable storage.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// LoadCollectionFile reads a collection written by Collection.SaveFile from the file at path.
+func LoadCollectionFile(path string, embeddingFunc EmbeddingFunc) (*Collection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadCollection(f, embeddingFunc)
+}
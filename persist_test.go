@@ -0,0 +1,106 @@
+package vector
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollection_SaveAndLoad(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	collection.metadata["owner"] = "team"
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	doc := &Document{
+		ID:       "1",
+		Metadata: map[string]interface{}{"author": "Alice", "year": 2024, "created": created},
+		Content:  "This is a test document.",
+	}
+	require.NoError(t, collection.AddDocument(doc))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Another document"}))
+
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+
+	loaded, err := LoadCollection(&buf, embeddingFunc.Embed)
+	require.NoError(t, err)
+
+	assert.Equal(t, "test", loaded.Name)
+	assert.Equal(t, "docType", loaded.embeddingDocumentType)
+	assert.Equal(t, "queryType", loaded.embeddingQueryType)
+	assert.Equal(t, 100, loaded.ChunkSize)
+	assert.Equal(t, 10, loaded.ChunkOverlap)
+	assert.Equal(t, "team", loaded.metadata["owner"])
+	assert.Equal(t, 2, loaded.Length())
+
+	loadedDoc, ok := loaded.GetDocument("1")
+	require.True(t, ok)
+	assert.Equal(t, doc.Content, loadedDoc.Content)
+	assert.Equal(t, "Alice", loadedDoc.Metadata["author"])
+	assert.Equal(t, 2024, loadedDoc.Metadata["year"])
+	assert.True(t, created.Equal(loadedDoc.Metadata["created"].(time.Time)))
+	require.Len(t, loadedDoc.Segments, len(doc.Segments))
+	assert.Equal(t, doc.Segments[0].Text, loadedDoc.Segments[0].Text)
+	assert.InDeltaSlice(t, doc.Segments[0].Embedding, loadedDoc.Segments[0].Embedding, 1e-12)
+
+	// The loaded collection can be queried without embedding the documents again.
+	results, err := loaded.GetTopNSimilarDocuments("test query", 2)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func TestCollection_SaveFileAndLoadFile(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+
+	path := filepath.Join(t.TempDir(), "collection.gob")
+	require.NoError(t, collection.SaveFile(path))
+
+	// Saving again replaces the file.
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Another document"}))
+	require.NoError(t, collection.SaveFile(path))
+
+	loaded, err := LoadCollectionFile(path, embeddingFunc.Embed)
+	require.NoError(t, err)
+	assert.Equal(t, 2, loaded.Length())
+
+	_, err = LoadCollectionFile(filepath.Join(t.TempDir(), "missing.gob"), embeddingFunc.Embed)
+	assert.Error(t, err)
+}
+
+func TestLoadCollection_InvalidInput(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+
+	tests := []struct {
+		name   string
+		header snapshotHeader
+	}{
+		{"Wrong Magic", snapshotHeader{Magic: "something else", Version: snapshotVersion}},
+		{"Future Version", snapshotHeader{Magic: snapshotMagic, Version: snapshotVersion + 1}},
+		{"Zero Version", snapshotHeader{Magic: snapshotMagic, Version: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, gob.NewEncoder(&buf).Encode(tt.header))
+			_, err := LoadCollection(&buf, embeddingFunc.Embed)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := LoadCollection(bytes.NewReader([]byte("garbage")), embeddingFunc.Embed)
+	assert.Error(t, err)
+}
@@ -0,0 +1,283 @@
+package vector
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// PQConfig configures a product quantizer.
+// Zero values are replaced by the defaults noted on each field.
+type PQConfig struct {
+	// M is the number of subspaces the embedding is split into. The embedding dimension
+	// must be divisible by M. Each subspace is stored as one byte. Defaults to 8.
+	M int
+	// K is the number of centroids per subspace, at most 256. It is lowered to the number
+	// of training embeddings when training on fewer. Defaults to 256.
+	K int
+	// Iterations is the maximum number of k-means iterations per subspace. Defaults to 20.
+	Iterations int
+	// Seed seeds the centroid initialization, which makes training reproducible.
+	Seed int64
+}
+
+const (
+	defaultPQM          = 8
+	defaultPQK          = 256
+	defaultPQIterations = 20
+)
+
+// ProductQuantizer is a Codec that splits an embedding into M subspaces and stores each
+// subvector as the index of its nearest centroid in that subspace's codebook, so a code
+// takes M bytes regardless of the embedding dimension.
+//
+// Queries are scored with asymmetric distance computation: the query is kept in full
+// precision, the dot product between each query subvector and every centroid of its
+// subspace is computed once into a lookup table, and the score of a code is the sum of
+// M table lookups.
+//
+// A product quantizer can be used on its own, as the codec of a collection with
+// Collection.EnableQuantization, or as the codec of an IVF index (IVF-PQ).
+type ProductQuantizer struct {
+	m          int
+	k          int
+	iterations int
+	seed       int64
+	dim        int
+	codebooks  [][][]float64 // [subspace][centroid][subspace dimension]
+}
+
+// NewProductQuantizer creates an untrained product quantizer.
+func NewProductQuantizer(config PQConfig) (*ProductQuantizer, error) {
+	if config.M < 0 || config.K < 0 || config.Iterations < 0 {
+		return nil, errors.New("PQ parameters must be greater than or equal to zero")
+	}
+	if config.M == 0 {
+		config.M = defaultPQM
+	}
+	if config.K == 0 {
+		config.K = defaultPQK
+	}
+	if config.K > 256 {
+		return nil, errors.New("PQ K must be at most 256")
+	}
+	if config.Iterations == 0 {
+		config.Iterations = defaultPQIterations
+	}
+
+	return &ProductQuantizer{
+		m:          config.M,
+		k:          config.K,
+		iterations: config.Iterations,
+		seed:       config.Seed,
+	}, nil
+}
+
+// Trained reports whether the codebooks have been trained.
+func (pq *ProductQuantizer) Trained() bool {
+	return pq.codebooks != nil
+}
+
+// Train learns a codebook for every subspace with k-means over the embeddings.
+func (pq *ProductQuantizer) Train(embeddings [][]float64) error {
+	if len(embeddings) == 0 {
+		return errors.New("no embeddings to train on")
+	}
+
+	dim := len(embeddings[0])
+	if dim%pq.m != 0 {
+		return errors.New("embedding length must be divisible by the number of subspaces")
+	}
+	for _, embedding := range embeddings {
+		if len(embedding) != dim {
+			return errors.New("all vectors must have the same length")
+		}
+	}
+
+	rng := rand.New(rand.NewSource(pq.seed))
+	subDim := dim / pq.m
+	codebooks := make([][][]float64, pq.m)
+	subvectors := make([][]float64, len(embeddings))
+	for s := range codebooks {
+		for i, embedding := range embeddings {
+			subvectors[i] = embedding[s*subDim : (s+1)*subDim]
+		}
+		codebooks[s] = kmeans(subvectors, min(pq.k, len(subvectors)), pq.iterations, rng)
+	}
+
+	pq.dim = dim
+	pq.codebooks = codebooks
+	return nil
+}
+
+// Encode stores each subvector of the embedding as the index of its nearest centroid.
+func (pq *ProductQuantizer) Encode(embedding []float64) ([]byte, error) {
+	if pq.codebooks == nil {
+		return nil, errors.New("product quantizer is not trained")
+	}
+	if len(embedding) != pq.dim {
+		return nil, errors.New("embedding length does not match the quantizer")
+	}
+
+	subDim := pq.dim / pq.m
+	code := make([]byte, pq.m)
+	for s, codebook := range pq.codebooks {
+		code[s] = byte(nearestCentroid(embedding[s*subDim:(s+1)*subDim], codebook))
+	}
+	return code, nil
+}
+
+// Decode reconstructs an approximation of the embedding by concatenating the centroids in the code.
+func (pq *ProductQuantizer) Decode(code []byte) ([]float64, error) {
+	if err := pq.checkCode(code); err != nil {
+		return nil, err
+	}
+
+	embedding := make([]float64, 0, pq.dim)
+	for s, c := range code {
+		embedding = append(embedding, pq.codebooks[s][c]...)
+	}
+	return embedding, nil
+}
+
+// checkCode verifies that code can be decoded by the quantizer.
+func (pq *ProductQuantizer) checkCode(code []byte) error {
+	if pq.codebooks == nil {
+		return errors.New("product quantizer is not trained")
+	}
+	if len(code) != pq.m {
+		return errors.New("code length does not match the quantizer")
+	}
+	for s, c := range code {
+		if int(c) >= len(pq.codebooks[s]) {
+			return errors.New("code refers to an unknown centroid")
+		}
+	}
+	return nil
+}
+
+// DistanceTable returns the lookup table used for asymmetric distance computation:
+// entry [s][c] is the dot product between subvector s of the query and centroid c of subspace s.
+func (pq *ProductQuantizer) DistanceTable(query []float64) ([][]float64, error) {
+	if pq.codebooks == nil {
+		return nil, errors.New("product quantizer is not trained")
+	}
+	if len(query) != pq.dim {
+		return nil, errors.New("query embedding length does not match embeddings")
+	}
+
+	subDim := pq.dim / pq.m
+	table := make([][]float64, pq.m)
+	for s, codebook := range pq.codebooks {
+		subquery := query[s*subDim : (s+1)*subDim]
+		table[s] = make([]float64, len(codebook))
+		for c, centroid := range codebook {
+			var dotP float64
+			for i := range subquery {
+				dotP += subquery[i] * centroid[i]
+			}
+			table[s][c] = dotP
+		}
+	}
+	return table, nil
+}
+
+// Scorer returns a function that estimates the dot product between the query and an
+// encoded embedding by summing the lookup table entries selected by the code.
+func (pq *ProductQuantizer) Scorer(query []float64) (func(code []byte) float64, error) {
+	table, err := pq.DistanceTable(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(code []byte) float64 {
+		if len(code) != len(table) {
+			return math.Inf(-1)
+		}
+		var score float64
+		for s, c := range code {
+			if int(c) >= len(table[s]) {
+				return math.Inf(-1)
+			}
+			score += table[s][c]
+		}
+		return score
+	}, nil
+}
+
+// MarshalBinary encodes the quantizer settings and codebooks.
+func (pq *ProductQuantizer) MarshalBinary() ([]byte, error) {
+	// Header: m, k, iterations, seed, dim, followed by the number of centroids
+	// per subspace and the centroids themselves.
+	data := make([]byte, 0, 40+8*pq.dim*pq.k)
+	for _, v := range []uint64{uint64(pq.m), uint64(pq.k), uint64(pq.iterations), uint64(pq.seed), uint64(pq.dim)} {
+		data = binary.LittleEndian.AppendUint64(data, v)
+	}
+	for _, codebook := range pq.codebooks {
+		data = binary.LittleEndian.AppendUint64(data, uint64(len(codebook)))
+		for _, centroid := range codebook {
+			for _, v := range centroid {
+				data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+			}
+		}
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes data written by MarshalBinary.
+func (pq *ProductQuantizer) UnmarshalBinary(data []byte) error {
+	errInvalid := errors.New("invalid product quantizer data")
+
+	next := func() (uint64, bool) {
+		if len(data) < 8 {
+			return 0, false
+		}
+		v := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		return v, true
+	}
+
+	var header [5]uint64
+	for i := range header {
+		v, ok := next()
+		if !ok {
+			return errInvalid
+		}
+		header[i] = v
+	}
+	m, k, iterations, seed, dim := int(header[0]), int(header[1]), int(header[2]), int64(header[3]), int(header[4])
+	if m <= 0 || k <= 0 || k > 256 || dim < 0 || dim%m != 0 {
+		return errInvalid
+	}
+
+	var codebooks [][][]float64
+	if dim > 0 {
+		subDim := dim / m
+		codebooks = make([][][]float64, m)
+		for s := range codebooks {
+			n, ok := next()
+			if !ok || n == 0 || n > uint64(k) {
+				return errInvalid
+			}
+			codebooks[s] = make([][]float64, n)
+			for c := range codebooks[s] {
+				centroid := make([]float64, subDim)
+				for i := range centroid {
+					v, ok := next()
+					if !ok {
+						return errInvalid
+					}
+					centroid[i] = math.Float64frombits(v)
+				}
+				codebooks[s][c] = centroid
+			}
+		}
+	}
+	if len(data) != 0 {
+		return errInvalid
+	}
+
+	*pq = ProductQuantizer{m: m, k: k, iterations: iterations, seed: seed, dim: dim, codebooks: codebooks}
+	return nil
+}
@@ -0,0 +1,252 @@
+package vector
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProductQuantizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PQConfig
+		wantErr bool
+	}{
+		{"Defaults", PQConfig{}, false},
+		{"Custom", PQConfig{M: 4, K: 16, Iterations: 5}, false},
+		{"K Too Large", PQConfig{K: 257}, true},
+		{"Negative M", PQConfig{M: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProductQuantizer(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProductQuantizer(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomNormalizedVectors(rng, 500, 16)
+
+	pq, _ := NewProductQuantizer(PQConfig{M: 4, K: 32, Seed: 7})
+	assert.False(t, pq.Trained())
+	_, err := pq.Encode(vectors[0])
+	assert.Error(t, err)
+	_, err = pq.Scorer(vectors[0])
+	assert.Error(t, err)
+
+	assert.Error(t, pq.Train(nil))
+	assert.Error(t, pq.Train([][]float64{{1, 0, 0}}))
+	require.NoError(t, pq.Train(vectors))
+	assert.True(t, pq.Trained())
+
+	query := vectors[1]
+	scorer, err := pq.Scorer(query)
+	require.NoError(t, err)
+
+	for _, v := range vectors[:20] {
+		code, err := pq.Encode(v)
+		require.NoError(t, err)
+		assert.Len(t, code, 4)
+
+		decoded, err := pq.Decode(code)
+		require.NoError(t, err)
+		require.Len(t, decoded, 16)
+
+		// The lookup table score is the dot product with the reconstructed embedding.
+		expected, _ := dotProduct(query, decoded)
+		assert.InDelta(t, expected, scorer(code), 1e-9)
+	}
+
+	_, err = pq.Encode([]float64{1, 0})
+	assert.Error(t, err)
+	_, err = pq.Decode([]byte{0})
+	assert.Error(t, err)
+	_, err = pq.Scorer([]float64{1, 0})
+	assert.Error(t, err)
+}
+
+func TestProductQuantizer_MarshalBinary(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	vectors := randomNormalizedVectors(rng, 100, 8)
+
+	pq, _ := NewProductQuantizer(PQConfig{M: 2, K: 8, Seed: 8})
+
+	// An untrained quantizer round-trips its settings.
+	data, err := pq.MarshalBinary()
+	require.NoError(t, err)
+	var restored ProductQuantizer
+	require.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, pq, &restored)
+
+	require.NoError(t, pq.Train(vectors))
+	data, err = pq.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, pq, &restored)
+
+	assert.Error(t, restored.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, restored.UnmarshalBinary(nil))
+}
+
+func TestIVFIndex_ProductQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(9))
+	vectors := randomNormalizedVectors(rng, 1000, 16)
+	ids := make([]string, len(vectors))
+
+	pq, _ := NewProductQuantizer(PQConfig{M: 8, K: 64, Seed: 9})
+	index, _ := NewIVFIndex(IVFConfig{NList: 8, NProbe: 8, Seed: 9, Codec: pq})
+	for i, v := range vectors {
+		ids[i] = fmt.Sprintf("v%d", i)
+		require.NoError(t, index.Add(ids[i], v))
+	}
+
+	// Training the index trains the codec and replaces the embeddings by codes.
+	require.NoError(t, index.Train())
+	assert.True(t, pq.Trained())
+	for _, list := range index.lists {
+		for _, entry := range list {
+			assert.Nil(t, entry.vector)
+			assert.Len(t, entry.code, 8)
+		}
+	}
+
+	// Embeddings added after training are stored as codes right away.
+	extra := randomNormalizedVectors(rng, 1, 16)[0]
+	require.NoError(t, index.Add("extra", extra))
+	got, err := index.Search(extra, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "extra", got[0].ID)
+	index.Remove("extra")
+
+	var total float64
+	queries := randomNormalizedVectors(rng, 50, 16)
+	for _, q := range queries {
+		expected, _ := getTopNSimilarEmbeddings(q, vectors, ids, 10)
+		got, err := index.Search(q, 40)
+		require.NoError(t, err)
+		total += recallAt(expected, got)
+	}
+	assert.GreaterOrEqual(t, total/float64(len(queries)), 0.8)
+
+	// Retraining works from the codes alone.
+	require.NoError(t, index.Train())
+	assert.Equal(t, len(vectors), index.Len())
+}
+
+func TestCollection_ProductQuantization(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", []string{"first"}, mock.Anything).Return([][]float64{{1.0, 0.0, 0.0, 0.0}}, nil)
+	embeddingFunc.On("Embed", []string{"second"}, mock.Anything).Return([][]float64{{0.0, 0.0, 1.0, 0.0}}, nil)
+	embeddingFunc.On("Embed", []string{"query"}, mock.Anything).Return([][]float64{{0.1, 0.0, 1.0, 0.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+
+	// Codebooks cannot be trained without embeddings.
+	pq, _ := NewProductQuantizer(PQConfig{M: 2, K: 2})
+	assert.Error(t, collection.EnableQuantization(pq, QuantizationOptions{}))
+
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second"}))
+	require.NoError(t, collection.EnableQuantization(pq, QuantizationOptions{}))
+
+	doc, _ := collection.GetDocument("2")
+	assert.Nil(t, doc.Segments[0].Embedding)
+	assert.Len(t, doc.Segments[0].Code, 2)
+
+	results, err := collection.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+
+	// IVF-PQ with the collection's codec.
+	index, _ := NewIVFIndex(IVFConfig{NList: 2, NProbe: 2, Codec: pq})
+	require.NoError(t, collection.SetIndex(index))
+	results, err = collection.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+
+	// The codebooks are saved with the collection.
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollection(&buf, embeddingFunc.Embed)
+	require.NoError(t, err)
+	assert.Equal(t, pq, loaded.codec)
+
+	results, err = loaded.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+}
+
+func TestCollection_IVFProductQuantization_SaveLoad(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	vectors := randomNormalizedVectors(rng, 200, 8)
+	contents := map[string][]float64{"query": vectors[0]}
+	embeddingFunc := func(inputs []string, embeddingType string) ([][]float64, error) {
+		embeddings := make([][]float64, len(inputs))
+		for i, input := range inputs {
+			embeddings[i] = contents[input]
+		}
+		return embeddings, nil
+	}
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc)
+	for i, v := range vectors {
+		content := fmt.Sprintf("content %d", i)
+		contents[content] = v
+		require.NoError(t, collection.AddDocument(&Document{ID: fmt.Sprintf("doc%d", i), Content: content}))
+	}
+
+	// The codec is only used by the index, not by the collection.
+	pq, _ := NewProductQuantizer(PQConfig{M: 4, K: 16, Seed: 1})
+	index, _ := NewIVFIndex(IVFConfig{NList: 4, NProbe: 4, Codec: pq})
+	require.NoError(t, collection.SetIndex(index))
+	expected, err := collection.GetTopNSimilarDocuments("query", 5)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollection(&buf, embeddingFunc)
+	require.NoError(t, err)
+
+	// The codebooks survive a save of the loaded collection before an index is set.
+	buf.Reset()
+	require.NoError(t, loaded.Save(&buf))
+	loaded, err = LoadCollection(&buf, embeddingFunc)
+	require.NoError(t, err)
+
+	// A new untrained codec gets the saved codebooks instead of being trained again.
+	restored, _ := NewProductQuantizer(PQConfig{M: 4, K: 16, Seed: 2})
+	loadedIndex, _ := NewIVFIndex(IVFConfig{NList: 4, NProbe: 4, Codec: restored})
+	require.NoError(t, loaded.SetIndex(loadedIndex))
+	assert.Equal(t, pq, restored)
+
+	results, err := loaded.GetTopNSimilarDocuments("query", 5)
+	require.NoError(t, err)
+	require.Len(t, results, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Document.ID, results[i].Document.ID)
+		assert.InDelta(t, expected[i].Similarity, results[i].Similarity, 1e-9)
+	}
+
+	// A codec of another kind is trained as usual.
+	other, _ := NewIVFIndex(IVFConfig{NList: 4, NProbe: 4, Codec: NewScalarQuantizer(false)})
+	buf.Reset()
+	require.NoError(t, collection.Save(&buf))
+	loaded, err = LoadCollection(&buf, embeddingFunc)
+	require.NoError(t, err)
+	require.NoError(t, loaded.SetIndex(other))
+}
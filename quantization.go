@@ -0,0 +1,478 @@
+package vector
+
+import (
+	"context"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+)
+
+// Codec compresses normalized embeddings into compact codes that can be scored
+// against a query without decompressing them.
+type Codec interface {
+	// Train learns the codec parameters from normalized embeddings.
+	Train(embeddings [][]float64) error
+	// Encode compresses a normalized embedding into a code.
+	Encode(embedding []float64) ([]byte, error)
+	// Decode reconstructs an approximation of the embedding from its code.
+	Decode(code []byte) ([]float64, error)
+	// Scorer returns a function that estimates the similarity between the
+	// normalized query embedding and an encoded embedding.
+	Scorer(query []float64) (func(code []byte) float64, error)
+}
+
+// QuantizationOptions configures how a collection stores and searches quantized embeddings.
+type QuantizationOptions struct {
+	// KeepEmbeddings keeps the full-precision embedding of every segment next to its code.
+	// Without it, Segment.Embedding is dropped once the code is stored, which saves the
+	// memory but rules out rescoring.
+	KeepEmbeddings bool
+	// RescoreFactor enables a rescoring pass when greater than zero: the topN*RescoreFactor
+	// best candidates by code are scored again against their full-precision embeddings.
+	// It requires KeepEmbeddings.
+	RescoreFactor int
+}
+
+// EnableQuantization trains the codec on the embeddings of every segment in the collection,
+// stores a code for each segment and encodes every segment added later.
+// Queries without an index then score the codes instead of the full-precision embeddings.
+func (c *Collection) EnableQuantization(codec Codec, options QuantizationOptions) error {
+	if codec == nil {
+		return errors.New("codec is required")
+	}
+	if options.RescoreFactor < 0 {
+		return errors.New("rescore factor must be greater than or equal to zero")
+	}
+	if options.RescoreFactor > 0 && !options.KeepEmbeddings {
+		return errors.New("rescoring requires keeping the embeddings")
+	}
+
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.codec != nil {
+		return errors.New("quantization is already enabled")
+	}
+
+	var embeddings [][]float64
+	for _, doc := range c.documents {
+		for _, segment := range doc.Segments {
+			if segment.Embedding != nil {
+				embeddings = append(embeddings, segment.Embedding)
+			}
+		}
+	}
+	if err := codec.Train(embeddings); err != nil {
+		return err
+	}
+
+	// Encode every segment before changing anything, so that a failure leaves the collection untouched.
+	codes := make(map[*Segment][]byte)
+	for _, doc := range c.documents {
+		for _, segment := range doc.Segments {
+			if segment.Embedding == nil {
+				continue
+			}
+			code, err := codec.Encode(segment.Embedding)
+			if err != nil {
+				return err
+			}
+			codes[segment] = code
+		}
+	}
+
+	for segment, code := range codes {
+		segment.Code = code
+		if !options.KeepEmbeddings {
+			segment.Embedding = nil
+		}
+	}
+
+	c.codec = codec
+	c.quantization = options
+	return nil
+}
+
+// encodeSegments stores a code for every segment of doc that does not have one yet.
+// Segments that cannot be encoded keep their full-precision embedding.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) encodeSegments(doc *Document) {
+	for i, segment := range doc.Segments {
+		if segment.Code != nil || segment.Embedding == nil {
+			continue
+		}
+		code, err := c.codec.Encode(segment.Embedding)
+		if err != nil {
+			slog.Warn("failed to encode segment", "docID", doc.ID, "segmentIndex", i, "error", err)
+			continue
+		}
+		segment.Code = code
+		if !c.quantization.KeepEmbeddings {
+			segment.Embedding = nil
+		}
+	}
+}
+
+// segmentEmbedding returns the full-precision embedding of the segment,
+// or the embedding decoded from its code if the full-precision one was dropped.
+func (c *Collection) segmentEmbedding(segment *Segment) []float64 {
+	if segment.Embedding != nil || segment.Code == nil || c.codec == nil {
+		return segment.Embedding
+	}
+	embedding, err := c.codec.Decode(segment.Code)
+	if err != nil {
+		return nil
+	}
+	return embedding
+}
+
+// searchQuantized scores the query against the codes of every segment and returns the
+// top N similarities, rescoring the best candidates with full precision if configured.
+// If exact is set, full-precision embeddings are used wherever they were kept.
+// Only the segments of the selected documents are scored; a nil selection selects every document.
+// The caller must hold c.documentsLock.
+func (c *Collection) searchQuantized(ctx context.Context, queryEmbedding []float64, topN int, exact bool, sel *selection) ([]Similarity, error) {
+	normalizedQueryEmbedding, err := normalizeVector(queryEmbedding)
+	if err != nil {
+		return nil, err
+	}
+	scorer, err := c.codec.Scorer(normalizedQueryEmbedding)
+	if err != nil {
+		return nil, err
+	}
+
+	var similarities []Similarity
+	err = c.eachSelectedDocument(ctx, sel, func(doc *Document) {
+		for segmentIndex, segment := range doc.Segments {
+			if !sel.selectsSegment(doc, segment) {
+				continue
+			}
+			var score float64
+			if segment.Code != nil && (!exact || segment.Embedding == nil) {
+				score = scorer(segment.Code)
+			} else if segment.Embedding != nil {
+				dot, err := dotProduct(normalizedQueryEmbedding, segment.Embedding)
+				if err != nil {
+					slog.Warn("error calculating dot product for embedding", "docID", doc.ID, "segmentIndex", segmentIndex, "error", err)
+					continue
+				}
+				score = dot
+			} else {
+				continue
+			}
+			similarities = append(similarities, Similarity{ID: segmentID(doc.ID, segmentIndex), Score: score})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(similarities) == 0 {
+		// The collection is empty, or no document matches the filter.
+		return nil, nil
+	}
+
+	sort.Slice(similarities, func(i, j int) bool {
+		return similarities[i].Score > similarities[j].Score
+	})
+
+	if c.quantization.RescoreFactor > 0 && !exact {
+		candidates := topN * c.quantization.RescoreFactor
+		if candidates < len(similarities) {
+			similarities = similarities[:candidates]
+		}
+		c.rescore(normalizedQueryEmbedding, similarities)
+	}
+
+	if topN > len(similarities) {
+		topN = len(similarities)
+	}
+	return similarities[:topN], nil
+}
+
+// rescore replaces the scores of similarities with the dot product between the normalized
+// query and the full-precision segment embeddings, and sorts them again.
+// Candidates without a full-precision embedding keep their approximate score.
+// The caller must hold c.documentsLock.
+func (c *Collection) rescore(normalizedQueryEmbedding []float64, similarities []Similarity) {
+	for i, sim := range similarities {
+		docID, segmentIndex, err := parseSegmentID(sim.ID)
+		if err != nil {
+			continue
+		}
+		doc, ok := c.documents[docID]
+		if !ok || segmentIndex < 0 || segmentIndex >= len(doc.Segments) {
+			continue
+		}
+		embedding := doc.Segments[segmentIndex].Embedding
+		if embedding == nil {
+			continue
+		}
+		if score, err := dotProduct(normalizedQueryEmbedding, embedding); err == nil {
+			similarities[i].Score = score
+		}
+	}
+
+	sort.Slice(similarities, func(i, j int) bool {
+		return similarities[i].Score > similarities[j].Score
+	})
+}
+
+// codecSnapshot is the on-disk representation of a codec.
+type codecSnapshot struct {
+	Kind  string
+	State []byte
+}
+
+// Codec kinds that can be saved with a collection.
+const (
+	codecKindScalar  = "scalar"
+	codecKindProduct = "product"
+	codecKindBinary  = "binary"
+)
+
+// codecKind returns the kind of codec, or false if it cannot be saved.
+func codecKind(codec Codec) (string, bool) {
+	switch codec.(type) {
+	case *ScalarQuantizer:
+		return codecKindScalar, true
+	case *ProductQuantizer:
+		return codecKindProduct, true
+	case *BinaryQuantizer:
+		return codecKindBinary, true
+	}
+	return "", false
+}
+
+// marshalCodec encodes a codec for a snapshot.
+func marshalCodec(codec Codec) (*codecSnapshot, error) {
+	kind, ok := codecKind(codec)
+	if !ok {
+		return nil, fmt.Errorf("codec %T cannot be saved", codec)
+	}
+
+	var state []byte
+	if marshaler, ok := codec.(encoding.BinaryMarshaler); ok {
+		var err error
+		if state, err = marshaler.MarshalBinary(); err != nil {
+			return nil, err
+		}
+	}
+	return &codecSnapshot{Kind: kind, State: state}, nil
+}
+
+// unmarshalCodec decodes a codec from a snapshot.
+func unmarshalCodec(snapshot *codecSnapshot) (Codec, error) {
+	switch snapshot.Kind {
+	case codecKindScalar:
+		codec := &ScalarQuantizer{}
+		if err := codec.UnmarshalBinary(snapshot.State); err != nil {
+			return nil, err
+		}
+		return codec, nil
+	case codecKindProduct:
+		codec := &ProductQuantizer{}
+		if err := codec.UnmarshalBinary(snapshot.State); err != nil {
+			return nil, err
+		}
+		return codec, nil
+	case codecKindBinary:
+		return &BinaryQuantizer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown codec kind %q", snapshot.Kind)
+	}
+}
+
+// scalarQuantizationLevels is the largest absolute int8 code. The range is kept symmetric
+// so that a value and its negation map to codes of the same magnitude.
+const scalarQuantizationLevels = 127
+
+// ScalarQuantizer is a Codec that stores every dimension of an embedding as an int8,
+// which takes an eighth of the memory of a float64.
+//
+// With a per-dimension scale, each dimension is scaled by the largest absolute value it
+// takes in the training embeddings, and a code is one byte per dimension. Until it is
+// trained, every dimension uses the range [-1, 1] that bounds a normalized embedding.
+// With a per-vector scale, each embedding is scaled by its own largest absolute value,
+// which is stored as a float32 in front of the code. This needs no training.
+type ScalarQuantizer struct {
+	perVector bool
+	scales    []float64
+}
+
+// NewScalarQuantizer creates a scalar quantizer with a per-dimension scale,
+// or a per-vector scale if perVector is set.
+func NewScalarQuantizer(perVector bool) *ScalarQuantizer {
+	return &ScalarQuantizer{perVector: perVector}
+}
+
+// Train learns the per-dimension scales from the embeddings.
+// It does nothing for a per-vector scale or when there are no embeddings.
+func (q *ScalarQuantizer) Train(embeddings [][]float64) error {
+	if q.perVector || len(embeddings) == 0 {
+		return nil
+	}
+
+	dim := len(embeddings[0])
+	maxAbs := make([]float64, dim)
+	for _, embedding := range embeddings {
+		if len(embedding) != dim {
+			return errors.New("all vectors must have the same length")
+		}
+		for i, v := range embedding {
+			maxAbs[i] = math.Max(maxAbs[i], math.Abs(v))
+		}
+	}
+
+	scales := make([]float64, dim)
+	for i, m := range maxAbs {
+		if m == 0 {
+			// A dimension that is always zero still needs a usable scale for later embeddings.
+			m = 1
+		}
+		scales[i] = m / scalarQuantizationLevels
+	}
+	q.scales = scales
+	return nil
+}
+
+// scale returns the per-dimension scale of dimension i.
+func (q *ScalarQuantizer) scale(i int) float64 {
+	if q.scales == nil {
+		return 1.0 / scalarQuantizationLevels
+	}
+	return q.scales[i]
+}
+
+// quantize rounds v/scale to the nearest code and clamps it to the int8 range.
+func quantize(v, scale float64) byte {
+	code := math.Round(v / scale)
+	code = math.Max(-scalarQuantizationLevels, math.Min(scalarQuantizationLevels, code))
+	return byte(int8(code))
+}
+
+// Encode quantizes a normalized embedding to int8 codes.
+func (q *ScalarQuantizer) Encode(embedding []float64) ([]byte, error) {
+	if len(embedding) == 0 {
+		return nil, errors.New("embedding is empty")
+	}
+
+	if q.perVector {
+		var maxAbs float64
+		for _, v := range embedding {
+			maxAbs = math.Max(maxAbs, math.Abs(v))
+		}
+		if maxAbs == 0 {
+			maxAbs = 1
+		}
+		scale := maxAbs / scalarQuantizationLevels
+
+		code := make([]byte, 4+len(embedding))
+		binary.LittleEndian.PutUint32(code, math.Float32bits(float32(scale)))
+		for i, v := range embedding {
+			code[4+i] = quantize(v, scale)
+		}
+		return code, nil
+	}
+
+	if q.scales != nil && len(embedding) != len(q.scales) {
+		return nil, errors.New("embedding length does not match the quantizer")
+	}
+	code := make([]byte, len(embedding))
+	for i, v := range embedding {
+		code[i] = quantize(v, q.scale(i))
+	}
+	return code, nil
+}
+
+// Decode reconstructs an approximation of the embedding from its int8 codes.
+func (q *ScalarQuantizer) Decode(code []byte) ([]float64, error) {
+	if q.perVector {
+		if len(code) < 4 {
+			return nil, errors.New("code is too short")
+		}
+		scale := float64(math.Float32frombits(binary.LittleEndian.Uint32(code)))
+		embedding := make([]float64, len(code)-4)
+		for i, c := range code[4:] {
+			embedding[i] = float64(int8(c)) * scale
+		}
+		return embedding, nil
+	}
+
+	if q.scales != nil && len(code) != len(q.scales) {
+		return nil, errors.New("code length does not match the quantizer")
+	}
+	embedding := make([]float64, len(code))
+	for i, c := range code {
+		embedding[i] = float64(int8(c)) * q.scale(i)
+	}
+	return embedding, nil
+}
+
+// Scorer returns a function that computes the dot product between the query and the
+// embedding represented by a code directly on the int8 values. The query is multiplied
+// by the per-dimension scales once up front.
+func (q *ScalarQuantizer) Scorer(query []float64) (func(code []byte) float64, error) {
+	if q.perVector {
+		return func(code []byte) float64 {
+			if len(code) != 4+len(query) {
+				return math.Inf(-1)
+			}
+			var dotP float64
+			for i, c := range code[4:] {
+				dotP += query[i] * float64(int8(c))
+			}
+			return dotP * float64(math.Float32frombits(binary.LittleEndian.Uint32(code)))
+		}, nil
+	}
+
+	if q.scales != nil && len(query) != len(q.scales) {
+		return nil, errors.New("query embedding length does not match embeddings")
+	}
+	scaled := make([]float64, len(query))
+	for i, v := range query {
+		scaled[i] = v * q.scale(i)
+	}
+	return func(code []byte) float64 {
+		if len(code) != len(scaled) {
+			return math.Inf(-1)
+		}
+		var dotP float64
+		for i, c := range code {
+			dotP += scaled[i] * float64(int8(c))
+		}
+		return dotP
+	}, nil
+}
+
+// MarshalBinary encodes the quantizer settings and learned scales.
+func (q *ScalarQuantizer) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 1+8*len(q.scales))
+	if q.perVector {
+		data[0] = 1
+	}
+	for i, scale := range q.scales {
+		binary.LittleEndian.PutUint64(data[1+8*i:], math.Float64bits(scale))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes data written by MarshalBinary.
+func (q *ScalarQuantizer) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || (len(data)-1)%8 != 0 {
+		return errors.New("invalid scalar quantizer data")
+	}
+	q.perVector = data[0] == 1
+	q.scales = nil
+	if len(data) > 1 {
+		q.scales = make([]float64, (len(data)-1)/8)
+		for i := range q.scales {
+			q.scales[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[1+8*i:]))
+		}
+	}
+	return nil
+}
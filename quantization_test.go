@@ -0,0 +1,166 @@
+package vector
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScalarQuantizer(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	vectors := randomNormalizedVectors(rng, 200, 32)
+
+	tests := []struct {
+		name      string
+		perVector bool
+		train     bool
+		codeLen   int
+	}{
+		{"Per Dimension Untrained", false, false, 32},
+		{"Per Dimension Trained", false, true, 32},
+		{"Per Vector", true, false, 36},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewScalarQuantizer(tt.perVector)
+			if tt.train {
+				require.NoError(t, q.Train(vectors))
+			}
+
+			query := vectors[0]
+			scorer, err := q.Scorer(query)
+			require.NoError(t, err)
+
+			for _, v := range vectors[:20] {
+				code, err := q.Encode(v)
+				require.NoError(t, err)
+				assert.Len(t, code, tt.codeLen)
+
+				decoded, err := q.Decode(code)
+				require.NoError(t, err)
+				assert.InDeltaSlice(t, v, decoded, 0.01)
+
+				expected, _ := dotProduct(query, v)
+				assert.InDelta(t, expected, scorer(code), 0.02)
+			}
+		})
+	}
+
+	_, err := NewScalarQuantizer(false).Encode(nil)
+	assert.Error(t, err)
+
+	trained := NewScalarQuantizer(false)
+	require.NoError(t, trained.Train(vectors))
+	_, err = trained.Encode([]float64{1, 0})
+	assert.Error(t, err)
+	_, err = trained.Scorer([]float64{1, 0})
+	assert.Error(t, err)
+	assert.Error(t, trained.Train([][]float64{{1, 0}, {1, 0, 0}}))
+}
+
+func TestScalarQuantizer_MarshalBinary(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	vectors := randomNormalizedVectors(rng, 50, 8)
+
+	for _, perVector := range []bool{false, true} {
+		q := NewScalarQuantizer(perVector)
+		require.NoError(t, q.Train(vectors))
+
+		data, err := q.MarshalBinary()
+		require.NoError(t, err)
+
+		var restored ScalarQuantizer
+		require.NoError(t, restored.UnmarshalBinary(data))
+		assert.Equal(t, q, &restored)
+	}
+
+	var q ScalarQuantizer
+	assert.Error(t, q.UnmarshalBinary(nil))
+	assert.Error(t, q.UnmarshalBinary([]byte{0, 1, 2}))
+}
+
+func TestCollection_EnableQuantization(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", []string{"first"}, mock.Anything).Return([][]float64{{1.0, 0.0}}, nil)
+	embeddingFunc.On("Embed", []string{"second"}, mock.Anything).Return([][]float64{{0.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"third"}, mock.Anything).Return([][]float64{{1.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"query"}, mock.Anything).Return([][]float64{{0.2, 1.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second"}))
+
+	assert.Error(t, collection.EnableQuantization(nil, QuantizationOptions{}))
+	assert.Error(t, collection.EnableQuantization(NewScalarQuantizer(false), QuantizationOptions{RescoreFactor: 2}))
+	assert.Error(t, collection.EnableQuantization(NewScalarQuantizer(false), QuantizationOptions{KeepEmbeddings: true, RescoreFactor: -1}))
+
+	require.NoError(t, collection.EnableQuantization(NewScalarQuantizer(false), QuantizationOptions{}))
+	assert.Error(t, collection.EnableQuantization(NewScalarQuantizer(false), QuantizationOptions{}))
+
+	// Existing and new segments are stored as codes only.
+	require.NoError(t, collection.AddDocument(&Document{ID: "3", Content: "third"}))
+	for _, id := range []string{"1", "2", "3"} {
+		doc, _ := collection.GetDocument(id)
+		assert.Nil(t, doc.Segments[0].Embedding)
+		assert.Len(t, doc.Segments[0].Code, 2)
+	}
+
+	results, err := collection.GetTopNSimilarDocuments("query", 3)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "2", results[0].Document.ID)
+	assert.Equal(t, "3", results[1].Document.ID)
+	assert.Equal(t, "1", results[2].Document.ID)
+	assert.InDelta(t, 0.98, results[0].Similarity, 0.02)
+
+	// An index is built from the decoded embeddings.
+	index, _ := NewHNSWIndex(HNSWConfig{})
+	require.NoError(t, collection.SetIndex(index))
+	assert.Equal(t, 3, index.Len())
+
+	// The codec is saved with the collection.
+	var buf bytes.Buffer
+	require.NoError(t, collection.Save(&buf))
+	loaded, err := LoadCollection(&buf, embeddingFunc.Embed)
+	require.NoError(t, err)
+	assert.Equal(t, collection.codec, loaded.codec)
+
+	results, err = loaded.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+}
+
+func TestCollection_QuantizationRescore(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", []string{"first"}, mock.Anything).Return([][]float64{{1.0, 0.0}}, nil)
+	embeddingFunc.On("Embed", []string{"second"}, mock.Anything).Return([][]float64{{0.0, 1.0}}, nil)
+	embeddingFunc.On("Embed", []string{"query"}, mock.Anything).Return([][]float64{{0.2, 1.0}}, nil)
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "first"}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "second"}))
+	require.NoError(t, collection.EnableQuantization(NewScalarQuantizer(true), QuantizationOptions{KeepEmbeddings: true, RescoreFactor: 2}))
+
+	doc, _ := collection.GetDocument("1")
+	assert.NotNil(t, doc.Segments[0].Embedding)
+	assert.NotNil(t, doc.Segments[0].Code)
+
+	// Rescored results carry the full-precision similarity.
+	results, err := collection.GetTopNSimilarDocuments("query", 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "2", results[0].Document.ID)
+	expected, _ := normalizeVector([]float64{0.2, 1.0})
+	assert.InDelta(t, expected[1], results[0].Similarity, 1e-12)
+
+	exact, err := collection.GetTopNSimilarDocumentsExact("query", 2)
+	require.NoError(t, err)
+	require.Len(t, exact, 2)
+	assert.InDelta(t, expected[0], exact[1].Similarity, 1e-12)
+}
@@ -0,0 +1,301 @@
+package vector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Splitter splits the content of a document into the texts of its segments.
+// A splitter that implements fmt.Stringer describes its configuration with String, so that
+// EmbedDocuments splits the documents again when it changes.
+type Splitter interface {
+	// Split splits text into chunks of at most chunkSize, where consecutive chunks share up to
+	// chunkOverlap. The splitter defines the unit of both sizes.
+	Split(text string, chunkSize, chunkOverlap int) ([]string, error)
+}
+
+// Chunk is a piece of text split from a document, with metadata of its own.
+type Chunk struct {
+	Text     string
+	Metadata map[string]interface{}
+}
+
+// ChunkSplitter is a Splitter that also returns metadata for every chunk, such as the section
+// of the document it comes from. The metadata is stored in the segment of the chunk.
+type ChunkSplitter interface {
+	Splitter
+	// SplitChunks is like Split, but returns the chunks with their metadata.
+	SplitChunks(text string, chunkSize, chunkOverlap int) ([]Chunk, error)
+}
+
+// checkChunkSizes checks the sizes passed to a Splitter.
+func checkChunkSizes(chunkSize, chunkOverlap int) error {
+	if chunkSize <= 0 {
+		return errors.New("chunk size must be greater than zero")
+	}
+	if chunkOverlap < 0 {
+		return errors.New("chunk overlap must be greater than or equal to zero")
+	}
+	if chunkOverlap >= chunkSize {
+		return errors.New("chunk overlap must be less than chunk size")
+	}
+	return nil
+}
+
+// description identifies a splitter or a tokenizer and its configuration in document fingerprints.
+func description(v interface{}) string {
+	if stringer, ok := v.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// RuneSplitter cuts text every chunkSize runes, regardless of words and sentences.
+// It is the splitter of a collection unless another one is set.
+type RuneSplitter struct{}
+
+// Split splits text into chunks of chunkSize runes, each starting chunkSize-chunkOverlap
+// runes after the previous one.
+func (RuneSplitter) Split(text string, chunkSize, chunkOverlap int) ([]string, error) {
+	if err := checkChunkSizes(chunkSize, chunkOverlap); err != nil {
+		return nil, err
+	}
+
+	var chunks []string
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i += chunkSize - chunkOverlap {
+		end := i + chunkSize
+		if end > len(runes) {
+			end = len(runes)
+		}
+		chunks = append(chunks, string(runes[i:end]))
+	}
+	return chunks, nil
+}
+
+// DefaultSeparators are the separators of a RecursiveSplitter without its own: paragraphs,
+// lines, sentences, in Latin and CJK punctuation, and words.
+var DefaultSeparators = []string{"\n\n", "\n", ". ", "! ", "? ", "; ", "。", "！", "？", "；", " "}
+
+// RecursiveSplitter splits text at the first of its separators that occurs in it, and packs
+// the pieces into chunks of at most chunkSize runes. Pieces that are still too long are split
+// at the next separator, and so on, down to single characters, so that chunks end at the
+// largest boundary that fits: a paragraph, a line, a sentence or a word.
+//
+// Separators stay at the end of the piece before them, and chunks are trimmed of surrounding
+// whitespace. Consecutive chunks share whole pieces of up to chunkOverlap runes.
+type RecursiveSplitter struct {
+	// Separators are tried in order. Defaults to DefaultSeparators.
+	Separators []string
+}
+
+// String describes the separators of the splitter.
+func (s RecursiveSplitter) String() string {
+	return fmt.Sprintf("RecursiveSplitter%q", s.separators())
+}
+
+// separators returns the separators of the splitter, or the default ones.
+func (s RecursiveSplitter) separators() []string {
+	if len(s.Separators) == 0 {
+		return DefaultSeparators
+	}
+	return s.Separators
+}
+
+// Split splits text into chunks of at most chunkSize runes, sharing up to chunkOverlap runes.
+// Only a single character longer than chunkSize, such as a long emoji sequence, makes a longer chunk.
+func (s RecursiveSplitter) Split(text string, chunkSize, chunkOverlap int) ([]string, error) {
+	if err := checkChunkSizes(chunkSize, chunkOverlap); err != nil {
+		return nil, err
+	}
+	if err := checkSeparators(s.separators()); err != nil {
+		return nil, err
+	}
+	return splitRecursive(text, s.separators(), chunkSize, chunkOverlap, runeLength), nil
+}
+
+// TokenSplitter splits text like a RecursiveSplitter, but measures chunkSize and chunkOverlap
+// in the tokens of Tokenizer, so that every segment fits the input limit of the embedding model
+// and uses as much of it as possible.
+type TokenSplitter struct {
+	// Tokenizer counts the tokens of the chunks. It is required, and should be the tokenizer
+	// of the embedding model.
+	Tokenizer Tokenizer
+	// Separators are tried in order. Defaults to DefaultSeparators.
+	Separators []string
+}
+
+// String describes the tokenizer and the separators of the splitter.
+func (s TokenSplitter) String() string {
+	return fmt.Sprintf("TokenSplitter(%s)%q", description(s.Tokenizer), RecursiveSplitter{Separators: s.Separators}.separators())
+}
+
+// Split splits text into chunks of at most chunkSize tokens, sharing up to chunkOverlap tokens.
+func (s TokenSplitter) Split(text string, chunkSize, chunkOverlap int) ([]string, error) {
+	if s.Tokenizer == nil {
+		return nil, errors.New("tokenizer is required")
+	}
+	if err := checkChunkSizes(chunkSize, chunkOverlap); err != nil {
+		return nil, err
+	}
+	separators := RecursiveSplitter{Separators: s.Separators}.separators()
+	if err := checkSeparators(separators); err != nil {
+		return nil, err
+	}
+	length := func(text string) int {
+		return len(s.Tokenizer.Encode(text))
+	}
+	return splitRecursive(text, separators, chunkSize, chunkOverlap, length), nil
+}
+
+// checkSeparators checks the separators of a splitter.
+func checkSeparators(separators []string) error {
+	for _, separator := range separators {
+		if separator == "" {
+			return errors.New("separators cannot be empty")
+		}
+	}
+	return nil
+}
+
+// runeLength is the length of text in runes.
+func runeLength(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// splitRecursive splits text at the first separator that occurs in it and merges the pieces
+// into chunks, splitting the pieces longer than chunkSize with the remaining separators.
+// Once the separators run out, text is split into characters. length measures the pieces.
+func splitRecursive(text string, separators []string, chunkSize, chunkOverlap int, length func(string) int) []string {
+	var pieces []string
+	var rest []string
+	for i, separator := range separators {
+		if strings.Contains(text, separator) {
+			pieces = strings.SplitAfter(text, separator)
+			rest = separators[i+1:]
+			break
+		}
+	}
+	if pieces == nil {
+		return mergePieces(graphemes(text), chunkSize, chunkOverlap, length, strings.TrimSpace)
+	}
+
+	var chunks []string
+	var fitting []string
+	for _, piece := range pieces {
+		if length(piece) <= chunkSize {
+			fitting = append(fitting, piece)
+			continue
+		}
+		chunks = append(chunks, mergePieces(fitting, chunkSize, chunkOverlap, length, strings.TrimSpace)...)
+		fitting = nil
+		chunks = append(chunks, splitRecursive(piece, rest, chunkSize, chunkOverlap, length)...)
+	}
+	return append(chunks, mergePieces(fitting, chunkSize, chunkOverlap, length, strings.TrimSpace)...)
+}
+
+// mergePieces packs consecutive pieces into chunks of at most chunkSize, starting each chunk
+// with the last pieces of the previous one, up to chunkOverlap. Every piece must fit in a chunk.
+// Chunks are cleaned up with trim, and those left empty are dropped.
+//
+// The length of the current chunk is kept as the length last measured for it plus the lengths
+// of the pieces added since, which is exact for runes. Since the tokens of joined pieces may
+// differ from theirs, the chunk is only measured as a whole when that sum exceeds chunkSize and
+// before it is emitted, so that splitting stays linear in the length of text.
+func mergePieces(pieces []string, chunkSize, chunkOverlap int, length func(string) int, trim func(string) string) []string {
+	lengths := make([]int, len(pieces))
+	for i, piece := range pieces {
+		lengths[i] = length(piece)
+	}
+	joined := func(start, end int) string {
+		return strings.Join(pieces[start:end], "")
+	}
+
+	// The current chunk is pieces[start:end].
+	var chunks []string
+	start, end, size := 0, 0, 0
+	for start < len(pieces) {
+		if end < len(pieces) {
+			if end == start || size+lengths[end] <= chunkSize {
+				size += lengths[end]
+				end++
+				continue
+			}
+			if measured := length(joined(start, end+1)); measured <= chunkSize {
+				size = measured
+				end++
+				continue
+			}
+		}
+
+		// Joined pieces may also have more tokens than theirs, so leave out the last pieces
+		// until the chunk fits.
+		for end-start > 1 && length(joined(start, end)) > chunkSize {
+			end--
+		}
+		if chunk := trim(joined(start, end)); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(pieces) {
+			break
+		}
+
+		// Keep the last pieces as the overlap, as long as the next piece still fits.
+		next, overlap := end, 0
+		for next > start+1 && overlap+lengths[next-1] <= chunkOverlap && overlap+lengths[next-1]+lengths[end] <= chunkSize {
+			next--
+			overlap += lengths[next]
+		}
+		start, size = next, overlap
+	}
+	return chunks
+}
+
+// graphemes splits text into user-perceived characters, approximating grapheme clusters:
+// combining marks, variation selectors and zero-width joiner sequences stay with the
+// character before them, and regional indicators are paired into flags.
+func graphemes(text string) []string {
+	var clusters []string
+	start := 0
+	var previous rune
+	regionalIndicators := 0
+	for i, r := range text {
+		if i > 0 && !extendsCluster(previous, r, regionalIndicators) {
+			clusters = append(clusters, text[start:i])
+			start = i
+			regionalIndicators = 0
+		}
+		if isRegionalIndicator(r) {
+			regionalIndicators++
+		}
+		previous = r
+	}
+	if start < len(text) {
+		clusters = append(clusters, text[start:])
+	}
+	return clusters
+}
+
+// zeroWidthJoiner joins emoji into a single character.
+const zeroWidthJoiner = '\u200d'
+
+// extendsCluster reports whether r belongs to the same character as previous, given the
+// number of regional indicators in the current cluster.
+func extendsCluster(previous, r rune, regionalIndicators int) bool {
+	switch {
+	case unicode.Is(unicode.M, r), r == zeroWidthJoiner, previous == zeroWidthJoiner:
+		return true
+	case isRegionalIndicator(r):
+		return regionalIndicators%2 == 1 && isRegionalIndicator(previous)
+	}
+	return false
+}
+
+// isRegionalIndicator reports whether r is one of the letters that form flags in pairs.
+func isRegionalIndicator(r rune) bool {
+	return r >= '\U0001F1E6' && r <= '\U0001F1FF'
+}
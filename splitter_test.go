@@ -0,0 +1,233 @@
+package vector
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuneSplitter(t *testing.T) {
+	chunks, err := RuneSplitter{}.Split("abcdefghij", 4, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abcd", "cdef", "efgh", "ghij", "ij"}, chunks)
+
+	chunks, err = RuneSplitter{}.Split("héllo", 2, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hé", "ll", "o"}, chunks)
+
+	chunks, err = RuneSplitter{}.Split("", 2, 0)
+	require.NoError(t, err)
+	assert.Empty(t, chunks)
+
+	for _, sizes := range [][2]int{{0, 0}, {4, -1}, {4, 4}} {
+		_, err := RuneSplitter{}.Split("text", sizes[0], sizes[1])
+		assert.Error(t, err, "chunk size %d, overlap %d", sizes[0], sizes[1])
+	}
+}
+
+func TestRecursiveSplitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		chunkSize int
+		overlap   int
+		expected  []string
+	}{
+		{
+			name:      "paragraphs",
+			text:      "First paragraph.\n\nSecond paragraph.",
+			chunkSize: 20,
+			expected:  []string{"First paragraph.", "Second paragraph."},
+		},
+		{
+			name:      "short paragraphs are merged",
+			text:      "One.\n\nTwo.\n\nThree.",
+			chunkSize: 20,
+			expected:  []string{"One.\n\nTwo.\n\nThree."},
+		},
+		{
+			name:      "sentences",
+			text:      "One two. Three four. Five six.",
+			chunkSize: 12,
+			expected:  []string{"One two.", "Three four.", "Five six."},
+		},
+		{
+			name:      "long paragraph falls back to sentences",
+			text:      "Short one.\n\nA much longer one. It has two sentences.",
+			chunkSize: 25,
+			expected:  []string{"Short one.", "A much longer one.", "It has two sentences."},
+		},
+		{
+			name:      "words with overlap",
+			text:      "alpha beta gamma delta",
+			chunkSize: 11,
+			overlap:   5,
+			expected:  []string{"alpha beta", "beta gamma", "delta"},
+		},
+		{
+			name:      "characters",
+			text:      "abcdefgh",
+			chunkSize: 3,
+			overlap:   1,
+			expected:  []string{"abc", "cde", "efg", "gh"},
+		},
+		{
+			name:      "combining marks stay with their letter",
+			text:      "ééé",
+			chunkSize: 3,
+			expected:  []string{"é", "é", "é"},
+		},
+		{
+			name:      "flags",
+			text:      "🇫🇷🇩🇪",
+			chunkSize: 3,
+			expected:  []string{"🇫🇷", "🇩🇪"},
+		},
+		{
+			name:      "emoji sequences are not split",
+			text:      "👨‍👩‍👧",
+			chunkSize: 2,
+			expected:  []string{"👨‍👩‍👧"},
+		},
+		{
+			name:      "empty text",
+			text:      "",
+			chunkSize: 10,
+			expected:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := RecursiveSplitter{}.Split(tt.text, tt.chunkSize, tt.overlap)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, chunks)
+		})
+	}
+}
+
+func TestRecursiveSplitter_ChunkSize(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20) + "\n\n" +
+		strings.Repeat("Pack my box with five dozen liquor jugs!\n", 10)
+
+	words := make(map[string]bool)
+	for _, word := range strings.Fields(text) {
+		words[word] = true
+	}
+
+	chunks, err := RecursiveSplitter{}.Split(text, 50, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, runeLength(chunk), 50)
+		// No word is cut.
+		for _, word := range strings.Fields(chunk) {
+			assert.True(t, words[word], "%q in %q", word, chunk)
+		}
+	}
+}
+
+func TestRecursiveSplitter_Long(t *testing.T) {
+	// Chinese sentences are split at their punctuation.
+	chunks, err := RecursiveSplitter{}.Split("这是第一句。这是第二句！这是第三句？", 7, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"这是第一句。", "这是第二句！", "这是第三句？"}, chunks)
+
+	// Text without separators falls back to characters, in linear time.
+	tests := []struct {
+		name      string
+		text      string
+		chunkSize int
+		overlap   int
+	}{
+		{"unspaced chinese", strings.Repeat("中文文本没有空格", 7200), 500, 50},
+		{"no separators", strings.Repeat("x", 200000), 2000, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			chunks, err := RecursiveSplitter{}.Split(tt.text, tt.chunkSize, tt.overlap)
+			require.NoError(t, err)
+			assert.Less(t, time.Since(start), time.Second)
+
+			require.NotEmpty(t, chunks)
+			for _, chunk := range chunks {
+				assert.LessOrEqual(t, runeLength(chunk), tt.chunkSize)
+			}
+			// Chunks are full and share the overlap.
+			first := []rune(chunks[0])
+			assert.Len(t, first, tt.chunkSize)
+			assert.True(t, strings.HasPrefix(chunks[1], string(first[tt.chunkSize-tt.overlap:])))
+		})
+	}
+}
+
+func TestRecursiveSplitter_Separators(t *testing.T) {
+	chunks, err := RecursiveSplitter{Separators: []string{"|"}}.Split("ab|cd|ef", 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ab|", "cd|", "ef"}, chunks)
+
+	_, err = RecursiveSplitter{Separators: []string{""}}.Split("text", 3, 0)
+	assert.Error(t, err)
+	_, err = RecursiveSplitter{}.Split("text", 3, 3)
+	assert.Error(t, err)
+
+	assert.NotEqual(t, RecursiveSplitter{}.String(), RecursiveSplitter{Separators: []string{"|"}}.String())
+	assert.Equal(t, RecursiveSplitter{}.String(), RecursiveSplitter{Separators: DefaultSeparators}.String())
+}
+
+func TestCollection_Splitter(t *testing.T) {
+	embedder := &recordingEmbedder{}
+	collection, err := NewCollectionWithEmbedder("test", "docType", "queryType", 12, 0, embedder)
+	require.NoError(t, err)
+
+	// The rune splitter is the default.
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "One two. Three four."}))
+	assert.Equal(t, []string{"One two. Thr", "ee four."}, embedder.calls[0])
+
+	// Setting another splitter splits the documents again.
+	collection.Splitter = RecursiveSplitter{}
+	require.NoError(t, collection.EmbedDocuments())
+	assert.Equal(t, []string{"One two.", "Three four."}, embedder.calls[1])
+	require.NoError(t, collection.EmbedDocuments())
+	assert.Len(t, embedder.calls, 2)
+
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Five six. Seven."}))
+	assert.Equal(t, []string{"Five six.", "Seven."}, embedder.calls[2])
+}
+
+// wordTokenizer has a token for every word.
+type wordTokenizer struct{}
+
+func (wordTokenizer) Encode(text string) []int   { return make([]int, len(strings.Fields(text))) }
+func (wordTokenizer) Decode(tokens []int) string { return strings.Repeat("word ", len(tokens)) }
+
+func TestTokenSplitter(t *testing.T) {
+	chunks, err := TokenSplitter{Tokenizer: wordTokenizer{}}.Split("one two three four five", 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one two", "two three", "three four", "four five"}, chunks)
+
+	// With a byte-level tokenizer, chunks fit the budget and characters are never cut.
+	tokenizer, err := NewBPETokenizer(testRanks(), "")
+	require.NoError(t, err)
+	text := strings.Repeat("Él está aquí. ", 10)
+	chunks, err = TokenSplitter{Tokenizer: tokenizer}.Split(text, 20, 5)
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, len(tokenizer.Encode(chunk)), 20, chunk)
+		assert.True(t, utf8.ValidString(chunk), chunk)
+	}
+
+	_, err = TokenSplitter{}.Split("text", 2, 0)
+	assert.Error(t, err)
+	_, err = TokenSplitter{Tokenizer: wordTokenizer{}}.Split("text", 2, 2)
+	assert.Error(t, err)
+
+	// The description changes with the tokenizer.
+	assert.NotEqual(t, TokenSplitter{Tokenizer: wordTokenizer{}}.String(), TokenSplitter{Tokenizer: tokenizer}.String())
+}
@@ -0,0 +1,234 @@
+package vector
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer converts text to the tokens of a model and back.
+// Implementations must be safe for concurrent use.
+type Tokenizer interface {
+	// Encode returns the tokens of text.
+	Encode(text string) []int
+	// Decode returns the text of tokens.
+	Decode(tokens []int) string
+}
+
+// DefaultBPEPattern splits text into the pieces that a BPETokenizer encodes separately, as the
+// cl100k_base encoding of OpenAI does: contractions, words with their leading space or
+// punctuation, numbers of up to three digits, punctuation runs, and whitespace.
+const DefaultBPEPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`
+
+// BPETokenizer is a byte pair encoding tokenizer, as used by the models of OpenAI and many
+// others. It encodes every piece of text matched by its pattern by merging adjacent bytes in the
+// order of the ranks of the merged byte sequences. Special tokens are encoded as plain text.
+type BPETokenizer struct {
+	ranks   map[string]int
+	decoder map[int]string
+	pattern *regexp.Regexp
+	id      string // hash of the ranks and the pattern, see String.
+}
+
+// NewBPETokenizer creates a BPETokenizer from the ranks of byte sequences, which are also their
+// tokens, and a pattern that splits text into pieces. An empty pattern means DefaultBPEPattern.
+// Every single byte must have a rank, so that any text can be encoded.
+//
+// The pattern is a Go regular expression, which has no lookahead. Runs of whitespace matched
+// last, by the final alternative, leave their last character to the next piece, as the
+// \s+(?!\S) alternative of the patterns of OpenAI does.
+func NewBPETokenizer(ranks map[string]int, pattern string) (*BPETokenizer, error) {
+	if pattern == "" {
+		pattern = DefaultBPEPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("byte %#02x has no rank", b)
+		}
+	}
+
+	decoder := make(map[int]string, len(ranks))
+	for token, rank := range ranks {
+		if other, ok := decoder[rank]; ok {
+			return nil, fmt.Errorf("rank %d is used by both %q and %q", rank, other, token)
+		}
+		decoder[rank] = token
+	}
+
+	// Identify the tokenizer by its ranks, in rank order, and its pattern.
+	tokens := make([]int, 0, len(decoder))
+	for rank := range decoder {
+		tokens = append(tokens, rank)
+	}
+	sort.Ints(tokens)
+	fields := make([]string, 0, 2*len(tokens)+1)
+	fields = append(fields, pattern)
+	for _, rank := range tokens {
+		fields = append(fields, strconv.Itoa(rank), decoder[rank])
+	}
+
+	return &BPETokenizer{
+		ranks:   ranks,
+		decoder: decoder,
+		pattern: re,
+		id:      hashFields(fields...)[:16],
+	}, nil
+}
+
+// LoadBPETokenizer reads a tiktoken rank file from r and creates a BPETokenizer with the given
+// pattern, or DefaultBPEPattern if it is empty. Every line of the file holds a base64-encoded byte
+// sequence and its rank, separated by a space, as in the cl100k_base.tiktoken file of OpenAI.
+func LoadBPETokenizer(r io.Reader, pattern string) (*BPETokenizer, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a token and a rank", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid token: %w", line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil || rank < 0 {
+			return nil, fmt.Errorf("line %d: invalid rank %q", line, fields[1])
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ranks: %w", err)
+	}
+	return NewBPETokenizer(ranks, pattern)
+}
+
+// LoadBPETokenizerFile is like LoadBPETokenizer, but reads the rank file at path.
+func LoadBPETokenizerFile(path, pattern string) (*BPETokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBPETokenizer(f, pattern)
+}
+
+// String identifies the ranks and the pattern of the tokenizer.
+func (t *BPETokenizer) String() string {
+	return "BPETokenizer(" + t.id + ")"
+}
+
+// Encode returns the tokens of text.
+func (t *BPETokenizer) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range t.pieces(text) {
+		if rank, ok := t.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, t.encodePiece(piece)...)
+	}
+	return tokens
+}
+
+// Decode returns the text of tokens. Unknown tokens are skipped. Tokens that end in the middle
+// of a character decode to invalid UTF-8.
+func (t *BPETokenizer) Decode(tokens []int) string {
+	var buf bytes.Buffer
+	for _, token := range tokens {
+		buf.WriteString(t.decoder[token])
+	}
+	return buf.String()
+}
+
+// pieces splits text with the pattern of the tokenizer.
+func (t *BPETokenizer) pieces(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := t.pattern.FindStringIndex(text)
+		if loc == nil {
+			// The pattern does not match the rest of the text, so encode it as a whole.
+			pieces = append(pieces, text)
+			break
+		}
+		start, end := loc[0], loc[1]
+		if start > 0 {
+			pieces = append(pieces, text[:start])
+		}
+		if end == start {
+			// Skip an empty match, so that the loop moves on.
+			_, size := utf8.DecodeRuneInString(text[start:])
+			end = start + size
+		}
+		match := text[start:end]
+		// Leave the last character of a run of spaces to the next piece, as \s+(?!\S) does.
+		if end < len(text) && isSpaceRun(match) {
+			_, size := utf8.DecodeLastRuneInString(match)
+			if size < len(match) {
+				end -= size
+				match = text[start:end]
+			}
+		}
+		pieces = append(pieces, match)
+		text = text[end:]
+	}
+	return pieces
+}
+
+// isSpaceRun reports whether piece is whitespace without line breaks, which is what the last
+// alternative of DefaultBPEPattern matches.
+func isSpaceRun(piece string) bool {
+	for _, r := range piece {
+		if !unicode.IsSpace(r) || r == '\r' || r == '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePiece encodes a piece of text that is not a token itself, by repeatedly merging the
+// adjacent parts whose concatenation has the lowest rank.
+func (t *BPETokenizer) encodePiece(piece string) []int {
+	parts := make([]string, len(piece))
+	for i := range parts {
+		parts[i] = piece[i : i+1]
+	}
+
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+
+	tokens := make([]int, len(parts))
+	for i, part := range parts {
+		tokens[i] = t.ranks[part]
+	}
+	return tokens
+}
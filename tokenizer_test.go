@@ -0,0 +1,121 @@
+package vector
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRanks returns ranks for every byte, followed by merges in the given order.
+func testRanks(merges ...string) map[string]int {
+	ranks := make(map[string]int, 256+len(merges))
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, merge := range merges {
+		ranks[merge] = 256 + i
+	}
+	return ranks
+}
+
+func TestBPETokenizer(t *testing.T) {
+	tokenizer, err := NewBPETokenizer(testRanks("he", "ll", "llo", "hello", " w", "or", " wor", "ld"), "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		text     string
+		expected []int
+	}{
+		{"hello", []int{259}},
+		{"hell", []int{256, 257}},
+		{"hello world", []int{259, 262, 263}},
+		{"", nil},
+		{"é", []int{0xc3, 0xa9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			tokens := tokenizer.Encode(tt.text)
+			assert.Equal(t, tt.expected, tokens)
+			assert.Equal(t, tt.text, tokenizer.Decode(tokens))
+		})
+	}
+
+	// Unknown tokens are skipped.
+	assert.Equal(t, "hello", tokenizer.Decode([]int{259, 100000}))
+}
+
+func TestBPETokenizer_Pieces(t *testing.T) {
+	tokenizer, err := NewBPETokenizer(testRanks(), "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"don't", []string{"don", "'t"}},
+		{"a   b", []string{"a", "  ", " b"}},
+		{"x   123", []string{"x", "  ", " ", "123"}},
+		{"12345", []string{"123", "45"}},
+		{"end.\n\nNext", []string{"end", ".\n\n", "Next"}},
+		{"line\n  indented", []string{"line", "\n", " ", " indented"}},
+		{"trailing   ", []string{"trailing", "   "}},
+		{"(hi)!", []string{"(hi", ")!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tokenizer.pieces(tt.text))
+		})
+	}
+}
+
+func TestNewBPETokenizer(t *testing.T) {
+	ranks := testRanks()
+	delete(ranks, "a")
+	_, err := NewBPETokenizer(ranks, "")
+	assert.Error(t, err)
+
+	_, err = NewBPETokenizer(testRanks(), "(")
+	assert.Error(t, err)
+
+	ranks = testRanks("ab")
+	ranks["cd"] = ranks["ab"]
+	_, err = NewBPETokenizer(ranks, "")
+	assert.Error(t, err)
+
+	// The description changes with the ranks and the pattern.
+	a, _ := NewBPETokenizer(testRanks("ab"), "")
+	b, _ := NewBPETokenizer(testRanks("ab"), "")
+	c, _ := NewBPETokenizer(testRanks("ba"), "")
+	d, _ := NewBPETokenizer(testRanks("ab"), `\S+|\s+`)
+	assert.Equal(t, a.String(), b.String())
+	assert.NotEqual(t, a.String(), c.String())
+	assert.NotEqual(t, a.String(), d.String())
+}
+
+func TestLoadBPETokenizerFile(t *testing.T) {
+	var file strings.Builder
+	for token, rank := range testRanks("he", "ll", "llo", "hello") {
+		fmt.Fprintf(&file, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	path := filepath.Join(t.TempDir(), "test.tiktoken")
+	require.NoError(t, os.WriteFile(path, []byte(file.String()), 0o644))
+
+	tokenizer, err := LoadBPETokenizerFile(path, "")
+	require.NoError(t, err)
+	assert.Equal(t, []int{259}, tokenizer.Encode("hello"))
+
+	_, err = LoadBPETokenizerFile(filepath.Join(t.TempDir(), "missing"), "")
+	assert.Error(t, err)
+
+	for _, invalid := range []string{"aGk=\n", "!!! 1\n", "aGk= -1\n", "aGk= x\n"} {
+		_, err := LoadBPETokenizer(strings.NewReader(invalid), "")
+		assert.Error(t, err, invalid)
+	}
+}
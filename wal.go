@@ -0,0 +1,302 @@
+package vector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// walOp identifies the kind of mutation stored in a write-ahead log record.
+type walOp uint8
+
+const (
+	walOpAdd walOp = iota + 1
+	walOpUpdate
+	walOpDelete
+	walOpAddBatch
+	walOpPatchMetadata
+)
+
+// walRecord is a single mutation stored in the write-ahead log.
+// Documents are logged together with their segments and embeddings,
+// so replaying the log never calls the embedding function.
+type walRecord struct {
+	Op       walOp
+	Document *Document
+	ID       string
+	// Documents holds the documents of a walOpAddBatch record, which are added together.
+	Documents []*Document
+	// Set and Unset hold the metadata keys of a walOpPatchMetadata record, see PatchMetadata.
+	Set   map[string]interface{}
+	Unset []string
+}
+
+// walFrameHeaderSize is the size of the length and checksum written in front of every record.
+const walFrameHeaderSize = 8
+
+// walMaxRecordSize bounds the payload of a record, so that a corrupt length is not trusted
+// with a huge allocation when the log is replayed.
+const walMaxRecordSize = 1 << 30
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// writeAheadLog is an append-only file of checksummed mutation records.
+// Every record is framed as a little-endian uint32 payload length, a uint32
+// CRC-32C of the payload and the gob-encoded walRecord itself.
+type writeAheadLog struct {
+	f    *os.File
+	size int64 // size of the valid records, where the next one is written.
+	// broken is set when a failed append or reset could not be undone, so that the end of
+	// the file is unknown. Later appends are refused, since they could follow a torn record.
+	broken error
+}
+
+// openWAL opens or creates the write-ahead log at path and returns the records it contains.
+// A torn or corrupt record at the end of the file, left behind by a crash in the middle
+// of a write, is discarded together with everything after it.
+func openWAL(path string) (*writeAheadLog, []walRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	records, validSize, err := readWALRecords(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	// Drop the invalid tail so that new records are appended right after the last valid one.
+	if err := f.Truncate(validSize); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err := f.Seek(validSize, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return &writeAheadLog{f: f, size: validSize}, records, nil
+}
+
+// readWALRecords reads records from r, which holds size bytes, until the end of the file or
+// the first invalid record. It returns the records and the number of bytes they occupy.
+// A record longer than the rest of the file or than walMaxRecordSize is invalid, since its
+// length was torn or corrupted.
+func readWALRecords(r io.Reader, size int64) ([]walRecord, int64, error) {
+	var records []walRecord
+	var offset int64
+	header := make([]byte, walFrameHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, offset, nil
+			}
+			return nil, 0, err
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if length > walMaxRecordSize || int64(length) > size-offset-walFrameHeaderSize {
+			return records, offset, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, offset, nil
+			}
+			return nil, 0, err
+		}
+		if crc32.Checksum(payload, walCRCTable) != checksum {
+			return records, offset, nil
+		}
+
+		var record walRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&record); err != nil {
+			return records, offset, nil
+		}
+
+		records = append(records, record)
+		offset += int64(walFrameHeaderSize) + int64(length)
+	}
+}
+
+// append writes record to the end of the log and flushes it to stable storage.
+// If the record cannot be written or flushed, the log is truncated back to its previous end,
+// so that neither a torn record nor a rejected mutation is left behind.
+func (w *writeAheadLog) append(record walRecord) error {
+	if w.broken != nil {
+		return fmt.Errorf("write-ahead log is unusable after a failed write: %w", w.broken)
+	}
+
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(&record); err != nil {
+		return fmt.Errorf("failed to encode WAL record: %w", err)
+	}
+	if payload.Len() > walMaxRecordSize {
+		return fmt.Errorf("WAL record of %d bytes exceeds the maximum of %d bytes", payload.Len(), walMaxRecordSize)
+	}
+
+	frame := make([]byte, walFrameHeaderSize, walFrameHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(frame[4:8], crc32.Checksum(payload.Bytes(), walCRCTable))
+	frame = append(frame, payload.Bytes()...)
+
+	if _, err := w.f.Write(frame); err != nil {
+		return w.rollback(fmt.Errorf("failed to write WAL record: %w", err))
+	}
+	if err := w.f.Sync(); err != nil {
+		return w.rollback(fmt.Errorf("failed to sync WAL record: %w", err))
+	}
+	w.size += int64(len(frame))
+	return nil
+}
+
+// rollback truncates the log back to its valid records after err, marking the log broken
+// if it cannot.
+func (w *writeAheadLog) rollback(err error) error {
+	if truncateErr := w.truncate(w.size); truncateErr != nil {
+		w.broken = truncateErr
+		return errors.Join(err, fmt.Errorf("failed to truncate WAL: %w", truncateErr))
+	}
+	return err
+}
+
+// reset discards every record in the log.
+func (w *writeAheadLog) reset() error {
+	if err := w.truncate(0); err != nil {
+		w.broken = err
+		return err
+	}
+	w.broken = nil
+	return nil
+}
+
+// truncate cuts the log to size bytes and flushes it, so that new records are appended there.
+func (w *writeAheadLog) truncate(size int64) error {
+	if err := w.f.Truncate(size); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.size = size
+	return nil
+}
+
+// close closes the log file.
+func (w *writeAheadLog) close() error {
+	return w.f.Close()
+}
+
+// OpenWAL enables durable mode: every later AddDocument, AddDocuments, UpdateDocument,
+// UpsertDocument, PatchMetadata, DeleteDocument and EmbedDocuments call writes its
+// mutation to the write-ahead log at path before it is applied to the collection.
+// If the log already contains records, for example after a crash, they are replayed
+// on top of the current documents first. To recover a collection, load the latest
+// snapshot with LoadCollectionFile and then call OpenWAL with the same path as before.
+func (c *Collection) OpenWAL(path string) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.wal != nil {
+		return errors.New("write-ahead log is already open")
+	}
+
+	wal, records, err := openWAL(path)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err := c.applyWALRecord(record); err != nil {
+			wal.close()
+			return err
+		}
+	}
+
+	c.wal = wal
+	return nil
+}
+
+// applyWALRecord applies a replayed record to the collection.
+// Replaying is idempotent, so records that are already part of the snapshot
+// (for example after a crash during Compact) are applied again without harm.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) applyWALRecord(record walRecord) error {
+	switch record.Op {
+	case walOpAdd, walOpUpdate:
+		if record.Document == nil || record.Document.ID == "" {
+			return errors.New("write-ahead log contains a document without ID")
+		}
+		c.replaceDocument(record.Document, record.Document.Segments, record.Document.Fingerprint)
+	case walOpAddBatch:
+		for _, doc := range record.Documents {
+			if doc == nil || doc.ID == "" {
+				return errors.New("write-ahead log contains a document without ID")
+			}
+			c.replaceDocument(doc, doc.Segments, doc.Fingerprint)
+		}
+	case walOpDelete:
+		c.removeDocument(record.ID)
+	case walOpPatchMetadata:
+		c.patchMetadata(record.ID, record.Set, record.Unset)
+	default:
+		return fmt.Errorf("write-ahead log contains an unknown operation %d", record.Op)
+	}
+	return nil
+}
+
+// logMutation appends record to the write-ahead log if durable mode is enabled.
+// The caller must hold c.documentsLock for writing.
+func (c *Collection) logMutation(record walRecord) error {
+	if c.wal == nil {
+		return nil
+	}
+	return c.wal.append(record)
+}
+
+// Compact folds the write-ahead log into a fresh snapshot at snapshotPath and empties the log.
+// The snapshot is written atomically and its directory flushed before the log is truncated,
+// so a crash at any point leaves a snapshot and log that together still contain every mutation.
+func (c *Collection) Compact(snapshotPath string) error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.wal == nil {
+		return errors.New("write-ahead log is not open")
+	}
+
+	if err := c.writeSnapshotFile(snapshotPath); err != nil {
+		return err
+	}
+	return c.wal.reset()
+}
+
+// CloseWAL closes the write-ahead log and disables durable mode.
+func (c *Collection) CloseWAL() error {
+	c.documentsLock.Lock()
+	defer c.documentsLock.Unlock()
+
+	if c.wal == nil {
+		return nil
+	}
+	err := c.wal.close()
+	c.wal = nil
+	return err
+}
@@ -0,0 +1,219 @@
+package vector
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollection_WALReplay(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "collection.wal")
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Another document"}))
+	require.NoError(t, collection.UpdateDocument(&Document{ID: "1", Content: "Updated content.", Metadata: map[string]interface{}{"v": 2, "x": 1}}))
+	require.NoError(t, collection.PatchMetadata("1", map[string]interface{}{"w": 3}, []string{"x"}))
+	require.NoError(t, collection.DeleteDocument("2"))
+
+	// Simulate a crash by dropping the collection without saving a snapshot.
+	require.NoError(t, collection.CloseWAL())
+	calls := len(embeddingFunc.Calls)
+
+	// The metadata patch is logged without the document.
+	wal, records, err := openWAL(walPath)
+	require.NoError(t, err)
+	require.NoError(t, wal.close())
+	require.Len(t, records, 5)
+	assert.Equal(t, walRecord{Op: walOpPatchMetadata, ID: "1", Set: map[string]interface{}{"w": 3}, Unset: []string{"x"}}, records[3])
+
+	recovered, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, recovered.OpenWAL(walPath))
+	defer recovered.CloseWAL()
+
+	assert.Equal(t, 1, recovered.Length())
+	doc, ok := recovered.GetDocument("1")
+	require.True(t, ok)
+	assert.Equal(t, "Updated content.", doc.Content)
+	assert.Equal(t, 2, doc.Metadata["v"])
+	assert.Equal(t, 3, doc.Metadata["w"])
+	assert.NotContains(t, doc.Metadata, "x")
+
+	// Replaying the log does not call the embedding function.
+	assert.Len(t, embeddingFunc.Calls, calls)
+}
+
+func TestCollection_WALCompact(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "collection.wal")
+	snapshotPath := filepath.Join(dir, "collection.gob")
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	assert.Error(t, collection.Compact(snapshotPath))
+
+	require.NoError(t, collection.OpenWAL(walPath))
+	assert.Error(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+	require.NoError(t, collection.Compact(snapshotPath))
+
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Another document"}))
+	require.NoError(t, collection.CloseWAL())
+
+	recovered, err := LoadCollectionFile(snapshotPath, embeddingFunc.Embed)
+	require.NoError(t, err)
+	assert.Equal(t, 1, recovered.Length())
+
+	require.NoError(t, recovered.OpenWAL(walPath))
+	defer recovered.CloseWAL()
+	assert.Equal(t, 2, recovered.Length())
+}
+
+func TestCollection_WALTornTail(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	walPath := filepath.Join(t.TempDir(), "collection.wal")
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+	require.NoError(t, collection.AddDocument(&Document{ID: "2", Content: "Another document"}))
+	require.NoError(t, collection.CloseWAL())
+
+	// Cut the last record in half, as a crash in the middle of a write would.
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(walPath, info.Size()-5))
+
+	recovered, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, recovered.OpenWAL(walPath))
+	assert.Equal(t, 1, recovered.Length())
+
+	// New records are appended after the last valid one.
+	require.NoError(t, recovered.AddDocument(&Document{ID: "3", Content: "Third document"}))
+	require.NoError(t, recovered.CloseWAL())
+
+	again, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, again.OpenWAL(walPath))
+	defer again.CloseWAL()
+	assert.Equal(t, 2, again.Length())
+	_, ok := again.GetDocument("3")
+	assert.True(t, ok)
+}
+
+func TestCollection_WALCorruptRecord(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	walPath := filepath.Join(t.TempDir(), "collection.wal")
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+	require.NoError(t, collection.CloseWAL())
+
+	// Flip a byte in the payload so that the checksum no longer matches.
+	data, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(walPath, data, 0o644))
+
+	recovered, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, recovered.OpenWAL(walPath))
+	defer recovered.CloseWAL()
+	assert.Equal(t, 0, recovered.Length())
+}
+
+func TestCollection_WALCorruptLength(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	walPath := filepath.Join(t.TempDir(), "collection.wal")
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+	require.NoError(t, collection.CloseWAL())
+
+	// Append a header with a garbage length, which must not be allocated.
+	f, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	recovered, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, recovered.OpenWAL(walPath))
+	assert.Equal(t, 1, recovered.Length())
+
+	// The garbage is dropped as a torn tail.
+	require.NoError(t, recovered.AddDocument(&Document{ID: "2", Content: "Another document"}))
+	require.NoError(t, recovered.CloseWAL())
+	again, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, again.OpenWAL(walPath))
+	defer again.CloseWAL()
+	assert.Equal(t, 2, again.Length())
+
+	// A length beyond the maximum is rejected even if the reader could supply it.
+	records, validSize, err := readWALRecords(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0}), 1<<40)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	assert.Zero(t, validSize)
+}
+
+func TestCollection_WALFailedAppend(t *testing.T) {
+	embeddingFunc := new(MockEmbeddingFunc)
+	embeddingFunc.On("Embed", mock.Anything, mock.Anything).Return([][]float64{{1.0, 2.0}}, nil)
+
+	walPath := filepath.Join(t.TempDir(), "collection.wal")
+
+	collection, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, collection.OpenWAL(walPath))
+	require.NoError(t, collection.AddDocument(&Document{ID: "1", Content: "This is a test document."}))
+
+	// A write that fails and cannot be undone rejects the mutation and every later one.
+	require.NoError(t, collection.wal.f.Close())
+	assert.Error(t, collection.AddDocument(&Document{ID: "2", Content: "Another document"}))
+	assert.Error(t, collection.wal.broken)
+	assert.ErrorContains(t, collection.AddDocument(&Document{ID: "3", Content: "A third document"}), "unusable")
+	assert.Equal(t, 1, collection.Length())
+
+	// A write that fails is truncated away, so later records are replayed.
+	f, err := os.OpenFile(walPath, os.O_RDWR, 0o644)
+	require.NoError(t, err)
+	info, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	wal := &writeAheadLog{f: f, size: info.Size()}
+	assert.Error(t, wal.rollback(errors.New("failed to write")))
+	assert.NoError(t, wal.broken)
+	require.NoError(t, wal.append(walRecord{Op: walOpDelete, ID: "1"}))
+	require.NoError(t, wal.close())
+
+	recovered, _ := NewCollection("test", "docType", "queryType", 100, 10, embeddingFunc.Embed)
+	require.NoError(t, recovered.OpenWAL(walPath))
+	defer recovered.CloseWAL()
+	assert.Equal(t, 0, recovered.Length())
+}